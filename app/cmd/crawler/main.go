@@ -2,25 +2,37 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
 	"os"
 	"strings"
-	"time"
 
 	"crawler/app/pkg/assert"
 	assetsHandler "crawler/app/pkg/assets-handler"
 	"crawler/app/pkg/crawler"
 	"crawler/app/pkg/crawler/network"
-	safews "crawler/app/pkg/safe-ws"
 	"crawler/app/pkg/shutdown"
-	"crawler/app/pkg/utils/mapx"
 	"crawler/app/pkg/utils/pathx"
-
-	"github.com/gorilla/websocket"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(runValidate(os.Args[2:]))
+	}
+
+	overrides, args, err := loadOverrides("crawler", os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		os.Exit(2)
+	}
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments %v, only config flags are accepted\n", args)
+		os.Exit(2)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	go shutdown.HandleSIGTERM(cancel)
 	assert.LoadCtxCancel(cancel)
@@ -46,12 +58,21 @@ func main() {
 	assert.NoError(err, "status log file must be created to start the crawler")
 	defer statusLogFile.Close()
 
-	config := assetsHandler.GetConfigFromFile(pathx.FromCwd(os.Getenv("CONFIG_FILE")))
-	proxies := assetsHandler.GetProxiesFromFile(pathx.FromCwd(os.Getenv("PROXIES_FILE")))
+	configPath := pathx.FromCwd(os.Getenv("CONFIG_FILE"))
+	config, err := crawler.ValidateConfigFile(configPath, overrides)
+	if err != nil {
+		// the report is printed as is, as it is unreadable once escaped by the logger
+		fmt.Fprintln(os.Stderr, err)
+	}
+	assert.NoError(err, "config file must be valid", assert.AssertData{"path": configPath})
+	proxiesPath := pathx.FromCwd(os.Getenv("PROXIES_FILE"))
+	proxies := assetsHandler.GetProxiesFromFile(proxiesPath)
+	userAgentsPath := pathx.FromCwd(os.Getenv("USER_AGENTS_FILE"))
 	httpAssets := assetsHandler.HttpAssets{
-		UserAgents: assetsHandler.GetUAsFromFile(pathx.FromCwd(os.Getenv("USER_AGENTS_FILE"))),
+		UserAgents: assetsHandler.GetUAsFromFile(userAgentsPath),
 	}
 
+	network.ConfigureProxies(&config.Http.RateLimit.PerProxy, &config.Http.ProxyHealth)
 	assert.NoError(
 		network.LoadProxies(proxies),
 		"no proxies found in file",
@@ -69,24 +90,23 @@ func main() {
 	genProfilesAmount := network.GenerateAndLoadProfiles()
 	slog.Info(fmt.Sprintf("generated %d network profiles", genProfilesAmount))
 
-	dialer := websocket.Dialer{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
-	}
+	// the vendors rotate the proxies lists, so the pools are reloaded when their files change
+	go network.WatchProxiesFile(ctx, proxiesPath)
+	go network.WatchUserAgentsFile(ctx, userAgentsPath)
+	// the dead proxies of the lists are evicted, and probed until they work again
+	go network.ProbeProxiesLoop(ctx, config)
 
-	safeConns := make([]*safews.SafeConn, len(config.Standard.WebSocket.WsUrls))
-	validFormatHeaders := mapx.StringToStringsList(config.Standard.WebSocket.WsHeaders)
-	for idx, wsUrl := range config.Standard.WebSocket.WsUrls {
-		conn, _, err := dialer.Dial(
-			wsUrl,
-			validFormatHeaders,
-		)
-		assert.NoError(err, "error connecting to websocket")
-		defer conn.Close()
-		conn.SetReadDeadline(time.Time{})
-		slog.Info(fmt.Sprintf("connected to websocket with url: %s", wsUrl))
-		safeConns[idx] = safews.NewSafeConn(conn)
-	}
+	crawler.Start(ctx, config, configPath, overrides, statusLogFile)
+}
 
-	crawler.Start(ctx, &config, safeConns, statusLogFile)
+// loadOverrides returns the config overrides set by the environment variables
+// and by the flags in args, the latter taking precedence, along with the
+// remaining non-flag arguments.
+// The flags errors are printed by the flag package.
+func loadOverrides(name string, args []string) ([]assetsHandler.Override, []string, error) {
+	flagOverrides, rest, err := assetsHandler.FlagOverrides(name, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(assetsHandler.EnvOverrides(os.Environ()), flagOverrides...), rest, nil
 }
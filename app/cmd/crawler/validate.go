@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"crawler/app/pkg/crawler"
+	"crawler/app/pkg/utils/pathx"
+)
+
+// runValidate validates the config file given as argument (CONFIG_FILE if missing),
+// with the env and flags overrides on top of it, without starting the crawl.
+// It returns the exit code of the command.
+func runValidate(args []string) int {
+	overrides, args, err := loadOverrides("crawler validate", args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	} else if err != nil {
+		return 2
+	}
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, "usage: crawler validate [config flags] [config file]")
+		return 2
+	}
+
+	path := os.Getenv("CONFIG_FILE")
+	if len(args) == 1 {
+		path = args[0]
+	}
+	if path == "" {
+		fmt.Fprintln(os.Stderr, "no config file provided, pass it as argument or set CONFIG_FILE")
+		return 2
+	}
+	path = pathx.FromCwd(path)
+
+	if _, err := crawler.ValidateConfigFile(path, overrides); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("config file %s is valid\n", path)
+	return 0
+}
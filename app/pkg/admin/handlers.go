@@ -0,0 +1,172 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	wtypes "crawler/app/pkg/crawler/workers/workers-types"
+)
+
+type stateResponse struct {
+	BatchID    uint16           `json:"batch_id"`
+	HighestID  int              `json:"highest_id"`
+	LastDelays delaysResponse   `json:"last_delays_ms"`
+	Thresholds thresholdsStatus `json:"thresholds"`
+	Manager    managerStatus    `json:"manager"`
+
+	// the outcome of the requests since the beginning of the current status interval
+	Outcome outcomeResponse `json:"outcome"`
+}
+
+type delaysResponse struct {
+	P50 uint32 `json:"p50"`
+	P90 uint32 `json:"p90"`
+	P99 uint32 `json:"p99"`
+	Max uint32 `json:"max"`
+}
+
+type thresholdsStatus struct {
+	Amount           uint32 `json:"amount"`
+	CurrentTimestamp uint32 `json:"current_timestamp"`
+}
+
+type managerStatus struct {
+	Paused bool `json:"paused"`
+}
+
+type outcomeResponse struct {
+	Successes    int `json:"successes"`
+	NotFounds    int `json:"not_founds"`
+	RateLimits   int `json:"rate_limits"`
+	Unauthorized int `json:"unauthorized"`
+	OtherErrs    int `json:"other_errors"`
+	Recovered    int `json:"recovered"`
+	Lost         int `json:"lost"`
+	Malformed    int `json:"malformed"`
+	Crashes      int `json:"worker_crashes"`
+	AckTimeouts  int `json:"ack_timeouts"`
+	AckDropped   int `json:"ack_dropped"`
+	LateItems    int `json:"late_items"`
+	Duplicates   int `json:"duplicates"`
+}
+
+type cookieSessionResponse struct {
+	ID int `json:"id"`
+	wtypes.CookieJarSessionHealth
+}
+
+type highestIDRequest struct {
+	HighestID int `json:"highest_id"`
+}
+
+func (s *Server) handleState(w http.ResponseWriter, req *http.Request) {
+	var resp stateResponse
+
+	s.State.Mu.Lock()
+	resp.BatchID = s.State.BatchID
+	resp.HighestID = s.State.HighestID
+	resp.LastDelays = delaysResponse(s.State.LastDelays)
+	s.State.Mu.Unlock()
+
+	s.Outcome.Mu.Lock()
+	resp.Outcome = outcomeResponse{
+		Successes:    s.Outcome.Successes,
+		NotFounds:    s.Outcome.NotFounds,
+		RateLimits:   s.Outcome.RateLimits,
+		Unauthorized: s.Outcome.Unauthorized,
+		OtherErrs:    s.Outcome.OtherErrs,
+		Recovered:    s.Outcome.Recovered,
+		Lost:         s.Outcome.Lost,
+		Malformed:    s.Outcome.Malformed,
+		Crashes:      s.Outcome.Crashes,
+		AckTimeouts:  s.Outcome.AckTimeouts,
+		AckDropped:   s.Outcome.AckDropped,
+		LateItems:    s.Outcome.LateItems,
+		Duplicates:   s.Outcome.Duplicates,
+	}
+	s.Outcome.Mu.Unlock()
+
+	resp.Thresholds = thresholdsStatus{
+		Amount:           s.ThresholdsController.GetThresholdsAmount(),
+		CurrentTimestamp: s.ThresholdsController.GetCurrentTimestamp(),
+	}
+	resp.Manager = managerStatus{Paused: s.Manager.Paused()}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleCookies(w http.ResponseWriter, req *http.Request) {
+	resp := make([]cookieSessionResponse, len(s.CookieJarSessions))
+	for idx, session := range s.CookieJarSessions {
+		resp[idx] = cookieSessionResponse{ID: idx, CookieJarSessionHealth: session.Health()}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleCookieRefresh(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil || id < 0 || id >= len(s.CookieJarSessions) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no cookie jar session with id %q", req.PathValue("id")))
+		return
+	}
+
+	if !s.CookieJarSessions[id].RequestRefresh() {
+		writeError(w, http.StatusServiceUnavailable, errors.New("too many pending refresh requests"))
+		return
+	}
+	slog.Info(fmt.Sprintf("(Admin): requested refresh of cookie jar session %d", id))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) handleProxies(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, s.Proxies())
+}
+
+func (s *Server) handleCrashes(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, s.Crashes())
+}
+
+func (s *Server) handlePause(w http.ResponseWriter, req *http.Request) {
+	if s.Manager.Pause() {
+		slog.Info("(Admin): workers manager paused")
+	}
+	writeJSON(w, http.StatusOK, managerStatus{Paused: s.Manager.Paused()})
+}
+
+func (s *Server) handleResume(w http.ResponseWriter, req *http.Request) {
+	if s.Manager.Resume() {
+		slog.Info("(Admin): workers manager resumed")
+	}
+	writeJSON(w, http.StatusOK, managerStatus{Paused: s.Manager.Paused()})
+}
+
+func (s *Server) handleHighestID(w http.ResponseWriter, req *http.Request) {
+	var body highestIDRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid body: %w", err))
+		return
+	}
+
+	if err := s.Manager.JumpHighestID(body.HighestID); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	slog.Info(fmt.Sprintf("(Admin): requested highest ID jump to %d", body.HighestID))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Warn(fmt.Sprintf("(Admin): error writing response: %s", err.Error()))
+	}
+}
+
+func writeError(w http.ResponseWriter, statusCode int, err error) {
+	writeJSON(w, statusCode, map[string]string{"error": err.Error()})
+}
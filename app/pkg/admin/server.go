@@ -0,0 +1,90 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"time"
+
+	wtypes "crawler/app/pkg/crawler/workers/workers-types"
+	"crawler/app/pkg/proxypool"
+	"crawler/app/pkg/thresholds"
+)
+
+// ManagerController controls the workers manager loop.
+// The requests are applied by the manager between two batches.
+type ManagerController interface {
+	// Pause returns false if the manager was already paused.
+	Pause() bool
+	// Resume returns false if the manager was not paused.
+	Resume() bool
+	Paused() bool
+	JumpHighestID(highestID int) error
+}
+
+// Server exposes the internals of a running crawler as JSON and allows to control it.
+type Server struct {
+	State                *wtypes.State
+	Outcome              *wtypes.Outcome
+	ThresholdsController *thresholds.ThresholdsController
+	Manager              ManagerController
+	CookieJarSessions    []*wtypes.CookieJarSession
+
+	// returns the state of the proxies of the pool, with their credentials redacted
+	Proxies func() []proxypool.Stats
+
+	// returns the last workers crashes, oldest first
+	Crashes func() []wtypes.WorkerCrash
+
+	// if not empty, the requests must provide it as "Authorization: Bearer <token>"
+	Token string
+}
+
+// Handler returns an http.Handler serving the admin API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /state", s.handleState)
+	mux.HandleFunc("GET /cookies", s.handleCookies)
+	mux.HandleFunc("POST /cookies/{id}/refresh", s.handleCookieRefresh)
+	mux.HandleFunc("GET /proxies", s.handleProxies)
+	mux.HandleFunc("POST /manager/pause", s.handlePause)
+	mux.HandleFunc("POST /manager/resume", s.handleResume)
+	mux.HandleFunc("POST /manager/highest-id", s.handleHighestID)
+	mux.HandleFunc("GET /workers/crashes", s.handleCrashes)
+
+	if s.Token == "" {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		expected := "Bearer " + s.Token
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte(expected)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+			return
+		}
+		mux.ServeHTTP(w, req)
+	})
+}
+
+// Serve serves the admin API on address until ctx is done.
+// It returns an error if the server cannot listen on address.
+func Serve(ctx context.Context, address string, server *Server) error {
+	httpServer := &http.Server{
+		Addr:              address,
+		Handler:           server.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
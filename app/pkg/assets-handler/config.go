@@ -1,7 +1,9 @@
 package assetshandler
 
 import (
+	"fmt"
 	"os"
+	"strings"
 
 	"crawler/app/pkg/assert"
 
@@ -9,35 +11,132 @@ import (
 )
 
 type Config struct {
-	Core     core                     `yaml:"core"`
-	Http     http                     `yaml:"http"`
-	Standard standard                 `yaml:"standard"`
-	Policies []ThresholdsAdjPolicyCfg `yaml:"thresholds_adjustment_policies"`
+	Core        core                     `yaml:"core"`
+	Http        http                     `yaml:"http"`
+	Standard    standard                 `yaml:"standard"`
+	Policies    []ThresholdsAdjPolicyCfg `yaml:"thresholds_adjustment_policies"`
+	Checkpoint  checkpoint               `yaml:"checkpoint"`
+	Metrics     metrics                  `yaml:"metrics"`
+	Status      status                   `yaml:"status"`
+	Admin       admin                    `yaml:"admin"`
+	Scaling     scaling                  `yaml:"scaling"`
+	Supervision supervision              `yaml:"supervision"`
+	Shutdown    shutdown                 `yaml:"shutdown"`
 }
 
 type core struct {
-	ThresholdsInitialAmount uint8       `yaml:"thresholds_initial_amount(max_255)"`
-	ExpMaxThresholdsAmount  uint8       `yaml:"expected_max_thresholds_amount(max_255)"`
-	ThresholdsOffset        uint8       `yaml:"thresholds_offset(max_255)"`
+	ThresholdsInitialAmount uint32      `yaml:"thresholds_initial_amount"`
+	ExpMaxThresholdsAmount  uint32      `yaml:"expected_max_thresholds_amount"`
+	ThresholdsOffset        uint32      `yaml:"thresholds_offset"`
 	BatchLimits             BatchLimits `yaml:"batch_limits"`
 }
 
+// legacyKeys maps the former paths of the renamed keys to their current ones.
+// The former names are still accepted in the config file.
+var legacyKeys = map[string]string{
+	"core.thresholds_initial_amount(max_255)":      "core.thresholds_initial_amount",
+	"core.expected_max_thresholds_amount(max_255)": "core.expected_max_thresholds_amount",
+	"core.thresholds_offset(max_255)":              "core.thresholds_offset",
+}
+
+// removedKeys maps the paths of the removed keys to what replaces them.
+// They are still accepted, with a warning telling how to update the config file.
+var removedKeys = map[string]string{
+	"http.max_rate_limits_per_second": "http.rate_limit",
+	"http.rate_limit_wait_seconds":    "http.rate_limit",
+}
+
+// UnmarshalYAML decodes the core section, accepting the legacy keys.
+func (c *core) UnmarshalYAML(value *yaml.Node) error {
+	// plainCore has no UnmarshalYAML method, avoiding an infinite recursion
+	type plainCore core
+	return renameLegacyKeys(value, "core").Decode((*plainCore)(c))
+}
+
+// renameLegacyKeys returns a copy of the mapping node at path with its legacy keys
+// renamed to their current names. If both names of a key are set, the legacy one
+// is dropped (the validation reports it).
+func renameLegacyKeys(node *yaml.Node, path string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return node
+	}
+
+	renamed := *node
+	renamed.Content = make([]*yaml.Node, 0, len(node.Content))
+	for idx := 0; idx+1 < len(node.Content); idx += 2 {
+		key, value := node.Content[idx], node.Content[idx+1]
+		if current, ok := legacyKeys[joinPath(path, key.Value)]; ok {
+			currentKey := strings.TrimPrefix(current, path+".")
+			if hasKey(node, currentKey) {
+				continue
+			}
+
+			renamedKey := *key
+			renamedKey.Value = currentKey
+			key = &renamedKey
+		}
+		renamed.Content = append(renamed.Content, key, value)
+	}
+	return &renamed
+}
+
 type http struct {
-	Timeout                      int    `yaml:"requests_timeout_seconds"`
-	CookiesSessionsAmount        uint16 `yaml:"cookies_sessions_amount"`
-	CookiesRefreshDelay          int    `yaml:"cookies_refresh_delay"`
-	CrashOnFirstCookieFetchError bool   `yaml:"crash_on_first_cookie_fetch_error"`
-	MaxRetriesPerItem            uint8  `yaml:"max_retries_per_item"`
-	DelayBetweenRetries          uint64 `yaml:"delay_between_retries_milli"`
-	MaxRateLimitsPerSecond       int    `yaml:"max_rate_limits_per_second"`
-	RateLimitWait                int    `yaml:"rate_limit_wait_seconds"`
+	Timeout                      int            `yaml:"requests_timeout_seconds"`
+	CookiesSessionsAmount        uint16         `yaml:"cookies_sessions_amount"`
+	CookiesRefreshDelay          int            `yaml:"cookies_refresh_delay"`
+	CrashOnFirstCookieFetchError bool           `yaml:"crash_on_first_cookie_fetch_error"`
+	MaxRetriesPerItem            uint8          `yaml:"max_retries_per_item"`
+	DelayBetweenRetries          uint64         `yaml:"delay_between_retries_milli"`
+	RateLimit                    RateLimitCfg   `yaml:"rate_limit"`
+	ProxyHealth                  ProxyHealthCfg `yaml:"proxy_health"`
+}
+
+// RateLimitCfg is the budget of the requests sent to the server (see the ratelimit package).
+type RateLimitCfg struct {
+	Global               RateBudget         `yaml:"global"`
+	Endpoints            rateLimitEndpoints `yaml:"endpoints"`
+	MinRequestsPerSecond float64            `yaml:"min_requests_per_second"`
+	DecreaseFactor       float64            `yaml:"decrease_factor"`
+	IncreasePerSecond    float64            `yaml:"increase_per_second"`
+	PerProxy             PerProxyCfg        `yaml:"per_proxy"`
+}
+
+type rateLimitEndpoints struct {
+	ItemUrl RateBudget `yaml:"item_url"`
+}
+
+// RateBudget is the maximum rate of the requests of a budget, 0 being unlimited.
+// A zero Burst takes the requests per second, rounded up.
+type RateBudget struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+}
+
+// PerProxyCfg is the budget of each proxy (see the proxypool package), 0 requests
+// per second being unlimited. A rate limited proxy is put into cooldown for
+// CooldownSeconds, doubled on consecutive rate limits up to MaxCooldownSeconds.
+// A zero CooldownSeconds disables the cooldown.
+type PerProxyCfg struct {
+	RequestsPerSecond  float64 `yaml:"requests_per_second"`
+	Burst              int     `yaml:"burst"`
+	CooldownSeconds    int     `yaml:"cooldown_seconds"`
+	MaxCooldownSeconds int     `yaml:"max_cooldown_seconds"`
+}
+
+// ProxyHealthCfg is the eviction of the broken proxies (see the proxypool package):
+// a proxy is evicted after EvictionFailures consecutive failures, 0 disabling the
+// eviction, and is probed every ProbeIntervalSeconds until it works again.
+type ProxyHealthCfg struct {
+	EvictionFailures     int `yaml:"eviction_failures"`
+	ProbeIntervalSeconds int `yaml:"probe_interval_seconds"`
 }
 
 type standard struct {
 	Urls               urls          `yaml:"urls"`
 	ItemsResponse      itemsResponse `yaml:"items_response"`
 	ItemResponse       itemResponse  `yaml:"item_response"`
-	WebSocket          websocket     `yaml:"websocket"`
+	WebSocket          WebsocketCfg  `yaml:"websocket"`
+	Sinks              sinks         `yaml:"sinks"`
 	SessionCookieNames []string      `yaml:"session_cookie_names"`
 	TimestampFormat    string        `yaml:"timestamp_format"`
 	InitialDelay       int           `yaml:"initial_delay"`
@@ -48,9 +147,61 @@ type ThresholdsAdjPolicyCfg struct {
 	ComputeIncrementExpr string  `yaml:"compute_increment"`
 }
 
+type checkpoint struct {
+	Enabled         bool   `yaml:"enabled"`
+	File            string `yaml:"file"`
+	IntervalSeconds int    `yaml:"interval_seconds"`
+	Resume          bool   `yaml:"resume"`
+	MaxAgeSeconds   int    `yaml:"max_age_seconds"`
+}
+
+type status struct {
+	IntervalSeconds int    `yaml:"interval_seconds"`
+	Format          string `yaml:"format"`
+}
+
+type metrics struct {
+	Enabled bool   `yaml:"enabled"`
+	Address string `yaml:"address"`
+	Path    string `yaml:"path"`
+}
+
+type admin struct {
+	Enabled bool   `yaml:"enabled"`
+	Address string `yaml:"address"`
+	Token   string `yaml:"token"`
+}
+
+type scaling struct {
+	Enabled           bool       `yaml:"enabled"`
+	IntervalSeconds   int        `yaml:"interval_seconds"`
+	TargetUtilization float64    `yaml:"target_utilization"`
+	Thresholds        PoolBounds `yaml:"thresholds"`
+	Subordinate       PoolBounds `yaml:"subordinate"`
+	Backup            PoolBounds `yaml:"backup"`
+}
+
+type supervision struct {
+	MaxRestarts         int `yaml:"max_restarts"`
+	WindowSeconds       int `yaml:"window_seconds"`
+	InitialBackoffMilli int `yaml:"initial_backoff_milli"`
+	MaxBackoffMilli     int `yaml:"max_backoff_milli"`
+}
+
+type shutdown struct {
+	DrainTimeoutSeconds int `yaml:"drain_timeout_seconds"`
+}
+
+// PoolBounds is the range the amount of workers of a pool is scaled within.
+// A zero value takes the default of the pool.
+type PoolBounds struct {
+	MinWorkers uint32 `yaml:"min_workers"`
+	MaxWorkers uint32 `yaml:"max_workers"`
+}
+
 type BatchLimits struct {
 	EnableBatchLimits bool   `yaml:"enable_batch_limits"`
-	MaxBatchSize      uint16 `yaml:"max_batch_size"`
+	MaxBatchSize      uint32 `yaml:"max_batch_size"`
 }
 
 type urls struct {
@@ -73,23 +224,97 @@ type itemResponse struct {
 	TimestampSuffix string `yaml:"timestamp_when_url_suffix"`
 }
 
-type websocket struct {
-	WsUrls    []string               `yaml:"ws_urls"`
-	WsHeaders map[string]interface{} `yaml:"ws_headers"`
+type WebsocketCfg struct {
+	WsUrls                       []string               `yaml:"ws_urls"`
+	WsHeaders                    map[string]interface{} `yaml:"ws_headers"`
+	ReconnectInitialBackoffMilli int                    `yaml:"reconnect_initial_backoff_milli"`
+	ReconnectMaxBackoffMilli     int                    `yaml:"reconnect_max_backoff_milli"`
+	PingIntervalSeconds          int                    `yaml:"ping_interval_seconds"`
+	PongTimeoutSeconds           int                    `yaml:"pong_timeout_seconds"`
+	ReplayBufferSize             int                    `yaml:"replay_buffer_size"`
+	Acks                         websocketAcks          `yaml:"acks"`
+	Ordered                      OrderedDeliveryCfg     `yaml:"ordered"`
+	Routing                      websocketRouting       `yaml:"routing"`
+}
+
+type websocketRouting struct {
+	Mode         string `yaml:"mode"`
+	Field        string `yaml:"field"`
+	VirtualNodes int    `yaml:"virtual_nodes"`
+	Strict       bool   `yaml:"strict"`
+}
+
+type websocketAcks struct {
+	Enabled        bool `yaml:"enabled"`
+	TimeoutSeconds int  `yaml:"timeout_seconds"`
+	MaxAttempts    int  `yaml:"max_attempts"`
+}
+
+type OrderedDeliveryCfg struct {
+	Enabled      bool `yaml:"enabled"`
+	WindowSize   int  `yaml:"window_size"`
+	MaxWaitMilli int  `yaml:"max_wait_milli"`
+}
+
+type sinks struct {
+	Enabled            []string       `yaml:"enabled"`
+	HealthCheckSeconds int            `yaml:"health_check_seconds"`
+	SlowWriteSeconds   int            `yaml:"slow_write_seconds"`
+	Ndjson             NdjsonSinkCfg  `yaml:"ndjson"`
+	Webhook            WebhookSinkCfg `yaml:"webhook"`
+	Dedup              DedupCfg       `yaml:"dedup"`
+}
+
+type DedupCfg struct {
+	Enabled                bool   `yaml:"enabled"`
+	WindowSeconds          int    `yaml:"window_seconds"`
+	MaxIDs                 int    `yaml:"max_ids"`
+	File                   string `yaml:"file"`
+	PersistIntervalSeconds int    `yaml:"persist_interval_seconds"`
+}
+
+type NdjsonSinkCfg struct {
+	Dir                   string `yaml:"dir"`
+	FilePrefix            string `yaml:"file_prefix"`
+	MaxSizeMB             int    `yaml:"max_size_mb"`
+	RotateIntervalSeconds int    `yaml:"rotate_interval_seconds"`
+	Compression           string `yaml:"compression"`
+}
+
+type WebhookSinkCfg struct {
+	Urls                   []string               `yaml:"urls"`
+	Headers                map[string]interface{} `yaml:"headers"`
+	BatchSize              int                    `yaml:"batch_size"`
+	LingerMilli            int                    `yaml:"linger_milli"`
+	QueueSize              int                    `yaml:"queue_size"`
+	TimeoutSeconds         int                    `yaml:"timeout_seconds"`
+	MaxRetries             int                    `yaml:"max_retries"`
+	InitialBackoffMilli    int                    `yaml:"initial_backoff_milli"`
+	MaxBackoffMilli        int                    `yaml:"max_backoff_milli"`
+	ConcurrencyPerEndpoint int                    `yaml:"concurrency_per_endpoint"`
 }
 
 func GetConfigFromFile(path string) Config {
 	assert.Assert(path != "", "config file path cannot be empty", assert.AssertData{"path": path})
 
-	configBytes, err := os.ReadFile(path)
+	config, err := ReadConfigFile(path)
 	assert.NoError(err, "error reading config file", assert.AssertData{"path": path})
 
-	var config Config
+	return *config
+}
+
+// ReadConfigFile is like GetConfigFromFile but returns an error instead of
+// stopping the program, so that it can be used while the crawler is running.
+func ReadConfigFile(path string) (*Config, error) {
+	configBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
 
-	assert.NoError(
-		yaml.Unmarshal(configBytes, &config), "error unmarshalling config file",
-		assert.AssertData{"path": path},
-	)
+	config := DefaultConfig()
+	if err := yaml.Unmarshal(configBytes, &config); err != nil {
+		return nil, fmt.Errorf("error unmarshalling config file: %w", err)
+	}
 
-	return config
+	return &config, nil
 }
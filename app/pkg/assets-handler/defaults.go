@@ -0,0 +1,85 @@
+package assetshandler
+
+// DefaultConfig returns the config the YAML file and the overrides are applied on.
+// The defaults match the ones the components fall back to when a value is not set.
+func DefaultConfig() Config {
+	return Config{
+		Http: http{
+			RateLimit: RateLimitCfg{
+				MinRequestsPerSecond: 1,
+				DecreaseFactor:       0.5,
+				IncreasePerSecond:    1,
+				PerProxy: PerProxyCfg{
+					CooldownSeconds:    10,
+					MaxCooldownSeconds: 120,
+				},
+			},
+			ProxyHealth: ProxyHealthCfg{
+				EvictionFailures:     5,
+				ProbeIntervalSeconds: 60,
+			},
+		},
+		Standard: standard{
+			WebSocket: WebsocketCfg{
+				Acks: websocketAcks{
+					TimeoutSeconds: 10,
+					MaxAttempts:    3,
+				},
+				Ordered: OrderedDeliveryCfg{
+					WindowSize:   1000,
+					MaxWaitMilli: 5000,
+				},
+				Routing: websocketRouting{
+					Mode:         "round_robin",
+					VirtualNodes: 100,
+				},
+			},
+			Sinks: sinks{
+				HealthCheckSeconds: 10,
+				SlowWriteSeconds:   10,
+				Ndjson: NdjsonSinkCfg{
+					FilePrefix:  "items",
+					Compression: "none",
+				},
+				Webhook: WebhookSinkCfg{
+					BatchSize:              100,
+					TimeoutSeconds:         10,
+					ConcurrencyPerEndpoint: 1,
+				},
+				Dedup: DedupCfg{
+					WindowSeconds:          3600,
+					MaxIDs:                 1_000_000,
+					PersistIntervalSeconds: 30,
+				},
+			},
+		},
+		Checkpoint: checkpoint{
+			IntervalSeconds: 1,
+			MaxAgeSeconds:   3600,
+		},
+		Metrics: metrics{
+			Address: ":9090",
+			Path:    "/metrics",
+		},
+		Status: status{
+			IntervalSeconds: 1,
+			Format:          "text",
+		},
+		Admin: admin{
+			Address: "127.0.0.1:9091",
+		},
+		Scaling: scaling{
+			IntervalSeconds:   5,
+			TargetUtilization: 0.7,
+		},
+		Supervision: supervision{
+			MaxRestarts:         10,
+			WindowSeconds:       60,
+			InitialBackoffMilli: 100,
+			MaxBackoffMilli:     10_000,
+		},
+		Shutdown: shutdown{
+			DrainTimeoutSeconds: 30,
+		},
+	}
+}
@@ -0,0 +1,54 @@
+package assetshandler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// WatchFile calls onChange each time the modification time of the file at path
+// changes (checked every pollInterval) or a SIGHUP is received, until ctx is done.
+func WatchFile(ctx context.Context, path string, pollInterval time.Duration, onChange func()) {
+	sighupChan := make(chan os.Signal, 1)
+	signal.Notify(sighupChan, syscall.SIGHUP)
+	defer signal.Stop(sighupChan)
+
+	lastModTime := modTime(path)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sighupChan:
+			slog.Info(fmt.Sprintf("(FileWatcher): SIGHUP received, reloading %s", path))
+			lastModTime = modTime(path)
+			onChange()
+		case <-ticker.C:
+			currentModTime := modTime(path)
+			if currentModTime.IsZero() || currentModTime.Equal(lastModTime) {
+				continue
+			}
+			lastModTime = currentModTime
+
+			slog.Info(fmt.Sprintf("(FileWatcher): %s changed, reloading it", path))
+			onChange()
+		}
+	}
+}
+
+// modTime returns the zero time if the file cannot be stat'ed (e.g. while it is being replaced),
+// in which case the change is detected at the next tick.
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
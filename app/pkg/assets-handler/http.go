@@ -14,11 +14,20 @@ type HttpAssets struct {
 func GetUAsFromFile(path string) []string {
 	assert.Assert(path != "", "user agents file path cannot be empty", assert.AssertData{"path": path})
 
+	userAgents, err := ReadUAsFile(path)
+	assert.NoError(err, "error reading user agents file", assert.AssertData{"path": path})
+
+	return userAgents
+}
+
+// ReadUAsFile is like GetUAsFromFile but returns an error instead of
+// stopping the program, so that it can be used while the crawler is running.
+func ReadUAsFile(path string) ([]string, error) {
 	uaFile, err := os.Open(path)
-	assert.NoError(err, "error opening user agents file", assert.AssertData{"path": path})
-	defer func() {
-		assert.NoError(uaFile.Close(), "error closing user agents file", assert.AssertData{"path": path})
-	}()
+	if err != nil {
+		return nil, err
+	}
+	defer uaFile.Close()
 
 	scanner := bufio.NewScanner(uaFile)
 	var userAgents []string
@@ -29,7 +38,9 @@ func GetUAsFromFile(path string) []string {
 			userAgents = append(userAgents, line)
 		}
 	}
-	assert.NoError(scanner.Err(), "error reading user agents file", assert.AssertData{"path": path})
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
 
-	return userAgents
+	return userAgents, nil
 }
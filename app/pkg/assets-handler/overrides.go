@@ -0,0 +1,151 @@
+package assetshandler
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix of the environment variables overriding the config keys,
+// e.g. CRAWLER_HTTP_REQUESTS_TIMEOUT_SECONDS overrides http.requests_timeout_seconds.
+const EnvPrefix = "CRAWLER_"
+
+// Override sets the value of a config key, on top of the YAML file.
+type Override struct {
+	// the YAML path of the key, e.g. "http.requests_timeout_seconds".
+	// It is empty if Source does not match any key.
+	Path string
+
+	// the value, parsed as YAML unless the key is a string
+	// (e.g. "10", "true", "[a, b]" or "{name: value}")
+	Value string
+
+	// where the override comes from, e.g. "CRAWLER_HTTP_REQUESTS_TIMEOUT_SECONDS"
+	// or "-http.requests_timeout_seconds"
+	Source string
+}
+
+// EnvOverrides returns the overrides set in environ (as returned by os.Environ)
+// with the EnvPrefix prefix. The variables that do not match any key are
+// returned without Path, so that they are reported by the validation.
+func EnvOverrides(environ []string) []Override {
+	keysByEnv := make(map[string]string)
+	for _, path := range overridableKeys() {
+		keysByEnv[EnvName(path)] = path
+	}
+
+	var overrides []Override
+	for _, keyValue := range environ {
+		name, value, _ := strings.Cut(keyValue, "=")
+		if !strings.HasPrefix(name, EnvPrefix) {
+			continue
+		}
+		overrides = append(overrides, Override{Path: keysByEnv[name], Value: value, Source: name})
+	}
+	return overrides
+}
+
+// EnvName returns the environment variable overriding the key at path.
+func EnvName(path string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+}
+
+// FlagOverrides parses the command line flags in args, one per config key
+// (e.g. -http.requests_timeout_seconds=10), and returns the overrides they set
+// in order, along with the remaining non-flag arguments.
+func FlagOverrides(name string, args []string) (overrides []Override, rest []string, err error) {
+	flagSet := flag.NewFlagSet(name, flag.ContinueOnError)
+	for _, path := range overridableKeys() {
+		flagSet.Func(path, "overrides "+path, func(value string) error {
+			overrides = append(overrides, Override{Path: path, Value: value, Source: "-" + path})
+			return nil
+		})
+	}
+
+	if err := flagSet.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	return overrides, flagSet.Args(), nil
+}
+
+// applyOverride sets the value of override in cfg.
+func applyOverride(cfg *Config, override Override) error {
+	if override.Path == "" {
+		return errors.New("does not match any config key")
+	}
+
+	field := reflect.ValueOf(cfg).Elem()
+	for _, key := range strings.Split(override.Path, ".") {
+		field = fieldByKey(field, key)
+		if !field.IsValid() {
+			return errors.New("does not match any config key")
+		}
+	}
+
+	// strings are set as is, so that they do not need to be quoted
+	if field.Kind() == reflect.String {
+		field.SetString(override.Value)
+		return nil
+	}
+
+	value := reflect.New(field.Type())
+	if err := yaml.Unmarshal([]byte(override.Value), value.Interface()); err != nil {
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) && len(typeErr.Errors) > 0 {
+			// the value is a single line, so its line number is meaningless
+			_, msg, _ := strings.Cut(typeErr.Errors[0], ": ")
+			return fmt.Errorf("invalid value %q: %s", override.Value, msg)
+		}
+		return fmt.Errorf("invalid value %q: %w", override.Value, err)
+	}
+	field.Set(value.Elem())
+	return nil
+}
+
+// fieldByKey returns the field of the struct value whose YAML key is key,
+// the zero reflect.Value if there is none.
+func fieldByKey(value reflect.Value, key string) reflect.Value {
+	if value.Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+	for idx := 0; idx < value.NumField(); idx++ {
+		if yamlKey(value.Type().Field(idx)) == key {
+			return value.Field(idx)
+		}
+	}
+	return reflect.Value{}
+}
+
+// overridableKeys returns the paths of all the keys of Config that are not structs,
+// i.e. the keys that can be overridden as a whole: scalars, lists and maps.
+func overridableKeys() []string {
+	var keys []string
+
+	var collect func(t reflect.Type, path string)
+	collect = func(t reflect.Type, path string) {
+		if t.Kind() != reflect.Struct {
+			keys = append(keys, path)
+			return
+		}
+		for idx := 0; idx < t.NumField(); idx++ {
+			field := t.Field(idx)
+			key := yamlKey(field)
+			if key == "" || key == "-" {
+				continue
+			}
+			collect(field.Type, joinPath(path, key))
+		}
+	}
+	collect(reflect.TypeOf(Config{}), "")
+
+	return keys
+}
+
+func yamlKey(field reflect.StructField) string {
+	key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	return key
+}
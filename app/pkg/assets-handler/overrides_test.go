@@ -0,0 +1,103 @@
+package assetshandler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOverrides(t *testing.T) {
+	tests := []struct {
+		name    string
+		environ []string
+		args    []string
+		// the error expected from applying the overrides, empty if none
+		wantErr string
+		check   func(t *testing.T, cfg *Config)
+	}{
+		{
+			name:    "unknown env variable",
+			environ: []string{"CRAWLER_UNKNOWN_KEY=1", "HOME=/root"},
+			wantErr: "does not match any config key",
+		},
+		{
+			name:    "string field set as is",
+			environ: []string{"CRAWLER_STANDARD_TIMESTAMP_FORMAT=2006-01-02: 15h"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Standard.TimestampFormat != "2006-01-02: 15h" {
+					t.Errorf("expected the timestamp format to be set as is, got %q", cfg.Standard.TimestampFormat)
+				}
+			},
+		},
+		{
+			name:    "YAML parsed field",
+			environ: []string{"CRAWLER_CHECKPOINT_ENABLED=true", "CRAWLER_CHECKPOINT_INTERVAL_SECONDS=10"},
+			check: func(t *testing.T, cfg *Config) {
+				if !cfg.Checkpoint.Enabled || cfg.Checkpoint.IntervalSeconds != 10 {
+					t.Errorf("expected the checkpoint enabled every 10 seconds, got %v every %d seconds",
+						cfg.Checkpoint.Enabled, cfg.Checkpoint.IntervalSeconds)
+				}
+			},
+		},
+		{
+			name:    "invalid YAML parsed field",
+			args:    []string{"-checkpoint.interval_seconds=ten"},
+			wantErr: `invalid value "ten"`,
+		},
+		{
+			name: "list and map values",
+			args: []string{
+				"-standard.websocket.ws_urls=[ws://a:1, ws://b:2]",
+				"-standard.websocket.ws_headers={name: value}",
+			},
+			check: func(t *testing.T, cfg *Config) {
+				if wsUrls := cfg.Standard.WebSocket.WsUrls; !reflect.DeepEqual(wsUrls, []string{"ws://a:1", "ws://b:2"}) {
+					t.Errorf("expected 2 websocket urls, got %v", wsUrls)
+				}
+				if wsHeaders := cfg.Standard.WebSocket.WsHeaders; !reflect.DeepEqual(wsHeaders, map[string]interface{}{"name": "value"}) {
+					t.Errorf("expected 1 websocket header, got %v", wsHeaders)
+				}
+			},
+		},
+		{
+			name:    "flags applied after env",
+			environ: []string{"CRAWLER_CHECKPOINT_INTERVAL_SECONDS=5"},
+			args:    []string{"-checkpoint.interval_seconds=7", "-checkpoint.interval_seconds=9"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Checkpoint.IntervalSeconds != 9 {
+					t.Errorf("expected the last flag to win, got %d", cfg.Checkpoint.IntervalSeconds)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			flagOverrides, rest, err := FlagOverrides("crawler", append(test.args, "validate"))
+			if err != nil {
+				t.Fatalf("unexpected error parsing the flags: %v", err)
+			}
+			if !reflect.DeepEqual(rest, []string{"validate"}) {
+				t.Errorf("expected the non-flag arguments to be returned, got %v", rest)
+			}
+
+			cfg := DefaultConfig()
+			var applyErr error
+			for _, override := range append(EnvOverrides(test.environ), flagOverrides...) {
+				if err := applyOverride(&cfg, override); err != nil {
+					applyErr = err
+				}
+			}
+
+			switch {
+			case test.wantErr == "" && applyErr != nil:
+				t.Fatalf("unexpected error applying the overrides: %v", applyErr)
+			case test.wantErr != "" && (applyErr == nil || !strings.Contains(applyErr.Error(), test.wantErr)):
+				t.Fatalf("expected an error containing %q, got %v", test.wantErr, applyErr)
+			}
+			if test.check != nil {
+				test.check(t, &cfg)
+			}
+		})
+	}
+}
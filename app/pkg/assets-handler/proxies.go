@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"slices"
 	"strings"
 
 	"crawler/app/pkg/assert"
@@ -13,18 +14,45 @@ import (
 func GetProxiesFromFile(path string) []*url.URL {
 	assert.Assert(path != "", "proxies file path cannot be empty", assert.AssertData{"path": path})
 
+	proxies, err := ReadProxiesFile(path)
+	assert.NoError(err, "error reading proxies file", assert.AssertData{"path": path})
+
+	return proxies
+}
+
+// the schemes of the proxies given as URLs in the proxies file
+var proxySchemes = []string{"http", "socks5"}
+
+// ReadProxiesFile is like GetProxiesFromFile but returns an error instead of
+// stopping the program, so that it can be used while the crawler is running.
+// Empty lines are skipped.
+//
+// Each line is either an http proxy as ip:port or ip:port:username:password,
+// or a proxy URL as scheme://[username:password@]ip:port, the scheme being one of proxySchemes.
+func ReadProxiesFile(path string) ([]*url.URL, error) {
 	pFile, err := os.Open(path)
-	assert.NoError(err, "error opening proxies file", assert.AssertData{"path": path})
-	defer func() {
-		assert.NoError(pFile.Close(), "error closing proxies file", assert.AssertData{"path": path})
-	}()
+	if err != nil {
+		return nil, err
+	}
+	defer pFile.Close()
 
 	scanner := bufio.NewScanner(pFile)
 	var proxies []*url.URL
 	var proxy string
 
-	for scanner.Scan() {
-		line := scanner.Text()
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.Contains(line, "://") {
+			proxyURL, err := parseProxyURL(line)
+			if err != nil {
+				return nil, fmt.Errorf("invalid proxy URL at line %d: %w", lineNumber, err)
+			}
+			proxies = append(proxies, proxyURL)
+			continue
+		}
 		proxyDataSlice := strings.Split(line, ":")
 
 		switch len(proxyDataSlice) {
@@ -57,19 +85,34 @@ func GetProxiesFromFile(path string) []*url.URL {
 				proxyData["port"],
 			)
 		default:
-			assert.Never(
-				"invalid proxy format. Should be ip:port or ip:port:username:password",
-				assert.AssertData{"proxy": line, "path": path},
+			return nil, fmt.Errorf(
+				"invalid proxy format at line %d. Should be ip:port, ip:port:username:password or a proxy URL",
+				lineNumber,
 			)
 		}
 		proxyURL, err := url.Parse(proxy)
-		assert.NoError(
-			err, "error parsing proxy URL",
-			assert.AssertData{"proxy": proxy, "path": path},
-		)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing proxy URL at line %d: %w", lineNumber, err)
+		}
 		proxies = append(proxies, proxyURL)
 	}
-	assert.NoError(scanner.Err(), "error reading proxies file", assert.AssertData{"path": path})
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
 
-	return proxies
+	return proxies, nil
+}
+
+func parseProxyURL(line string) (*url.URL, error) {
+	proxyURL, err := url.Parse(line)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(proxySchemes, proxyURL.Scheme) {
+		return nil, fmt.Errorf("scheme %q is not supported, should be one of %v", proxyURL.Scheme, proxySchemes)
+	}
+	if proxyURL.Hostname() == "" || proxyURL.Port() == "" {
+		return nil, fmt.Errorf("%s should have a host and a port", proxyURL.Redacted())
+	}
+	return proxyURL, nil
 }
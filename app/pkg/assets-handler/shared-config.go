@@ -0,0 +1,26 @@
+package assetshandler
+
+import "sync/atomic"
+
+// SharedConfig holds the config used by the running crawler.
+// It can be swapped while the crawler is running (see WatchFile),
+// so the users should Load it each time they start working on an item
+// instead of keeping the returned pointer around.
+type SharedConfig struct {
+	current atomic.Pointer[Config]
+}
+
+func NewSharedConfig(cfg *Config) *SharedConfig {
+	sharedConfig := &SharedConfig{}
+	sharedConfig.current.Store(cfg)
+	return sharedConfig
+}
+
+// Load returns the current config. It must not be modified.
+func (sc *SharedConfig) Load() *Config {
+	return sc.current.Load()
+}
+
+func (sc *SharedConfig) Store(cfg *Config) {
+	sc.current.Store(cfg)
+}
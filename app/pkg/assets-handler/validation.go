@@ -0,0 +1,436 @@
+package assetshandler
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigIssue is a problem found in a config file.
+type ConfigIssue struct {
+	// the YAML path of the value, e.g. "standard.websocket.ws_urls[1]"
+	Path string
+
+	// the line of the value in the file. If the value is missing, it is the line
+	// of its closest parent, 0 if no line is known.
+	Line int
+
+	Message string
+
+	// true if the value could not be decoded in its field
+	decodeFailed bool
+}
+
+func (ci ConfigIssue) String() string {
+	var location string
+	if ci.Line > 0 {
+		location = fmt.Sprintf("line %d: ", ci.Line)
+	}
+	if ci.Path != "" {
+		location += ci.Path + ": "
+	}
+	return location + ci.Message
+}
+
+// ConfigValidationError collects all the issues found in a config file.
+type ConfigValidationError struct {
+	File   string
+	Issues []ConfigIssue
+}
+
+func (cve *ConfigValidationError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "config file %s is not valid, %d problem(s) found:", cve.File, len(cve.Issues))
+	for _, issue := range cve.Issues {
+		sb.WriteString("\n  - ")
+		// multi-line messages (e.g. expressions errors) are indented under their issue
+		sb.WriteString(strings.ReplaceAll(issue.String(), "\n", "\n    "))
+	}
+	return sb.String()
+}
+
+// ReportFunc reports a problem of the value at path (e.g. "standard.websocket.ws_urls[1]").
+type ReportFunc func(path string, format string, args ...any)
+
+// ConfigCheck reports the problems of cfg. It is used to validate the settings
+// that are only known by the packages using them (e.g. the sinks kinds).
+type ConfigCheck func(cfg *Config, report ReportFunc)
+
+// ValidateConfigFile loads the config in layers: DefaultConfig, the YAML file at path,
+// then the overrides in order. The result is validated with the checks of this
+// package and the given ones.
+// All the problems found are returned together in a *ConfigValidationError.
+func ValidateConfigFile(path string, overrides []Override, checks ...ConfigCheck) (*Config, error) {
+	configBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ValidateConfig(configBytes, path, overrides, checks...)
+}
+
+// ValidateConfig is like ValidateConfigFile but reads the YAML config from data.
+// file is only used in the error message.
+func ValidateConfig(data []byte, file string, overrides []Override, checks ...ConfigCheck) (*Config, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, &ConfigValidationError{
+			File:   file,
+			Issues: []ConfigIssue{{Message: err.Error()}},
+		}
+	}
+
+	cv := &configValidator{lines: make(map[string]int), overridden: make(map[string]string)}
+	if len(root.Content) > 0 {
+		cv.walk(root.Content[0], reflect.TypeOf(Config{}), "")
+	}
+
+	config := DefaultConfig()
+	if len(root.Content) > 0 {
+		if err := root.Content[0].Decode(&config); err != nil {
+			var typeErr *yaml.TypeError
+			if !errors.As(err, &typeErr) {
+				return nil, &ConfigValidationError{
+					File:   file,
+					Issues: []ConfigIssue{{Message: err.Error()}},
+				}
+			}
+			for _, msg := range typeErr.Errors {
+				cv.issues = append(cv.issues, cv.newDecodeIssue(msg))
+			}
+		}
+	}
+
+	for _, override := range overrides {
+		if override.Path == "" {
+			cv.issues = append(cv.issues, ConfigIssue{Path: override.Source, Message: "does not match any config key"})
+			continue
+		}
+		if err := applyOverride(&config, override); err != nil {
+			cv.issues = append(cv.issues, ConfigIssue{
+				Path:    override.Path,
+				Message: fmt.Sprintf("%s (set by %s)", err.Error(), override.Source),
+			})
+			continue
+		}
+		cv.overridden[override.Path] = override.Source
+	}
+
+	checkConfig(&config, cv.report)
+	for _, check := range checks {
+		check(&config, cv.report)
+	}
+
+	if len(cv.issues) > 0 {
+		slices.SortStableFunc(cv.issues, func(a, b ConfigIssue) int { return a.Line - b.Line })
+		return nil, &ConfigValidationError{File: file, Issues: cv.issues}
+	}
+	return &config, nil
+}
+
+type configValidator struct {
+	// the line of each value found in the file, by path
+	lines map[string]int
+	// the source of the overridden values, by path
+	overridden map[string]string
+	issues     []ConfigIssue
+}
+
+// walk records the line of each value of node and reports the keys
+// that do not match any field of t.
+func (cv *configValidator) walk(node *yaml.Node, t reflect.Type, path string) {
+	if path != "" {
+		cv.lines[path] = node.Line
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		fields := make(map[string]reflect.Type, t.NumField())
+		for idx := 0; idx < t.NumField(); idx++ {
+			field := t.Field(idx)
+			if key := yamlKey(field); key != "" && key != "-" {
+				fields[key] = field.Type
+			}
+		}
+
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			key, value := node.Content[idx], node.Content[idx+1]
+			fieldKey, keyPath := key.Value, joinPath(path, key.Value)
+			if current, ok := legacyKeys[keyPath]; ok {
+				currentKey := current[strings.LastIndex(current, ".")+1:]
+				if hasKey(node, currentKey) {
+					cv.issues = append(cv.issues, ConfigIssue{
+						Path:    keyPath,
+						Line:    key.Line,
+						Message: fmt.Sprintf("former name of %s, which is also set, remove one of them", current),
+					})
+					continue
+				}
+				// the issues of a legacy key refer to its current name
+				fieldKey, keyPath = currentKey, current
+			}
+
+			if replacement, ok := removedKeys[keyPath]; ok {
+				// the former config files are still accepted, the value is ignored
+				slog.Warn("(Config): " + ConfigIssue{
+					Path:    keyPath,
+					Line:    key.Line,
+					Message: fmt.Sprintf("deprecated key, ignored as it has been replaced by %s", replacement),
+				}.String())
+				continue
+			}
+
+			fieldType, ok := fields[fieldKey]
+			if !ok {
+				cv.issues = append(cv.issues, ConfigIssue{
+					Path:    keyPath,
+					Line:    key.Line,
+					Message: "unknown key",
+				})
+				continue
+			}
+			cv.walk(value, fieldType, keyPath)
+		}
+	case t.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		for idx, item := range node.Content {
+			cv.walk(item, t.Elem(), fmt.Sprintf("%s[%d]", path, idx))
+		}
+	case t.Kind() == reflect.Map && node.Kind == yaml.MappingNode:
+		// free form maps (e.g. headers) have no unknown keys
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			cv.lines[joinPath(path, node.Content[idx].Value)] = node.Content[idx+1].Line
+		}
+	}
+}
+
+// hasKey reports whether the mapping node has the given key.
+func hasKey(node *yaml.Node, key string) bool {
+	for idx := 0; idx+1 < len(node.Content); idx += 2 {
+		if node.Content[idx].Value == key {
+			return true
+		}
+	}
+	return false
+}
+
+// report adds an issue for the value at path, unless it could not be decoded
+// (in which case its value is meaningless and the decode issue is enough).
+// If the value has been overridden, the issue refers to the override instead of the file.
+func (cv *configValidator) report(path string, format string, args ...any) {
+	for _, issue := range cv.issues {
+		if issue.Path == path && issue.decodeFailed {
+			return
+		}
+	}
+
+	issue := ConfigIssue{
+		Path:    path,
+		Line:    cv.line(path),
+		Message: fmt.Sprintf(format, args...),
+	}
+	if source, ok := cv.overrideSource(path); ok {
+		// the line of the file is not where the value comes from
+		issue.Line = 0
+		issue.Message += fmt.Sprintf(" (set by %s)", source)
+	}
+	cv.issues = append(cv.issues, issue)
+}
+
+// overrideSource returns the source of the override that set the value at path, if any.
+func (cv *configValidator) overrideSource(path string) (string, bool) {
+	for path != "" {
+		if source, ok := cv.overridden[path]; ok {
+			return source, true
+		}
+		cut := strings.LastIndexAny(path, ".[")
+		if cut < 0 {
+			break
+		}
+		path = path[:cut]
+	}
+	return "", false
+}
+
+// line returns the line of the value at path or, if it is missing, of its closest parent.
+func (cv *configValidator) line(path string) int {
+	for path != "" {
+		if line, ok := cv.lines[path]; ok {
+			return line
+		}
+		cut := strings.LastIndexAny(path, ".[")
+		if cut < 0 {
+			break
+		}
+		path = path[:cut]
+	}
+	return 0
+}
+
+// newDecodeIssue converts a yaml.TypeError message (e.g. "line 3: cannot unmarshal ...").
+// The path of the issue is the deepest one found at its line.
+func (cv *configValidator) newDecodeIssue(msg string) ConfigIssue {
+	issue := ConfigIssue{Message: msg, decodeFailed: true}
+	if _, err := fmt.Sscanf(msg, "line %d:", &issue.Line); err != nil {
+		return issue
+	}
+	_, issue.Message, _ = strings.Cut(msg, ": ")
+
+	for path, line := range cv.lines {
+		if line != issue.Line {
+			continue
+		}
+		if len(path) > len(issue.Path) || (len(path) == len(issue.Path) && path < issue.Path) {
+			issue.Path = path
+		}
+	}
+	return issue
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// checkConfig reports the problems that do not depend on other packages.
+func checkConfig(cfg *Config, report ReportFunc) {
+	if cfg.Core.ThresholdsInitialAmount == 0 {
+		report("core.thresholds_initial_amount", "must be greater than 0")
+	}
+	if cfg.Core.ExpMaxThresholdsAmount == 0 {
+		report("core.expected_max_thresholds_amount", "must be greater than 0")
+	}
+	if cfg.Core.ThresholdsOffset == 0 {
+		report("core.thresholds_offset", "must be greater than 0")
+	}
+	if cfg.Core.BatchLimits.EnableBatchLimits && cfg.Core.BatchLimits.MaxBatchSize == 0 {
+		report("core.batch_limits.max_batch_size", "must be greater than 0 when batch limits are enabled")
+	}
+
+	if cfg.Http.Timeout <= 0 {
+		report("http.requests_timeout_seconds", "must be greater than 0")
+	}
+	if cfg.Http.CookiesSessionsAmount == 0 {
+		report("http.cookies_sessions_amount", "must be greater than 0")
+	}
+	if cfg.Http.CookiesRefreshDelay <= 0 {
+		report("http.cookies_refresh_delay", "must be greater than 0")
+	}
+	for _, budget := range []struct {
+		path   string
+		budget RateBudget
+	}{
+		{"http.rate_limit.global", cfg.Http.RateLimit.Global},
+		{"http.rate_limit.endpoints.item_url", cfg.Http.RateLimit.Endpoints.ItemUrl},
+		{"http.rate_limit.per_proxy", RateBudget{
+			RequestsPerSecond: cfg.Http.RateLimit.PerProxy.RequestsPerSecond,
+			Burst:             cfg.Http.RateLimit.PerProxy.Burst,
+		}},
+	} {
+		if budget.budget.RequestsPerSecond < 0 {
+			report(budget.path+".requests_per_second", "must not be negative")
+		}
+		if budget.budget.Burst < 0 {
+			report(budget.path+".burst", "must not be negative")
+		}
+	}
+	if cfg.Http.RateLimit.MinRequestsPerSecond <= 0 {
+		report("http.rate_limit.min_requests_per_second", "must be greater than 0")
+	}
+	if factor := cfg.Http.RateLimit.DecreaseFactor; factor <= 0 || factor >= 1 {
+		report("http.rate_limit.decrease_factor", "must be in the range (0, 1), %v has been provided", factor)
+	}
+	if cfg.Http.RateLimit.IncreasePerSecond <= 0 {
+		report("http.rate_limit.increase_per_second", "must be greater than 0")
+	}
+	if perProxy := cfg.Http.RateLimit.PerProxy; perProxy.CooldownSeconds < 0 {
+		report("http.rate_limit.per_proxy.cooldown_seconds", "must not be negative")
+	} else if perProxy.MaxCooldownSeconds < perProxy.CooldownSeconds {
+		report("http.rate_limit.per_proxy.max_cooldown_seconds", "must not be less than cooldown_seconds")
+	}
+	if cfg.Http.ProxyHealth.EvictionFailures < 0 {
+		report("http.proxy_health.eviction_failures", "must not be negative")
+	}
+	if cfg.Http.ProxyHealth.ProbeIntervalSeconds <= 0 {
+		report("http.proxy_health.probe_interval_seconds", "must be greater than 0")
+	}
+
+	CheckURL(report, "standard.urls.base_url", cfg.Standard.Urls.BaseUrl, "http", "https")
+	CheckURL(report, "standard.urls.items_url", cfg.Standard.Urls.ItemsUrl, "http", "https")
+	CheckURL(report, "standard.urls.item_url", cfg.Standard.Urls.ItemUrl, "http", "https")
+	for _, required := range []struct{ path, value string }{
+		{"standard.items_response.items", cfg.Standard.ItemsResponse.Items},
+		{"standard.items_response.id", cfg.Standard.ItemsResponse.ID},
+		{"standard.item_response.item", cfg.Standard.ItemResponse.Item},
+		{"standard.item_response.timestamp", cfg.Standard.ItemResponse.Timestamp},
+		{"standard.timestamp_format", cfg.Standard.TimestampFormat},
+	} {
+		if required.value == "" {
+			report(required.path, "must not be empty")
+		}
+	}
+
+	if cfg.Checkpoint.Enabled && cfg.Checkpoint.File == "" {
+		report("checkpoint.file", "must not be empty when the checkpoint is enabled")
+	}
+	if cfg.Checkpoint.MaxAgeSeconds < 0 {
+		report("checkpoint.max_age_seconds", "must not be negative")
+	}
+	if cfg.Metrics.Enabled && cfg.Metrics.Path != "" && !strings.HasPrefix(cfg.Metrics.Path, "/") {
+		report("metrics.path", "must start with \"/\"")
+	}
+	if cfg.Status.IntervalSeconds < 0 {
+		report("status.interval_seconds", "must not be negative")
+	}
+	if cfg.Supervision.MaxRestarts < 0 {
+		report("supervision.max_restarts", "must not be negative")
+	}
+	if cfg.Supervision.WindowSeconds <= 0 {
+		report("supervision.window_seconds", "must be greater than 0")
+	}
+	if cfg.Supervision.InitialBackoffMilli <= 0 {
+		report("supervision.initial_backoff_milli", "must be greater than 0")
+	}
+	if cfg.Supervision.MaxBackoffMilli < cfg.Supervision.InitialBackoffMilli {
+		report("supervision.max_backoff_milli", "must not be less than supervision.initial_backoff_milli")
+	}
+	if cfg.Shutdown.DrainTimeoutSeconds < 0 {
+		report("shutdown.drain_timeout_seconds", "must not be negative")
+	}
+	if cfg.Scaling.Enabled {
+		if cfg.Scaling.IntervalSeconds <= 0 {
+			report("scaling.interval_seconds", "must be greater than 0 when scaling is enabled")
+		}
+		if cfg.Scaling.TargetUtilization <= 0 || cfg.Scaling.TargetUtilization > 1 {
+			report("scaling.target_utilization", "must be in the range (0, 1], %.2f has been provided",
+				cfg.Scaling.TargetUtilization)
+		}
+	}
+}
+
+// CheckURL reports value if it is not an absolute URL with one of the schemes.
+func CheckURL(report ReportFunc, path, value string, schemes ...string) {
+	if value == "" {
+		report(path, "must not be empty")
+		return
+	}
+
+	parsedURL, err := url.Parse(value)
+	if err != nil {
+		report(path, "invalid URL: %s", err.Error())
+		return
+	}
+	if !slices.Contains(schemes, parsedURL.Scheme) || parsedURL.Host == "" {
+		report(path, "must be an absolute %s URL, %q has been provided", strings.Join(schemes, " or "), value)
+	}
+}
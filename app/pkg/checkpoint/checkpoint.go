@@ -0,0 +1,101 @@
+package checkpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	wtypes "crawler/app/pkg/crawler/workers/workers-types"
+	"crawler/app/pkg/utils/filex"
+)
+
+// Checkpoint is the persisted crawl position.
+// It contains everything needed to resume the crawl after a restart
+// without leaving gaps in the fetched IDs.
+type Checkpoint struct {
+	SavedAt time.Time `json:"saved_at"`
+
+	// All IDs lower than or equal to this one have been either fetched
+	// successfully or labeled as lost by the backup workers.
+	LastContiguousID int `json:"last_contiguous_id"`
+
+	// The highest ID generated by the workers manager.
+	// A resumed crawl starts generating thresholds from this ID.
+	HighestID int `json:"highest_id"`
+
+	BatchID uint16 `json:"batch_id"`
+
+	// The current (randomized) offset of the workers manager.
+	Offset uint32 `json:"offset"`
+
+	// The state of the thresholds controller.
+	ThresholdsAmount uint32 `json:"thresholds_amount"`
+	CurrentTimestamp uint32 `json:"current_timestamp"`
+
+	// The items within (LastContiguousID, HighestID] that were still being
+	// fetched by the subordinate or backup workers when the checkpoint was taken.
+	// A resumed crawl sends them to the backup workers.
+	PendingBackups []*wtypes.BackupPacket `json:"pending_backups"`
+}
+
+// Load reads a checkpoint from path.
+// If the file does not exist the returned error wraps os.ErrNotExist.
+func Load(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("could not decode checkpoint file %s: %w", path, err)
+	}
+
+	return &cp, nil
+}
+
+// Save writes the checkpoint to path.
+// The file is replaced atomically, so that a crash during the write never corrupts the previous checkpoint.
+func Save(path string, cp *Checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return fmt.Errorf("could not encode checkpoint: %w", err)
+	}
+
+	if err := filex.WriteAtomic(path, data); err != nil {
+		return fmt.Errorf("could not write checkpoint file: %w", err)
+	}
+
+	return nil
+}
+
+// SaveNow takes a snapshot of the tracker and saves it to path.
+// Nothing is saved if the tracker has not received any batch commit yet.
+func SaveNow(tracker *Tracker, path string) error {
+	cp, ok := tracker.Snapshot()
+	if !ok {
+		return nil
+	}
+
+	return Save(path, cp)
+}
+
+// SaveLoop saves a checkpoint of the tracker to path every interval until ctx is done.
+func SaveLoop(ctx context.Context, tracker *Tracker, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := SaveNow(tracker, path); err != nil {
+				slog.Error(fmt.Sprintf("(Checkpoint): error saving checkpoint: %s", err.Error()))
+			}
+		}
+	}
+}
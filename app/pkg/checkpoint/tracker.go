@@ -0,0 +1,166 @@
+package checkpoint
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	wtypes "crawler/app/pkg/crawler/workers/workers-types"
+)
+
+// Tracker keeps track of the crawl position in a thread safe way.
+//
+// The workers manager commits its state at the end of each batch, while the
+// subordinate and backup workers report the items they are still working on.
+// All methods can be called on a nil *Tracker, in which case they do nothing.
+type Tracker struct {
+	mu sync.Mutex
+
+	// whether at least one batch has been committed (or a checkpoint restored).
+	committed bool
+	batch     BatchCommit
+
+	// the items dispatched to the subordinate workers that have not been
+	// resolved yet, indexed by their ID.
+	// once an item is sent to the backup workers its packet is updated.
+	inFlight map[int]*wtypes.BackupPacket
+}
+
+// BatchCommit is the state of the workers manager at the end of a batch.
+type BatchCommit struct {
+	// All IDs lower than or equal to HighestID have been either fetched by the
+	// thresholds workers or dispatched to the subordinate workers.
+	HighestID        int
+	BatchID          uint16
+	Offset           uint32
+	ThresholdsAmount uint32
+	CurrentTimestamp uint32
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		inFlight: make(map[int]*wtypes.BackupPacket),
+	}
+}
+
+// Dispatch marks an item as sent to the subordinate workers.
+func (t *Tracker) Dispatch(itemID int, batchID uint16) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inFlight[itemID] = &wtypes.BackupPacket{ItemID: itemID, BatchID: batchID}
+}
+
+// MoveToBackup marks an item as sent to the backup workers.
+func (t *Tracker) MoveToBackup(packet *wtypes.BackupPacket) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inFlight[packet.ItemID] = packet
+}
+
+// Resolve marks an item as done, either because it has been fetched
+// successfully or because it has been labeled as lost.
+func (t *Tracker) Resolve(itemID int) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.inFlight, itemID)
+}
+
+// Pending returns the amount of items dispatched that have not been resolved yet.
+func (t *Tracker) Pending() int {
+	if t == nil {
+		return 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.inFlight)
+}
+
+// CommitBatch stores the state of the workers manager at the end of a batch.
+func (t *Tracker) CommitBatch(commit BatchCommit) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.batch = commit
+	t.committed = true
+}
+
+// Restore loads a previously saved checkpoint into the tracker.
+// The pending backups of the checkpoint are considered in flight again.
+func (t *Tracker) Restore(cp *Checkpoint) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.batch = BatchCommit{
+		HighestID:        cp.HighestID,
+		BatchID:          cp.BatchID,
+		Offset:           cp.Offset,
+		ThresholdsAmount: cp.ThresholdsAmount,
+		CurrentTimestamp: cp.CurrentTimestamp,
+	}
+	t.committed = true
+
+	for _, packet := range cp.PendingBackups {
+		t.inFlight[packet.ItemID] = packet
+	}
+}
+
+// Snapshot returns the current crawl position as a Checkpoint.
+// ok is false if nothing has been committed yet.
+func (t *Tracker) Snapshot() (cp *Checkpoint, ok bool) {
+	if t == nil {
+		return nil, false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.committed {
+		return nil, false
+	}
+
+	lastContiguousID := t.batch.HighestID
+	pending := make([]*wtypes.BackupPacket, 0, len(t.inFlight))
+	for itemID, packet := range t.inFlight {
+		if itemID-1 < lastContiguousID {
+			lastContiguousID = itemID - 1
+		}
+		pending = append(pending, &wtypes.BackupPacket{
+			ItemID:       packet.ItemID,
+			AppendSuffix: packet.AppendSuffix,
+			BatchID:      packet.BatchID,
+		})
+	}
+	slices.SortFunc(pending, func(a, b *wtypes.BackupPacket) int {
+		return a.ItemID - b.ItemID
+	})
+
+	return &Checkpoint{
+		SavedAt:          time.Now(),
+		LastContiguousID: lastContiguousID,
+		HighestID:        t.batch.HighestID,
+		BatchID:          t.batch.BatchID,
+		Offset:           t.batch.Offset,
+		ThresholdsAmount: t.batch.ThresholdsAmount,
+		CurrentTimestamp: t.batch.CurrentTimestamp,
+		PendingBackups:   pending,
+	}, true
+}
@@ -0,0 +1,31 @@
+package crawler
+
+import (
+	"maps"
+	"slices"
+
+	"crawler/app/pkg/metrics"
+)
+
+// registerChannelMetrics registers the length and capacity gauges of the channels,
+// by name. Each function must return the current length and the capacity of its channel.
+func registerChannelMetrics(registry *metrics.Registry, channels map[string]func() (int, int)) {
+	for _, name := range slices.Sorted(maps.Keys(channels)) {
+		lenCap := channels[name]
+		labels := metrics.Labels{"channel": name}
+		registry.NewGaugeFunc(
+			"crawler_channel_length", "Amount of elements waiting in the channel.", labels,
+			func() float64 {
+				length, _ := lenCap()
+				return float64(length)
+			},
+		)
+		registry.NewGaugeFunc(
+			"crawler_channel_capacity", "Capacity of the channel.", labels,
+			func() float64 {
+				_, capacity := lenCap()
+				return float64(capacity)
+			},
+		)
+	}
+}
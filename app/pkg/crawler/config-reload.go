@@ -0,0 +1,93 @@
+package crawler
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"time"
+
+	assetshandler "crawler/app/pkg/assets-handler"
+	"crawler/app/pkg/crawler/network"
+	wtypes "crawler/app/pkg/crawler/workers/workers-types"
+	"crawler/app/pkg/ratelimit"
+	"crawler/app/pkg/sinks"
+	"crawler/app/pkg/thresholds"
+	"crawler/app/pkg/utils/mapx"
+)
+
+// how often the modification time of the config file is checked
+const configPollInterval = 2 * time.Second
+
+// configReloader applies the runtime safe settings of a changed config file
+// to the running crawler. The other settings require a restart.
+type configReloader struct {
+	path string
+	// reapplied on top of the file at each reload
+	overrides []assetshandler.Override
+	sharedCfg *assetshandler.SharedConfig
+
+	thresholdsController *thresholds.ThresholdsController
+	delays               func() wtypes.DelayPercentiles
+	sink                 sinks.Sink
+	rateLimiter          *ratelimit.Limiter
+}
+
+// reload re-reads the config file and, if it is valid, swaps the runtime safe
+// settings of the shared config. On error, the running config is left untouched.
+func (cr *configReloader) reload() error {
+	loaded, err := ValidateConfigFile(cr.path, cr.overrides)
+	if err != nil {
+		return err
+	}
+
+	policies, err := compilePolicies(loaded.Policies, cr.delays)
+	if err != nil {
+		return err
+	}
+
+	// the policies are validated by the controller, so they are set
+	// before swapping the config to leave it untouched on error
+	if err := cr.thresholdsController.SetPolicies(policies); err != nil {
+		return err
+	}
+
+	current := cr.sharedCfg.Load()
+	next := *current
+	next.Http.Timeout = loaded.Http.Timeout
+	next.Http.MaxRetriesPerItem = loaded.Http.MaxRetriesPerItem
+	next.Http.DelayBetweenRetries = loaded.Http.DelayBetweenRetries
+	next.Http.RateLimit = loaded.Http.RateLimit
+	next.Http.ProxyHealth = loaded.Http.ProxyHealth
+	next.Policies = loaded.Policies
+	next.Core.BatchLimits = loaded.Core.BatchLimits
+	next.Standard.WebSocket.WsHeaders = loaded.Standard.WebSocket.WsHeaders
+	cr.sharedCfg.Store(&next)
+
+	if !reflect.DeepEqual(current.Http.RateLimit, next.Http.RateLimit) {
+		cr.rateLimiter.Configure(&next.Http.RateLimit)
+	}
+	if !reflect.DeepEqual(current.Http.RateLimit.PerProxy, next.Http.RateLimit.PerProxy) ||
+		!reflect.DeepEqual(current.Http.ProxyHealth, next.Http.ProxyHealth) {
+		network.ConfigureProxies(&next.Http.RateLimit.PerProxy, &next.Http.ProxyHealth)
+	}
+	if !reflect.DeepEqual(current.Standard.WebSocket.WsHeaders, next.Standard.WebSocket.WsHeaders) {
+		sinks.SetWebsocketHeaders(cr.sink, http.Header(mapx.StringToStringsList(next.Standard.WebSocket.WsHeaders)))
+	}
+
+	if !reflect.DeepEqual(*loaded, next) {
+		slog.Warn("(ConfigReloader): some of the changed settings are not reloadable, " +
+			"they will be applied on the next restart")
+	}
+
+	return nil
+}
+
+// onConfigChange is meant to be passed to assetshandler.WatchFile.
+func (cr *configReloader) onConfigChange() {
+	if err := cr.reload(); err != nil {
+		slog.Error(fmt.Sprintf("(ConfigReloader): config not reloaded, the running one is kept: %s", err.Error()))
+		return
+	}
+	slog.Info("(ConfigReloader): config reloaded")
+}
@@ -0,0 +1,114 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+
+	assetshandler "crawler/app/pkg/assets-handler"
+	"crawler/app/pkg/sinks"
+	"crawler/app/pkg/status"
+)
+
+// ValidateConfigFile loads the config file at path with the overrides on top of it
+// (see assetshandler.ValidateConfigFile) and validates it, including the settings
+// only known by the crawler components (sinks, status format, policies).
+// All the problems found are returned together in a *assetshandler.ConfigValidationError.
+func ValidateConfigFile(path string, overrides []assetshandler.Override) (*assetshandler.Config, error) {
+	return assetshandler.ValidateConfigFile(
+		path, overrides, sinks.CheckConfig, checkStatusConfig, checkPoliciesConfig, checkWorkersConfig,
+	)
+}
+
+func checkStatusConfig(cfg *assetshandler.Config, report assetshandler.ReportFunc) {
+	if _, err := status.NewRenderer(cfg.Status.Format); err != nil {
+		report("status.format", "%s, should be %s or %s", err.Error(), status.FormatText, status.FormatJSON)
+	}
+}
+
+// checkWorkersConfig reports the core and scaling settings leading to pools
+// of more than maxWorkersAmount workers or with inconsistent bounds.
+// All the problems are reported, those of the same setting joined together.
+func checkWorkersConfig(cfg *assetshandler.Config, report assetshandler.ReportFunc) {
+	_, subordinateWks, backupWks := workersAmounts(cfg)
+	thresholdsBounds, subordinateBounds, backupBounds := workersBounds(cfg)
+
+	// the problems by path, in the order they have been found
+	var paths []string
+	problems := make(map[string][]error)
+	addProblem := func(path string, format string, args ...any) {
+		if _, ok := problems[path]; !ok {
+			paths = append(paths, path)
+		}
+		problems[path] = append(problems[path], fmt.Errorf(format, args...))
+	}
+
+	// the thresholds amount is kept within maxWorkersAmount by the thresholds controller
+	if cfg.Core.ThresholdsInitialAmount > maxWorkersAmount {
+		addProblem("core.thresholds_initial_amount", "must not be greater than %d", maxWorkersAmount)
+	}
+
+	for _, pool := range []struct {
+		name      string
+		cfgBounds assetshandler.PoolBounds
+		bounds    poolBounds
+		// reports the max bound when it is derived from the core settings
+		reportDerived func()
+	}{
+		{"thresholds", cfg.Scaling.Thresholds, thresholdsBounds, func() {
+			addProblem("core.expected_max_thresholds_amount", "must not be greater than %d", maxWorkersAmount)
+		}},
+		{"subordinate", cfg.Scaling.Subordinate, subordinateBounds, func() {
+			addProblem("core.thresholds_offset",
+				"times core.expected_max_thresholds_amount gives %d subordinate workers, more than %d",
+				subordinateWks, maxWorkersAmount)
+		}},
+		{"backup", cfg.Scaling.Backup, backupBounds, func() {
+			addProblem("core.thresholds_offset",
+				"gives %d backup workers with the http retries settings, more than %d",
+				backupWks, maxWorkersAmount)
+		}},
+	} {
+		path := "scaling." + pool.name
+		if pool.bounds.max > maxWorkersAmount {
+			if cfg.Scaling.Enabled && pool.cfgBounds.MaxWorkers > 0 {
+				addProblem(path+".max_workers", "must not be greater than %d", maxWorkersAmount)
+			} else {
+				pool.reportDerived()
+			}
+		}
+
+		if cfg.Scaling.Enabled && pool.bounds.min > pool.bounds.max {
+			addProblem(path+".min_workers", "must not be greater than the max workers of the pool (%d)", pool.bounds.max)
+		}
+	}
+
+	for _, path := range paths {
+		report(path, "%s", errors.Join(problems[path]...).Error())
+	}
+}
+
+// checkPoliciesConfig reports the policies that would be rejected by
+// compilePolicies or by the thresholds controller.
+func checkPoliciesConfig(cfg *assetshandler.Config, report assetshandler.ReportFunc) {
+	if len(cfg.Policies) == 0 {
+		report("thresholds_adjustment_policies", "at least one policy is required")
+		return
+	}
+
+	foundZero := false
+	for idx, policyCfg := range cfg.Policies {
+		path := fmt.Sprintf("thresholds_adjustment_policies[%d]", idx)
+
+		if policyCfg.Percentage < 0 || policyCfg.Percentage > 1 {
+			report(path+".percentage", "must be in the range [0, 1], %.2f has been provided", policyCfg.Percentage)
+		}
+		foundZero = foundZero || policyCfg.Percentage == 0
+
+		if _, err := compilePolicyExpr(policyCfg.ComputeIncrementExpr); err != nil {
+			report(path+".compute_increment", "expression does not compile: %s", err.Error())
+		}
+	}
+	if !foundZero {
+		report("thresholds_adjustment_policies", "at least one policy must have a percentage of 0")
+	}
+}
@@ -2,26 +2,45 @@ package crawler
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
-	"math"
 	"math/rand"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"crawler/app/pkg/admin"
 	"crawler/app/pkg/assert"
 	assetshandler "crawler/app/pkg/assets-handler"
+	"crawler/app/pkg/checkpoint"
 	"crawler/app/pkg/crawler/network"
 	"crawler/app/pkg/crawler/workers"
 	wtypes "crawler/app/pkg/crawler/workers/workers-types"
-	safews "crawler/app/pkg/safe-ws"
+	"crawler/app/pkg/metrics"
+	"crawler/app/pkg/ratelimit"
+	"crawler/app/pkg/shutdown"
+	"crawler/app/pkg/sinks"
+	"crawler/app/pkg/status"
 	"crawler/app/pkg/thresholds"
+	"crawler/app/pkg/utils/pathx"
 )
 
-func Start(ctx context.Context, cfg *assetshandler.Config, conns []*safews.SafeConn, statusLogFile *os.File) {
+// Start runs the crawler with cfg. The runtime safe settings of the config
+// are reloaded when the file at configPath changes (see configReloader),
+// the overrides cfg has been loaded with being applied again on top of it.
+func Start(
+	ctx context.Context,
+	cfg *assetshandler.Config,
+	configPath string,
+	overrides []assetshandler.Override,
+	statusLogFile *os.File,
+) {
 	slog.Info("Crawler Started...")
 
+	sharedCfg := assetshandler.NewSharedConfig(cfg)
+
 	//
 	// Setup workers related variables
 	//
@@ -29,157 +48,310 @@ func Start(ctx context.Context, cfg *assetshandler.Config, conns []*safews.SafeC
 	var state *wtypes.State = new(wtypes.State)
 	var outcome *wtypes.Outcome = new(wtypes.Outcome)
 
-	var maxRetriesPerItem uint8 = cfg.Http.MaxRetriesPerItem
-	var delayBetweenRetries uint64 = cfg.Http.DelayBetweenRetries
+	sink, err := sinks.Build(cfg, outcome)
+	assert.NoError(err, "all enabled sinks must be built successfully")
+	slog.Info(fmt.Sprintf("writing crawled items to sink: %s", sink.Name()))
 
-	// if this amount of thresholds is exceeded the system will slow down.
-	//
-	// TODO: maybe in future handle this by dynamically spawning workers
-	// as the user might not have any idea about what value to put here.
-	var idealMaxThresholdsAmount uint8 = cfg.Core.ExpMaxThresholdsAmount
-	thresholdsWkIDsChan := make(chan *wtypes.ItemFromBatchPacket, idealMaxThresholdsAmount)
-	thresholdsWkResultsChan := make(chan *wtypes.ThresholdsWorkerResult, idealMaxThresholdsAmount)
+	statusRenderer, err := status.NewRenderer(cfg.Status.Format)
+	assert.NoError(err, "status format must be valid", assert.AssertData{"format": cfg.Status.Format})
+
+	// the channels are sized after the max amount of workers of their pools
+	thresholdsBounds, subBounds, backupBounds := workersBounds(cfg)
+	for _, bounds := range []poolBounds{thresholdsBounds, subBounds, backupBounds} {
+		assert.Assert(bounds.min <= bounds.max && bounds.max <= maxWorkersAmount,
+			"workers bounds must have been validated with the config (see checkWorkersConfig)",
+			assert.AssertData{"min": bounds.min, "max": bounds.max})
+	}
+	maxThresholdsWks := int(thresholdsBounds.max)
+	maxSubWks := int(subBounds.max)
+	maxBackupWks := int(backupBounds.max)
 
-	var subWorkersAmount uint16 = uint16(cfg.Core.ThresholdsOffset) * uint16(idealMaxThresholdsAmount)
+	thresholdsWkIDsChan := make(chan *wtypes.ItemFromBatchPacket, maxThresholdsWks)
+	thresholdsWkResultsChan := make(chan *wtypes.ThresholdsWorkerResult, maxThresholdsWks)
 
 	// Each request takes a different amount of time to complete
 	// and little delays are introduced all the time.
 	// To take care of this, the channel size is tripled.
-	subordinateWkIDsChannel := make(chan *wtypes.ItemFromBatchPacket, subWorkersAmount*3)
+	subordinateWkIDsChannel := make(chan *wtypes.ItemFromBatchPacket, maxSubWks*3)
 
-	// Let's rename "subWorkersAmount" to N, "maxRetriesPerItem" to M and
-	// "delayBetweenRetries" to D.
-	//
-	// Hypothesize all requests fail and each one takes the same amount of T ms.
-	// In this situation, each subordinate worker will produce a backup packet every T ms,
-	// while each backup worker will take M*(T+D) ms to consume it.
-	//
-	// Being X the amount of backup workers needed to exactly match the
-	// production and consumption rates, X will need to satisfy the following equation:
-	// X/[M*(T+D)] = N/T ==> X = N*M*(T+D)/T = N*M*(1+D/T).
-	// In the hypothesized scenario, with this amount of backup workers,
-	// a backup channel size of N*M*(1+D/T) is never exceeded.
-	//
-	// Since a GET request ideally takes less than 1 second to complete, assuming
-	// T = 1000 ms is a good approximation.
-	// In this case, the amount of backup workers needed is N*M*(1+D/1000).
-	//
-	// In practice, each request takes a different amount of time to complete
-	// and little delays are introduced all the time.
-	// To take care of this, the channel size is tripled.
-	//
-	// The amount of backup workers could also be tripled instead but, since
-	// the hypothesis of all requests failing is very pessimistic, it would only
-	// waste many resources without any actual benefit.
-	//
-	// backupWorkersAmount is a uint32 because, even letting N and M being their highest possible
-	// values (2^16-1 and 2^8-1 respectively), the result of N*M*(1+D/1000) exceeds
-	// the maximum value of a uint32 (2^32-1) only if D is greater than 4.2 minutes.
-	// This is a very unlikely scenario, so we can safely use uint32 instead of uint64.
-	var backupWorkersAmount uint32
-	if maxRetriesPerItem < 2 {
-		backupWorkersAmount = uint32(subWorkersAmount)
-	} else {
-		backupWorkersAmount = uint32(subWorkersAmount) * (uint32)(maxRetriesPerItem)
-	}
-	backupWorkersAmount *= 1 + uint32(math.Ceil((float64)(delayBetweenRetries)/1000))
-	backupChan := make(chan *wtypes.BackupPacket, backupWorkersAmount*3)
+	// see workersAmounts for the backup channel size
+	backupChan := make(chan *wtypes.BackupPacket, maxBackupWks*3)
 
 	// this channel is used to send results by subordinate Wks, backup Wks and
 	// the workers manager.
 	// since the backup workers are the ones in majority, the channel size is
 	// set to their amount.
-	wsChan := make(chan *wtypes.ContentElement, backupWorkersAmount)
+	sinkChan := make(chan *wtypes.ContentElement, maxBackupWks)
+
+	//
+	// Setup the checkpoint tracker
+	//
+
+	// the tracker is also used to wait for the items in flight on shutdown,
+	// so it is kept even if the checkpoint is disabled
+	tracker := checkpoint.NewTracker()
+	var resumeCheckpoint *checkpoint.Checkpoint
+	var checkpointPath string
+	if cfg.Checkpoint.Enabled {
+		checkpointPath = pathx.FromCwd(cfg.Checkpoint.File)
+
+		if cfg.Checkpoint.Resume {
+			cp, err := checkpoint.Load(checkpointPath)
+			maxAge := (time.Duration)(cfg.Checkpoint.MaxAgeSeconds) * time.Second
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+				slog.Info("no checkpoint found, the crawl will start from the items endpoint")
+			case err == nil && maxAge > 0 && time.Since(cp.SavedAt) > maxAge:
+				// the IDs generated from an old position may not exist anymore
+				slog.Warn(fmt.Sprintf(
+					"checkpoint saved at %s is older than %s, the crawl will start from the items endpoint",
+					cp.SavedAt.Format(time.DateTime), maxAge,
+				))
+			default:
+				assert.NoError(err, "checkpoint file must be readable to resume the crawl",
+					assert.AssertData{"path": checkpointPath})
+				resumeCheckpoint = cp
+			}
+		}
+	}
+
+	//
+	// Setup the workers supervisor
+	//
+
+	supervisor := &workersSupervisor{
+		ctx:            ctx,
+		outcome:        outcome,
+		maxRestarts:    cfg.Supervision.MaxRestarts,
+		window:         (time.Duration)(cfg.Supervision.WindowSeconds) * time.Second,
+		initialBackoff: (time.Duration)(cfg.Supervision.InitialBackoffMilli) * time.Millisecond,
+		maxBackoff:     (time.Duration)(cfg.Supervision.MaxBackoffMilli) * time.Millisecond,
+	}
 
 	var wg sync.WaitGroup
 
 	for i, cookieJarSession := range network.CookieJarSessionsPool {
 		wg.Add(1)
-		cWk := &workers.CookiesRefreshWorker{
-			ID:               i,
-			Ctx:              ctx,
-			CookieJarSession: cookieJarSession,
-			OnSessionReady:   wg.Done,
-			Rand:             rand.New(rand.NewSource(time.Now().UnixNano())),
-		}
-
-		go cWk.Run(cfg, cfg.Standard.SessionCookieNames)
+		// a restarted worker fetches the cookie again, the session is ready only once
+		onSessionReady := sync.OnceFunc(wg.Done)
+
+		supervisor.supervise(func(onCrash func(crash *wtypes.WorkerCrash)) {
+			// the refresh loop started by the worker stops with it
+			workerCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			cWk := &workers.CookiesRefreshWorker{
+				ID:               i,
+				Ctx:              workerCtx,
+				CookieJarSession: cookieJarSession,
+				OnSessionReady:   onSessionReady,
+				OnCrash:          onCrash,
+				Rand:             rand.New(rand.NewSource(time.Now().UnixNano())),
+			}
+			cWk.Run(sharedCfg, cfg.Standard.SessionCookieNames)
+		})
 	}
 
 	//
 	// Start the main workers
 	//
 
-	// TODO: do not use one rand source per worker, instead implement a thread safe one
+	// the context of the thresholds, subordinate and backup workers, cancelled
+	// on shutdown once the items in flight have been fetched (see drainer)
+	workersCtx, cancelWorkers := context.WithCancel(ctx)
 
-	for i := uint16(1); i <= subWorkersAmount; i++ {
-		sWk := &workers.SubordinateWorker{
-			ID:           int(i),
-			Ctx:          ctx,
-			ItemsIDsChan: subordinateWkIDsChannel,
-			ResultsChan:  wsChan,
-			BackupChan:   backupChan,
-			Rand:         rand.New(rand.NewSource(time.Now().UnixNano())),
-		}
+	// the subordinate and backup workers running, waited for on shutdown
+	var runningFetchers atomic.Int64
 
-		go sWk.Run(cfg, state, outcome)
-	}
+	// shared by all the workers, so that a burst of rate limits slows them down together
+	rateLimiter := ratelimit.New(&cfg.Http.RateLimit)
 
-	for j := uint32(1); j <= backupWorkersAmount; j++ {
-		bWk := &workers.BackupWorker{
-			ID:                    int(j),
-			Ctx:                   ctx,
-			ItemsBackupPacketChan: backupChan,
-			ResultsChan:           wsChan,
-			MaxRetries:            int16(maxRetriesPerItem) - 1,
-			Delay:                 delayBetweenRetries,
-			Rand:                  rand.New(rand.NewSource(time.Now().UnixNano())),
-		}
+	// TODO: do not use one rand source per worker, instead implement a thread safe one
 
-		go bWk.Run(cfg, state, outcome)
-	}
+	// the usage of each pool is also reported in the status log
+	subordinateUsage := &wtypes.PoolUsage{Name: "subordinate"}
+	subordinatePool := newWorkerPool(subordinateUsage, subBounds,
+		func() int { return len(subordinateWkIDsChannel) },
+		func(id int, stopChan <-chan struct{}) {
+			supervisor.supervise(func(onCrash func(crash *wtypes.WorkerCrash)) {
+				runningFetchers.Add(1)
+				defer runningFetchers.Add(-1)
+
+				sWk := &workers.SubordinateWorker{
+					ID:           id,
+					Ctx:          workersCtx,
+					ItemsIDsChan: subordinateWkIDsChannel,
+					ResultsChan:  sinkChan,
+					BackupChan:   backupChan,
+					RateLimiter:  rateLimiter,
+					Tracker:      tracker,
+					Usage:        subordinateUsage,
+					StopChan:     stopChan,
+					OnCrash:      onCrash,
+					Rand:         rand.New(rand.NewSource(time.Now().UnixNano())),
+				}
+				sWk.Run(sharedCfg, state, outcome)
+			})
+		},
+	)
 
-	for k := uint8(1); k <= idealMaxThresholdsAmount; k++ {
-		tWk := &workers.ThresholdsWorker{
-			ID:           int(k),
-			Ctx:          ctx,
-			ItemsIDsChan: thresholdsWkIDsChan,
-			ResultsChan:  thresholdsWkResultsChan,
-			Rand:         rand.New(rand.NewSource(time.Now().UnixNano())),
-		}
+	backupUsage := &wtypes.PoolUsage{Name: "backup"}
+	backupPool := newWorkerPool(backupUsage, backupBounds,
+		func() int { return len(backupChan) },
+		func(id int, stopChan <-chan struct{}) {
+			supervisor.supervise(func(onCrash func(crash *wtypes.WorkerCrash)) {
+				runningFetchers.Add(1)
+				defer runningFetchers.Add(-1)
+
+				bWk := &workers.BackupWorker{
+					ID:                    id,
+					Ctx:                   workersCtx,
+					ItemsBackupPacketChan: backupChan,
+					ResultsChan:           sinkChan,
+					RateLimiter:           rateLimiter,
+					Tracker:               tracker,
+					Usage:                 backupUsage,
+					StopChan:              stopChan,
+					OnCrash:               onCrash,
+					Rand:                  rand.New(rand.NewSource(time.Now().UnixNano())),
+				}
+				bWk.Run(sharedCfg, state, outcome)
+			})
+		},
+	)
 
-		go tWk.Run(cfg, state, outcome)
-	}
+	thresholdsUsage := &wtypes.PoolUsage{Name: "thresholds"}
+	thresholdsPool := newWorkerPool(thresholdsUsage, thresholdsBounds,
+		func() int { return len(thresholdsWkIDsChan) },
+		func(id int, stopChan <-chan struct{}) {
+			supervisor.supervise(func(onCrash func(crash *wtypes.WorkerCrash)) {
+				tWk := &workers.ThresholdsWorker{
+					ID:           id,
+					Ctx:          workersCtx,
+					ItemsIDsChan: thresholdsWkIDsChan,
+					ResultsChan:  thresholdsWkResultsChan,
+					RateLimiter:  rateLimiter,
+					Usage:        thresholdsUsage,
+					StopChan:     stopChan,
+					OnCrash:      onCrash,
+					Rand:         rand.New(rand.NewSource(time.Now().UnixNano())),
+				}
+				tWk.Run(sharedCfg, state, outcome)
+			})
+		},
+	)
 
+	// the pools start with their min amount of workers, which is also
+	// their fixed amount if they are not scaled
 	slog.Info(
 		fmt.Sprintf(
 			"%d thresholds workers, %d subordinate workers and %d backup workers Started...",
-			idealMaxThresholdsAmount, subWorkersAmount, backupWorkersAmount,
+			thresholdsPool.resize(0), subordinatePool.resize(0), backupPool.resize(0),
 		),
 	)
 
+	if cfg.Scaling.Enabled {
+		supervisor := &poolsSupervisor{
+			pools:             []*workerPool{thresholdsPool, subordinatePool, backupPool},
+			interval:          (time.Duration)(cfg.Scaling.IntervalSeconds) * time.Second,
+			targetUtilization: cfg.Scaling.TargetUtilization,
+		}
+		go supervisor.run(workersCtx)
+
+		slog.Info(fmt.Sprintf(
+			"workers pools scaled every %s within thresholds [%d, %d], subordinate [%d, %d] and backup [%d, %d]",
+			supervisor.interval, thresholdsBounds.min, thresholdsBounds.max,
+			subBounds.min, subBounds.max, backupBounds.min, backupBounds.max,
+		))
+	}
+
 	//
-	// Start the websocket worker
+	// Start the metrics server
 	//
 
-	wsWk := &workers.WebsocketWorker{
-		ID:           1,
-		Ctx:          ctx,
-		ContentsChan: wsChan,
-		Conns:        conns,
+	var metricsRegistry *metrics.Registry
+	if cfg.Metrics.Enabled {
+		metricsRegistry = metrics.NewRegistry()
+		registerChannelMetrics(metricsRegistry, map[string]func() (int, int){
+			"thresholds_ids":     func() (int, int) { return len(thresholdsWkIDsChan), cap(thresholdsWkIDsChan) },
+			"thresholds_results": func() (int, int) { return len(thresholdsWkResultsChan), cap(thresholdsWkResultsChan) },
+			"subordinate":        func() (int, int) { return len(subordinateWkIDsChannel), cap(subordinateWkIDsChannel) },
+			"backup":             func() (int, int) { return len(backupChan), cap(backupChan) },
+			"sink":               func() (int, int) { return len(sinkChan), cap(sinkChan) },
+		})
+		for _, budget := range []string{"global", ratelimit.ItemEndpoint.String()} {
+			metricsRegistry.NewGaugeFunc(
+				"crawler_rate_limit_requests_per_second",
+				"Current rate of the requests budget, adapted to the rate limits. 0 if unlimited.",
+				metrics.Labels{"budget": budget},
+				func() float64 { return rateLimiter.Rates()[budget] },
+			)
+		}
+		metricsRegistry.NewGaugeFunc(
+			"crawler_proxies_cooling_down",
+			"Amount of proxies in cooldown after being rate limited.",
+			nil,
+			func() float64 { return float64(network.ProxiesCounts().CoolingDown) },
+		)
+		metricsRegistry.NewGaugeFunc(
+			"crawler_proxies_evicted",
+			"Amount of proxies evicted after consecutive failures, until a probe succeeds.",
+			nil,
+			func() float64 { return float64(network.ProxiesCounts().Evicted) },
+		)
+
+		metricsAddress := cfg.Metrics.Address
+		if metricsAddress == "" {
+			metricsAddress = ":9090"
+		}
+		metricsPath := cfg.Metrics.Path
+		if metricsPath == "" {
+			metricsPath = "/metrics"
+		}
+
+		go func() {
+			err := metrics.Serve(ctx, metricsAddress, metricsPath, metricsRegistry)
+			assert.NoError(err, "metrics server must listen successfully",
+				assert.AssertData{"address": metricsAddress})
+		}()
+
+		slog.Info(fmt.Sprintf("serving metrics at %s%s", metricsAddress, metricsPath))
 	}
 
-	go wsWk.Run()
+	//
+	// Start the sink worker
+	//
+
+	sinkStopChan := make(chan chan struct{})
+	supervisor.supervise(func(onCrash func(crash *wtypes.WorkerCrash)) {
+		skWk := &workers.SinkWorker{
+			ID:                 1,
+			Ctx:                ctx,
+			ContentsChan:       sinkChan,
+			Sink:               sink,
+			StopChan:           sinkStopChan,
+			HealthCheckSeconds: cfg.Standard.Sinks.HealthCheckSeconds,
+			SlowWriteSeconds:   cfg.Standard.Sinks.SlowWriteSeconds,
+			OnCrash:            onCrash,
+		}
+		skWk.Run()
+	})
 
 	//
 	// Setup workers manager related variables
 	//
 
-	compiledThresholdsAdjPolicies, err := compilePolicies(cfg.Policies)
+	lastDelays := func() wtypes.DelayPercentiles {
+		state.Mu.Lock()
+		defer state.Mu.Unlock()
+		return state.LastDelays
+	}
+	compiledThresholdsAdjPolicies, err := compilePolicies(cfg.Policies, lastDelays)
 	assert.NoError(err, "all thresholds adjustment policies must be compiled successfully")
 
 	thresholdsControllerCfg := &thresholds.ThresholdsControllerConfig{
-		InitialThresholdsAmount:      uint16(cfg.Core.ThresholdsInitialAmount),
+		InitialThresholdsAmount:      cfg.Core.ThresholdsInitialAmount,
+		MaxThresholdsAmount:          maxWorkersAmount,
 		ThresholdsAdjustmentPolicies: compiledThresholdsAdjPolicies,
 	}
 	thresholdsController, err := thresholds.NewThresholdsController(thresholdsControllerCfg)
@@ -187,40 +359,183 @@ func Start(ctx context.Context, cfg *assetshandler.Config, conns []*safews.SafeC
 
 	mainRand := rand.New(rand.NewSource(time.Now().UnixNano()))
 
+	var wksManager workersManager = workersManager{
+		thresholdsController: thresholdsController,
+		offset:               cfg.Core.ThresholdsOffset,
+		initialOffset:        cfg.Core.ThresholdsOffset,
+		tracker:              tracker,
+		control:              newManagerControl(),
+		rand:                 rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
 	// wait for the cookies refresher workers to fetch all the cookies for the first time
 	wg.Wait()
-	cookieJarSession := network.PickRandomCookieJarSession(mainRand)
 
-	state.HighestID, err = network.FetchHighestID(ctx, cfg, cookieJarSession.CookieJar, mainRand)
-	assert.NoError(
-		err, "highest id fetch must be successful to start the crawler",
-		assert.AssertData{
-			"CookieJar": cookieJarSession.CookieJar,
-		},
-	)
+	if resumeCheckpoint != nil {
+		state.HighestID = resumeCheckpoint.HighestID
+		wksManager.offset = resumeCheckpoint.Offset
+		wksManager.firstBatchID = resumeCheckpoint.BatchID + 1
+
+		err = thresholdsController.Restore(resumeCheckpoint.ThresholdsAmount, resumeCheckpoint.CurrentTimestamp)
+		assert.NoError(err, "thresholds controller state must be restored from the checkpoint",
+			assert.AssertData{"path": checkpointPath})
+
+		tracker.Restore(resumeCheckpoint)
+
+		// the backup channel might be smaller than the amount of pending items,
+		// so they are sent in background to avoid blocking the start of the crawl
+		go func() {
+			for _, packet := range resumeCheckpoint.PendingBackups {
+				select {
+				case <-workersCtx.Done():
+					return
+				case backupChan <- packet:
+				}
+			}
+		}()
+
+		slog.Info(
+			fmt.Sprintf(
+				"resumed crawl from checkpoint saved at %s: highest ID %d, last contiguous ID %d, %d pending items",
+				resumeCheckpoint.SavedAt.Format(time.DateTime), resumeCheckpoint.HighestID,
+				resumeCheckpoint.LastContiguousID, len(resumeCheckpoint.PendingBackups),
+			),
+		)
+	} else {
+		cookieJarSession := network.PickRandomCookieJarSession(mainRand)
+
+		err = rateLimiter.Wait(ctx, ratelimit.ItemsEndpoint)
+		if err == nil {
+			state.HighestID, err = network.FetchHighestID(ctx, cfg, cookieJarSession.CookieJar, mainRand)
+		}
+		assert.NoError(
+			err, "highest id fetch must be successful to start the crawler",
+			assert.AssertData{
+				"CookieJar": cookieJarSession.CookieJar,
+			},
+		)
+	}
+
+	//
+	// Start the checkpoint saver
+	//
+
+	if cfg.Checkpoint.Enabled {
+		go checkpoint.SaveLoop(
+			ctx, tracker, checkpointPath,
+			(time.Duration)(max(cfg.Checkpoint.IntervalSeconds, 1))*time.Second,
+		)
+
+		// save the latest position even if the crawler is stopped between two ticks
+		shutdown.OnShutdown(func() {
+			if err := checkpoint.SaveNow(tracker, checkpointPath); err != nil {
+				slog.Error(fmt.Sprintf("(Checkpoint): error saving checkpoint on shutdown: %s", err.Error()))
+			}
+		})
+	}
 
 	//
 	// Start the status logger
 	//
 
-	logSeconds := 1
-	go workers.LogAndResetVarsLoop(state, outcome, logSeconds, statusLogFile)
+	var statusMetrics *workers.StatusMetrics
+	if metricsRegistry != nil {
+		statusMetrics = workers.NewStatusMetrics(metricsRegistry)
+	}
+
+	statusIntervalSeconds := cfg.Status.IntervalSeconds
+	if statusIntervalSeconds <= 0 {
+		statusIntervalSeconds = 1
+	}
+
+	go workers.LogAndResetVarsLoop(
+		state, outcome,
+		[]*wtypes.PoolUsage{thresholdsUsage, subordinateUsage, backupUsage},
+		(time.Duration)(statusIntervalSeconds)*time.Second,
+		statusRenderer, statusLogFile, statusMetrics,
+	)
 
 	//
-	// Start the workers manager
+	// Start the config watcher
 	//
 
-	var wksManager workersManager = workersManager{
+	reloader := &configReloader{
+		path:                 configPath,
+		overrides:            overrides,
+		sharedCfg:            sharedCfg,
 		thresholdsController: thresholdsController,
-		offset:               uint16(cfg.Core.ThresholdsOffset),
-		rand:                 rand.New(rand.NewSource(time.Now().UnixNano())),
+		delays:               lastDelays,
+		sink:                 sink,
+		rateLimiter:          rateLimiter,
+	}
+	go assetshandler.WatchFile(ctx, configPath, configPollInterval, reloader.onConfigChange)
+
+	//
+	// Start the admin server
+	//
+
+	if cfg.Admin.Enabled {
+		adminAddress := cfg.Admin.Address
+		if adminAddress == "" {
+			adminAddress = "127.0.0.1:9091"
+		}
+
+		adminServer := &admin.Server{
+			State:                state,
+			Outcome:              outcome,
+			ThresholdsController: thresholdsController,
+			Manager:              wksManager.control,
+			CookieJarSessions:    network.CookieJarSessionsPool,
+			Proxies:              network.ProxiesStats,
+			Crashes:              supervisor.Crashes,
+			Token:                cfg.Admin.Token,
+		}
+
+		go func() {
+			err := admin.Serve(ctx, adminAddress, adminServer)
+			assert.NoError(err, "admin server must listen successfully",
+				assert.AssertData{"address": adminAddress})
+		}()
+
+		slog.Info(fmt.Sprintf("serving admin API at %s", adminAddress))
 	}
+
+	//
+	// Setup the graceful shutdown
+	//
+
+	managerDone := make(chan struct{})
+	if cfg.Shutdown.DrainTimeoutSeconds > 0 {
+		drainer := &drainer{
+			timeout:         (time.Duration)(cfg.Shutdown.DrainTimeoutSeconds) * time.Second,
+			control:         wksManager.control,
+			managerDone:     managerDone,
+			tracker:         tracker,
+			checkpointPath:  checkpointPath,
+			cancelWorkers:   cancelWorkers,
+			runningFetchers: &runningFetchers,
+			sinkStopChan:    sinkStopChan,
+			sink:            sink,
+			outcome:         outcome,
+		}
+		shutdown.SetDrain(drainer.drain)
+	}
+
+	//
+	// Start the workers manager
+	//
+
 	wksManager.run(
 		thresholdsWkIDsChan,
 		thresholdsWkResultsChan,
 		subordinateWkIDsChannel,
-		wsChan,
+		sinkChan,
 		state,
-		&cfg.Core.BatchLimits,
+		sharedCfg,
 	)
+	close(managerDone)
+
+	// the manager only returns when it is drained on shutdown,
+	// the process is exited by the shutdown package once the drain is completed
+	select {}
 }
@@ -0,0 +1,189 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"crawler/app/pkg/checkpoint"
+	wtypes "crawler/app/pkg/crawler/workers/workers-types"
+	"crawler/app/pkg/sinks"
+)
+
+const (
+	// how often the drainer checks whether a step is completed
+	drainPollInterval = 100 * time.Millisecond
+
+	// the time given to the workers to return once their context is cancelled,
+	// on top of the drain timeout, as their requests are aborted at once
+	workersStopTimeout = 5 * time.Second
+
+	// the time given to the sink to be closed, on top of the drain timeout,
+	// as it is flushed at least once even if the timeout has expired
+	sinkCloseTimeout = 5 * time.Second
+)
+
+// drainer stops the crawler in order on shutdown, so that the items already
+// dispatched are fetched and delivered instead of being thrown away:
+//
+//  1. the workers manager stops generating batches, once the current one is completed
+//  2. the subordinate and backup workers fetch the items in flight
+//  3. the fetching workers are stopped, the items still in flight are checkpointed
+//  4. the sink worker writes the contents already fetched
+//  5. the sink is flushed and closed (e.g. the websocket connections with a close frame)
+//
+// Steps 1 and 2 share the timeout, the sink is flushed at least once even if it has expired.
+// A sink that cannot be closed in time (e.g. stuck on a broken connection) is given up,
+// its buffered items being labeled as lost.
+type drainer struct {
+	timeout time.Duration
+
+	control *managerControl
+	// closed once the workers manager has returned
+	managerDone <-chan struct{}
+
+	tracker *checkpoint.Tracker
+	// empty if the checkpoint is disabled
+	checkpointPath string
+
+	// cancels the context of the thresholds, subordinate and backup workers
+	cancelWorkers context.CancelFunc
+	// the amount of subordinate and backup workers running
+	runningFetchers *atomic.Int64
+
+	sinkStopChan chan<- chan struct{}
+	sink         sinks.Sink
+
+	// used to count the items buffered in a sink that could not be closed
+	outcome *wtypes.Outcome
+}
+
+// drain runs the shutdown steps and returns whether nothing has been lost: every
+// item dispatched has been either delivered, labeled as lost by the backup workers
+// or saved in the checkpoint, and the sink has delivered all its buffered items.
+func (d *drainer) drain() bool {
+	deadline := time.Now().Add(d.timeout)
+	clean := true
+
+	d.control.Stop()
+	if !waitUntil(deadline, func() bool { return isClosed(d.managerDone) }) {
+		// the items of the batch are generated again on resume, as it has not been committed
+		slog.Warn(fmt.Sprintf("(Drainer): the workers manager has not completed its batch within %s", d.timeout))
+		clean = d.checkpointPath != ""
+	}
+
+	if !waitUntil(deadline, func() bool { return d.tracker.Pending() == 0 }) {
+		slog.Warn(fmt.Sprintf(
+			"(Drainer): %d items still in flight after %s, their requests are aborted",
+			d.tracker.Pending(), d.timeout,
+		))
+	}
+
+	d.cancelWorkers()
+	stopDeadline := time.Now().Add(max(time.Until(deadline), 0) + workersStopTimeout)
+	if !waitUntil(stopDeadline, func() bool { return d.runningFetchers.Load() == 0 }) {
+		slog.Warn(fmt.Sprintf(
+			"(Drainer): %d subordinate and backup workers still running, their items might not be delivered",
+			d.runningFetchers.Load(),
+		))
+		clean = false
+	}
+
+	if pending := d.tracker.Pending(); pending > 0 {
+		if d.checkpointPath == "" {
+			slog.Error(fmt.Sprintf("(Drainer): %d items in flight are lost, enable the checkpoint to keep them", pending))
+			clean = false
+		} else {
+			slog.Info(fmt.Sprintf("(Drainer): %d items in flight are saved in the checkpoint", pending))
+		}
+	}
+
+	// the contents fetched are written even if the deadline has expired,
+	// as they are not in the checkpoint anymore
+	sinkDone := make(chan struct{})
+	select {
+	case d.sinkStopChan <- sinkDone:
+		if !waitUntil(stopDeadline, func() bool { return isClosed(sinkDone) }) {
+			slog.Error("(Drainer): the sink worker has not written the remaining contents in time")
+			clean = false
+		}
+	case <-time.After(time.Until(stopDeadline)):
+		slog.Error("(Drainer): the sink worker is not running, the remaining contents are lost")
+		clean = false
+	}
+
+	// the sink might hang on Flush or Close, so it is closed in a goroutine bounded by the deadline
+	closeDeadline := time.Now().Add(max(time.Until(deadline), 0) + sinkCloseTimeout)
+	closed := make(chan bool, 1)
+	go func() { closed <- d.closeSink(deadline) }()
+	select {
+	case sinkClean := <-closed:
+		clean = clean && sinkClean
+	case <-time.After(time.Until(closeDeadline)):
+		slog.Error(fmt.Sprintf("(Drainer): sink %s has not been closed in time, it is given up", d.sink.Name()))
+		clean = false
+	}
+
+	if buffered := sinks.Buffered(d.sink); buffered > 0 {
+		slog.Error(fmt.Sprintf("(Drainer): %d items buffered in sink %s are lost", buffered, d.sink.Name()))
+		d.outcome.Mu.Lock()
+		d.outcome.Lost += buffered
+		d.outcome.Mu.Unlock()
+		clean = false
+	}
+
+	if d.checkpointPath != "" {
+		if err := checkpoint.SaveNow(d.tracker, d.checkpointPath); err != nil {
+			slog.Error(fmt.Sprintf("(Drainer): error saving checkpoint: %s", err.Error()))
+			clean = false
+		}
+	}
+
+	return clean
+}
+
+// closeSink flushes the sink until deadline, as the buffered sinks (e.g. the websocket
+// one while reconnecting) might deliver once reconnected, then closes it.
+// It returns whether both succeeded.
+func (d *drainer) closeSink(deadline time.Time) bool {
+	clean := true
+
+	var flushErr error
+	waitUntil(deadline, func() bool {
+		flushErr = d.sink.Flush()
+		return flushErr == nil
+	})
+	if flushErr != nil {
+		slog.Error(fmt.Sprintf("(Drainer): error flushing sink %s: %s", d.sink.Name(), flushErr.Error()))
+		clean = false
+	}
+	if err := d.sink.Close(); err != nil {
+		slog.Error(fmt.Sprintf("(Drainer): error closing sink %s: %s", d.sink.Name(), err.Error()))
+		clean = false
+	}
+
+	return clean
+}
+
+// waitUntil calls done every drainPollInterval until it returns true or deadline
+// has passed. done is called at least once. It returns the last result of done.
+func waitUntil(deadline time.Time, done func() bool) bool {
+	for !done() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(min(drainPollInterval, max(time.Until(deadline), 0)))
+	}
+	return true
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,121 @@
+package crawler
+
+import (
+	"errors"
+	"sync"
+)
+
+// managerControl allows to control the workers manager loop from other goroutines
+// (e.g. the admin API). The requests are applied between two batches.
+type managerControl struct {
+	paused bool
+	// closed when the manager is resumed, nil while it is not paused
+	resumeChan chan struct{}
+
+	// the HighestID the next batch must start from, nil if no jump has been requested
+	pendingHighestID *int
+
+	// closed by Stop, the manager returns instead of generating the next batch
+	stopChan chan struct{}
+	stopOnce sync.Once
+
+	mu sync.Mutex
+}
+
+func newManagerControl() *managerControl {
+	return &managerControl{
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Pause stops the manager from generating new batches once the current one is completed.
+// It returns false if the manager was already paused.
+func (mc *managerControl) Pause() bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if mc.paused {
+		return false
+	}
+	mc.paused = true
+	mc.resumeChan = make(chan struct{})
+	return true
+}
+
+// Resume lets a paused manager generate new batches again.
+// It returns false if the manager was not paused.
+func (mc *managerControl) Resume() bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if !mc.paused {
+		return false
+	}
+	mc.paused = false
+	close(mc.resumeChan)
+	mc.resumeChan = nil
+	return true
+}
+
+func (mc *managerControl) Paused() bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.paused
+}
+
+// Stop makes the manager return once the current batch is completed, even if it is paused.
+// A stopped manager cannot be started again.
+func (mc *managerControl) Stop() {
+	mc.stopOnce.Do(func() {
+		close(mc.stopChan)
+	})
+}
+
+// JumpHighestID makes the next batch start generating thresholds from highestID.
+func (mc *managerControl) JumpHighestID(highestID int) error {
+	if highestID <= 0 {
+		return errors.New("the highest ID must be greater than 0")
+	}
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.pendingHighestID = &highestID
+	return nil
+}
+
+// waitIfPaused blocks until the manager is resumed or stopped, if it is paused.
+func (mc *managerControl) waitIfPaused() {
+	mc.mu.Lock()
+	resumeChan := mc.resumeChan
+	mc.mu.Unlock()
+
+	if resumeChan != nil {
+		select {
+		case <-resumeChan:
+		case <-mc.stopChan:
+		}
+	}
+}
+
+// stopped reports whether Stop has been called.
+func (mc *managerControl) stopped() bool {
+	select {
+	case <-mc.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// takeHighestIDJump returns the requested HighestID, if any, and clears the request.
+func (mc *managerControl) takeHighestIDJump() (highestID int, ok bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if mc.pendingHighestID == nil {
+		return 0, false
+	}
+	highestID = *mc.pendingHighestID
+	mc.pendingHighestID = nil
+	return highestID, true
+}
@@ -6,26 +6,35 @@ import (
 	"math/rand"
 	"net/http/cookiejar"
 	"net/url"
+	"sync/atomic"
 
+	assetshandler "crawler/app/pkg/assets-handler"
 	wtypes "crawler/app/pkg/crawler/workers/workers-types"
+	"crawler/app/pkg/proxypool"
 )
 
 var (
-	proxiesPool    []*url.URL
-	userAgentsPool []string
-	profilesPool   []*Profile
+	// the proxies keep their own state (budget, cooldown), so their pool is
+	// updated in place when their file is reloaded
+	proxiesPool = proxypool.New(&assetshandler.PerProxyCfg{}, &assetshandler.ProxyHealthCfg{})
+
+	// the pools are swapped as a whole when their files are reloaded,
+	// so that the workers can keep picking from them without locking
+	userAgentsPool atomic.Pointer[[]string]
+	profilesPool   atomic.Pointer[[]*Profile]
 
 	// must be exported in order to make the crawler assign each cookie jar to a refresh worker
 	CookieJarSessionsPool []*wtypes.CookieJarSession
 )
 
+// LoadProxies replaces the proxies of the pool, the ones still in it keep their state.
 func LoadProxies(proxies []*url.URL) error {
-	if len(proxies) == 0 {
-		return errors.New("tried to load pool with an empty proxies slice")
-	}
+	return proxiesPool.Load(proxies)
+}
 
-	proxiesPool = proxies
-	return nil
+// ConfigureProxies changes the budget, the cooldown and the eviction of the proxies.
+func ConfigureProxies(perProxy *assetshandler.PerProxyCfg, health *assetshandler.ProxyHealthCfg) {
+	proxiesPool.Configure(perProxy, health)
 }
 
 func LoadUserAgents(userAgents []string) error {
@@ -33,7 +42,7 @@ func LoadUserAgents(userAgents []string) error {
 		return errors.New("tried to load pool with an empty user agents slice")
 	}
 
-	userAgentsPool = userAgents
+	userAgentsPool.Store(&userAgents)
 	return nil
 }
 
@@ -59,18 +68,33 @@ func InitCookieJars(amount uint16) error {
 
 // This function should only be called after LoadUserAgents
 func GenerateAndLoadProfiles() (profilesAmount int) {
-	profilesPool = generateProfiles(userAgentsPool)
-	return len(profilesPool)
+	profiles := generateProfiles(*userAgentsPool.Load())
+	profilesPool.Store(&profiles)
+	return len(profiles)
 }
 
-// XXX: The three pickRandom functions are not assert checked (len(pool) > 0)
-// to increase performances. This is unsafe and might be changed in future
-func pickRandomProxy(randGen *rand.Rand) *url.URL {
-	return proxiesPool[randGen.Intn(len(proxiesPool))]
+// ReloadUserAgents replaces the user agents pool and the profiles generated from it.
+// The previous pools are kept if no profile can be generated from userAgents.
+func ReloadUserAgents(userAgents []string) (profilesAmount int, err error) {
+	if len(userAgents) == 0 {
+		return 0, errors.New("tried to reload pool with an empty user agents slice")
+	}
+
+	profiles := generateProfiles(userAgents)
+	if len(profiles) == 0 {
+		return 0, errors.New("no profile could be generated from the user agents")
+	}
+
+	userAgentsPool.Store(&userAgents)
+	profilesPool.Store(&profiles)
+	return len(profiles), nil
 }
 
+// XXX: The pickRandom functions are not assert checked (len(pool) > 0)
+// to increase performances. This is unsafe and might be changed in future
 func PickRandomUserAgent(randGen *rand.Rand) string {
-	return userAgentsPool[randGen.Intn(len(userAgentsPool))]
+	userAgents := *userAgentsPool.Load()
+	return userAgents[randGen.Intn(len(userAgents))]
 }
 
 func PickRandomCookieJarSession(randGen *rand.Rand) *wtypes.CookieJarSession {
@@ -78,7 +102,8 @@ func PickRandomCookieJarSession(randGen *rand.Rand) *wtypes.CookieJarSession {
 }
 
 func pickRandomProfile(randGen *rand.Rand) *Profile {
-	return profilesPool[randGen.Intn(len(profilesPool))]
+	profiles := *profilesPool.Load()
+	return profiles[randGen.Intn(len(profiles))]
 }
 
 type implements struct {
@@ -101,3 +126,13 @@ type Headers struct {
 	ReferrerPolicy  string
 	Implements      implements
 }
+
+// ProxiesStats returns the state of the proxies of the pool, with their passwords redacted.
+func ProxiesStats() []proxypool.Stats {
+	return proxiesPool.Stats()
+}
+
+// ProxiesCounts returns the amount of proxies of the pool in cooldown and evicted.
+func ProxiesCounts() proxypool.Counts {
+	return proxiesPool.Counts()
+}
@@ -0,0 +1,48 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	assetshandler "crawler/app/pkg/assets-handler"
+)
+
+// how often the modification time of the pools files is checked
+const poolsPollInterval = 2 * time.Second
+
+// WatchProxiesFile reloads the proxies pool each time the file at path changes, until ctx is done.
+// If the new file is not valid, the current pool is kept.
+func WatchProxiesFile(ctx context.Context, path string) {
+	assetshandler.WatchFile(ctx, path, poolsPollInterval, func() {
+		proxies, err := assetshandler.ReadProxiesFile(path)
+		if err == nil {
+			err = LoadProxies(proxies)
+		}
+		if err != nil {
+			slog.Error(fmt.Sprintf("(Network): proxies not reloaded, the current pool is kept: %s", err.Error()))
+			return
+		}
+		slog.Info(fmt.Sprintf("(Network): reloaded %d proxies", len(proxies)))
+	})
+}
+
+// WatchUserAgentsFile reloads the user agents pool and regenerates the profiles
+// each time the file at path changes, until ctx is done.
+// If the new file is not valid, the current pools are kept.
+func WatchUserAgentsFile(ctx context.Context, path string) {
+	assetshandler.WatchFile(ctx, path, poolsPollInterval, func() {
+		userAgents, err := assetshandler.ReadUAsFile(path)
+		var profilesAmount int
+		if err == nil {
+			profilesAmount, err = ReloadUserAgents(userAgents)
+		}
+		if err != nil {
+			slog.Error(fmt.Sprintf("(Network): user agents not reloaded, the current pool is kept: %s", err.Error()))
+			return
+		}
+		slog.Info(fmt.Sprintf("(Network): reloaded %d user agents, generated %d network profiles",
+			len(userAgents), profilesAmount))
+	})
+}
@@ -0,0 +1,25 @@
+package network
+
+import (
+	"context"
+	"math/rand"
+	"net/url"
+	"time"
+
+	"crawler/app/pkg/assert"
+	assetshandler "crawler/app/pkg/assets-handler"
+	"crawler/app/pkg/utils/httpx"
+)
+
+// ProbeProxiesLoop probes the evicted proxies until ctx is done, by opening a tunnel
+// to the host of the base url and performing a TLS handshake over it (see httpx.ProbeProxy).
+func ProbeProxiesLoop(ctx context.Context, cfg *assetshandler.Config) {
+	baseUrl, err := url.Parse(cfg.Standard.Urls.BaseUrl)
+	assert.NoError(err, "base url must be valid to probe the proxies", assert.AssertData{"url": cfg.Standard.Urls.BaseUrl})
+
+	proxiesPool.ProbeLoop(ctx, func(ctx context.Context, proxyUrl *url.URL) error {
+		// the probes run concurrently, so each one has its own generator
+		randGen := rand.New(rand.NewSource(time.Now().UnixNano()))
+		return httpx.ProbeProxy(ctx, proxyUrl, baseUrl, pickRandomProfile(randGen).TLSClientHelloID, cfg.Http.Timeout)
+	})
+}
@@ -69,16 +69,25 @@ func FetchCookie(
 		return err
 	}
 
-	proxyUrl := pickRandomProxy(randGen)
+	proxy, err := proxiesPool.Pick(ctx, randGen)
+	if err != nil {
+		return err
+	}
 
-	response, err := httpx.MakeRequestWithProxyAndFingerprint(req, tmpJar, proxyUrl, reqProfile.TLSClientHelloID, cfg.Http.Timeout)
+	sentAt := time.Now()
+	response, err := httpx.MakeRequestWithProxyAndFingerprint(req, tmpJar, proxy.URL, reqProfile.TLSClientHelloID, cfg.Http.Timeout)
 	if err != nil {
+		proxiesPool.Report(proxy, 0, err)
 		return err
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusOK {
-		return customerrors.InferHttpError(response.StatusCode)
+		err = customerrors.InferHttpError(response.StatusCode)
+	}
+	proxiesPool.Report(proxy, time.Since(sentAt), err)
+	if err != nil {
+		return err
 	}
 
 	newCookies := tmpJar.Cookies(parsedUrl)
@@ -116,13 +125,15 @@ func FetchCookie(
 
 func FetchCookieLoop(
 	ctx context.Context,
-	cfg *assetshandler.Config,
+	sharedCfg *assetshandler.SharedConfig,
 	jar *http.CookieJar,
 	targetCookieNames []string,
 	randGen *rand.Rand,
 	logChan chan<- ctypes.LogData,
+	onFetched func(err error),
 ) {
 	for {
+		cfg := sharedCfg.Load()
 		time.Sleep(time.Duration(cfg.Http.CookiesRefreshDelay) * time.Second)
 
 		select {
@@ -130,6 +141,7 @@ func FetchCookieLoop(
 			return
 		default:
 			err := FetchCookie(ctx, cfg, jar, targetCookieNames, randGen)
+			onFetched(err)
 			if err != nil {
 				logChan <- ctypes.LogData{
 					Level: slog.LevelError,
@@ -213,15 +225,26 @@ func FetchDirectJSONUrl(
 		return nil, err
 	}
 
-	proxyUrl := pickRandomProxy(randGen)
+	// the proxy is picked once the request is ready, as the pick might wait
+	// for a proxy to come out of cooldown
+	proxy, err := proxiesPool.Pick(ctx, randGen)
+	if err != nil {
+		return nil, err
+	}
 
-	response, err := httpx.MakeRequestWithProxyAndFingerprint(req, jar, proxyUrl, reqProfile.TLSClientHelloID, timeout)
+	sentAt := time.Now()
+	response, err := httpx.MakeRequestWithProxyAndFingerprint(req, jar, proxy.URL, reqProfile.TLSClientHelloID, timeout)
 	if err != nil {
+		proxiesPool.Report(proxy, 0, err)
 		return nil, err
 	}
 
 	if response.StatusCode != http.StatusOK {
-		return nil, customerrors.InferHttpError(response.StatusCode)
+		err = customerrors.InferHttpError(response.StatusCode)
+	}
+	proxiesPool.Report(proxy, time.Since(sentAt), err)
+	if err != nil {
+		return nil, err
 	}
 
 	body, cleanup, err := httpx.DecompressResponseBody(response)
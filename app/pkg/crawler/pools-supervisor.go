@@ -0,0 +1,88 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+)
+
+// poolsSupervisor periodically resizes the worker pools to the amount of workers
+// needed to handle their load, so that idle workers are stopped and bursts are absorbed.
+type poolsSupervisor struct {
+	pools    []*workerPool
+	interval time.Duration
+
+	// the fraction of the time the workers should spend working on items,
+	// the rest being the headroom for the bursts
+	targetUtilization float64
+}
+
+// poolTotals is the usage of a pool at the previous resize.
+type poolTotals struct {
+	busy  time.Duration
+	items int64
+}
+
+// run resizes the pools every interval until ctx is done.
+func (ps *poolsSupervisor) run(ctx context.Context) {
+	lastTotals := make([]poolTotals, len(ps.pools))
+	for idx, pool := range ps.pools {
+		lastTotals[idx].busy, lastTotals[idx].items = pool.usage.Totals()
+	}
+
+	ticker := time.NewTicker(ps.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for idx, pool := range ps.pools {
+				var totals poolTotals
+				totals.busy, totals.items = pool.usage.Totals()
+
+				current := pool.usage.Workers()
+				desired := ps.desiredWorkers(
+					current, totals.busy-lastTotals[idx].busy, totals.items-lastTotals[idx].items, pool.backlog(),
+				)
+				lastTotals[idx] = totals
+
+				if resized := pool.resize(desired); resized != current {
+					slog.Info(fmt.Sprintf(
+						"(PoolsSupervisor): %s pool resized from %d to %d workers",
+						pool.usage.Name, current, resized,
+					))
+				}
+			}
+		}
+	}
+}
+
+// desiredWorkers returns the amount of workers a pool needs to handle in the next
+// interval as many items as in the last one plus its backlog, at the target utilization.
+// busy and items are the time spent working and the items handled in the last interval,
+// the average latency of an item being busy/items.
+func (ps *poolsSupervisor) desiredWorkers(current int, busy time.Duration, items int64, backlog int) int {
+	var desired int
+	switch {
+	case items > 0:
+		latency := float64(busy) / float64(items)
+		demand := float64(items) + float64(backlog)
+		desired = int(math.Ceil(demand * latency / (float64(ps.interval) * ps.targetUtilization)))
+	case backlog > 0:
+		// no item has been handled, the latency is unknown but the items are piling up
+		desired = max(current*2, 1)
+	default:
+		desired = 0
+	}
+
+	// the pools grow at once to absorb the bursts, but shrink gradually as the
+	// load of a single interval is not enough to tell that a burst is over
+	if desired < current {
+		desired = max(desired, current-int(math.Ceil(float64(current)/4)))
+	}
+	return desired
+}
@@ -2,30 +2,43 @@ package crawler
 
 import (
 	"fmt"
+	"math"
 
 	"crawler/app/pkg/assert"
 	assetshandler "crawler/app/pkg/assets-handler"
+	wtypes "crawler/app/pkg/crawler/workers/workers-types"
 	"crawler/app/pkg/thresholds"
 
 	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
 )
 
 type policyExprParams struct {
 	CurrentTimestamp uint32
 	NewTimestamp     uint32
-	ThresholdsAmount uint16
+	ThresholdsAmount uint32
+
+	// the delay percentiles (in milliseconds) of the last status interval
+	DelayP50 uint32
+	DelayP90 uint32
+	DelayP99 uint32
+	DelayMax uint32
+}
+
+func compilePolicyExpr(computeIncrementExpr string) (*vm.Program, error) {
+	return expr.Compile(computeIncrementExpr, expr.Env(policyExprParams{}))
 }
 
+// compilePolicies compiles the policies expressions.
+// delays is called on each evaluation to get the delay percentiles exposed to the expressions.
 func compilePolicies(
 	policiesCfgs []assetshandler.ThresholdsAdjPolicyCfg,
+	delays func() wtypes.DelayPercentiles,
 ) ([]*thresholds.ThresholdsAdjustmentPolicy, error) {
 	policies := make([]*thresholds.ThresholdsAdjustmentPolicy, len(policiesCfgs))
 
 	for idx, policyCfg := range policiesCfgs {
-		compiledExpr, err := expr.Compile(
-			policyCfg.ComputeIncrementExpr,
-			expr.Env(policyExprParams{}),
-		)
+		compiledExpr, err := compilePolicyExpr(policyCfg.ComputeIncrementExpr)
 		if err != nil {
 			return nil, fmt.Errorf(
 				"error compiling thresholds adjustment policy with percentage %.2f: %w",
@@ -35,11 +48,16 @@ func compilePolicies(
 
 		policies[idx] = &thresholds.ThresholdsAdjustmentPolicy{
 			Percentage: policyCfg.Percentage,
-			ComputeIncrement: func(currentTimestamp, newTimestamp uint32, thresholdsAmount uint16) int32 {
+			ComputeIncrement: func(currentTimestamp, newTimestamp uint32, thresholdsAmount uint32) int64 {
+				delayPercentiles := delays()
 				params := policyExprParams{
 					CurrentTimestamp: currentTimestamp,
 					NewTimestamp:     newTimestamp,
 					ThresholdsAmount: thresholdsAmount,
+					DelayP50:         delayPercentiles.P50,
+					DelayP90:         delayPercentiles.P90,
+					DelayP99:         delayPercentiles.P99,
+					DelayMax:         delayPercentiles.Max,
 				}
 
 				result, err := expr.Run(compiledExpr, params)
@@ -57,9 +75,15 @@ func compilePolicies(
 
 				switch r := result.(type) {
 				case float64:
-					return int32(r)
+					// the conversion of NaN is implementation defined, it leaves the amount unchanged
+					if math.IsNaN(r) {
+						return 0
+					}
+					// the amount is clamped by the controller anyway, clamping the float
+					// first avoids the undefined conversion of out of range values
+					return int64(min(max(r, -math.MaxUint32), math.MaxUint32))
 				case int:
-					return int32(r)
+					return int64(r)
 				default:
 					assert.Never("a non float64/int value has been returned by a "+
 						"successfully compiled thresholds adjustment expression",
@@ -0,0 +1,69 @@
+package crawler
+
+import (
+	wtypes "crawler/app/pkg/crawler/workers/workers-types"
+)
+
+// workerPool starts and stops the workers of a pool, keeping their amount
+// within its bounds. It is not safe for concurrent use: it is resized by the
+// pools supervisor only.
+type workerPool struct {
+	usage  *wtypes.PoolUsage
+	bounds poolBounds
+
+	// backlog returns the amount of items waiting to be received by the workers
+	backlog func() int
+
+	// startWorker starts a worker with the given ID, that returns when it
+	// receives from stopChan.
+	startWorker func(id int, stopChan <-chan struct{})
+
+	// buffered with the max amount of workers, so that stopping never blocks:
+	// the workers are only started when no stop is pending, so the running
+	// ones are never more than the max.
+	stopChan chan struct{}
+
+	// the ID of the last started worker, the IDs of the stopped ones are not reused
+	lastID int
+}
+
+// newWorkerPool returns an empty pool, see resize to start its workers.
+// The workers started by startWorker must report their usage to usage.
+func newWorkerPool(
+	usage *wtypes.PoolUsage,
+	bounds poolBounds,
+	backlog func() int,
+	startWorker func(id int, stopChan <-chan struct{}),
+) *workerPool {
+	return &workerPool{
+		usage:       usage,
+		bounds:      bounds,
+		backlog:     backlog,
+		startWorker: startWorker,
+		stopChan:    make(chan struct{}, bounds.max),
+	}
+}
+
+// resize starts or stops workers to reach the given amount, clamped to the bounds.
+// The stopped workers finish the item they are working on first.
+// It returns the new amount of workers.
+func (wp *workerPool) resize(workers int) int {
+	workers = int(min(max(uint64(max(workers, 0)), wp.bounds.min), wp.bounds.max))
+
+	current := wp.usage.Workers()
+	for ; current < workers; current++ {
+		select {
+		case <-wp.stopChan:
+			// a worker that has not received its stop yet keeps running instead
+		default:
+			wp.lastID++
+			wp.startWorker(wp.lastID, wp.stopChan)
+		}
+	}
+	for ; current > workers; current-- {
+		wp.stopChan <- struct{}{}
+	}
+
+	wp.usage.SetWorkers(workers)
+	return workers
+}
@@ -0,0 +1,95 @@
+package crawler
+
+import (
+	"math"
+
+	assetshandler "crawler/app/pkg/assets-handler"
+)
+
+// maxWorkersAmount is the highest amount of workers of a single pool.
+// Each worker is a goroutine and each pool has channels sized after it,
+// so higher amounts are most likely a mistake in the config.
+const maxWorkersAmount = 1 << 20
+
+// workersAmounts returns the amount of thresholds, subordinate and backup workers
+// to start with cfg. They are computed in uint64 so that no product of the config
+// values can overflow, and checked against maxWorkersAmount by checkWorkersConfig.
+func workersAmounts(cfg *assetshandler.Config) (thresholdsWks, subordinateWks, backupWks uint64) {
+	// if this amount of thresholds is exceeded the system will slow down,
+	// unless the pools are scaled (see workersBounds).
+	thresholdsWks = uint64(cfg.Core.ExpMaxThresholdsAmount)
+
+	subordinateWks = uint64(cfg.Core.ThresholdsOffset) * thresholdsWks
+
+	// Let's rename "subordinateWks" to N, "maxRetriesPerItem" to M and
+	// "delayBetweenRetries" to D.
+	//
+	// Hypothesize all requests fail and each one takes the same amount of T ms.
+	// In this situation, each subordinate worker will produce a backup packet every T ms,
+	// while each backup worker will take M*(T+D) ms to consume it.
+	//
+	// Being X the amount of backup workers needed to exactly match the
+	// production and consumption rates, X will need to satisfy the following equation:
+	// X/[M*(T+D)] = N/T ==> X = N*M*(T+D)/T = N*M*(1+D/T).
+	// In the hypothesized scenario, with this amount of backup workers,
+	// a backup channel size of N*M*(1+D/T) is never exceeded.
+	//
+	// Since a GET request ideally takes less than 1 second to complete, assuming
+	// T = 1000 ms is a good approximation.
+	// In this case, the amount of backup workers needed is N*M*(1+D/1000).
+	//
+	// In practice, each request takes a different amount of time to complete
+	// and little delays are introduced all the time.
+	// To take care of this, the backup channel size is tripled.
+	//
+	// The amount of backup workers could also be tripled instead but, since
+	// the hypothesis of all requests failing is very pessimistic, it would only
+	// waste many resources without any actual benefit.
+	//
+	// N is at most (2^32-1)^2 and the other factors are small, so the product
+	// saturates instead of overflowing: such amounts are rejected anyway.
+	maxRetriesPerItem := max(uint64(cfg.Http.MaxRetriesPerItem), 1)
+	delayFactor := 1 + uint64(math.Ceil(float64(cfg.Http.DelayBetweenRetries)/1000))
+	backupWks = saturatingMul(saturatingMul(subordinateWks, maxRetriesPerItem), delayFactor)
+
+	return thresholdsWks, subordinateWks, backupWks
+}
+
+// saturatingMul returns a*b, or math.MaxUint64 if the product overflows.
+func saturatingMul(a, b uint64) uint64 {
+	if a != 0 && b > math.MaxUint64/a {
+		return math.MaxUint64
+	}
+	return a * b
+}
+
+// poolBounds is the range the amount of workers of a pool is kept within.
+type poolBounds struct {
+	min, max uint64
+}
+
+// workersBounds returns the bounds of the thresholds, subordinate and backup pools.
+// Without scaling, the pools have the fixed amounts of workersAmounts. With scaling,
+// the unset bounds default to 1 worker and to the amounts of workersAmounts.
+func workersBounds(cfg *assetshandler.Config) (thresholdsWks, subordinateWks, backupWks poolBounds) {
+	thresholdsAmount, subordinateAmount, backupAmount := workersAmounts(cfg)
+	if !cfg.Scaling.Enabled {
+		return poolBounds{thresholdsAmount, thresholdsAmount},
+			poolBounds{subordinateAmount, subordinateAmount},
+			poolBounds{backupAmount, backupAmount}
+	}
+
+	scalingBounds := func(cfgBounds assetshandler.PoolBounds, amount uint64) poolBounds {
+		bounds := poolBounds{min: 1, max: amount}
+		if cfgBounds.MinWorkers > 0 {
+			bounds.min = uint64(cfgBounds.MinWorkers)
+		}
+		if cfgBounds.MaxWorkers > 0 {
+			bounds.max = uint64(cfgBounds.MaxWorkers)
+		}
+		return bounds
+	}
+	return scalingBounds(cfg.Scaling.Thresholds, thresholdsAmount),
+		scalingBounds(cfg.Scaling.Subordinate, subordinateAmount),
+		scalingBounds(cfg.Scaling.Backup, backupAmount)
+}
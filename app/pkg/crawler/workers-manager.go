@@ -5,6 +5,7 @@ import (
 	"slices"
 
 	assetshandler "crawler/app/pkg/assets-handler"
+	"crawler/app/pkg/checkpoint"
 	wtypes "crawler/app/pkg/crawler/workers/workers-types"
 	"crawler/app/pkg/thresholds"
 )
@@ -14,30 +15,59 @@ type workersManager struct {
 	thresholdsController *thresholds.ThresholdsController
 
 	// The offset to keep between each ID threshold.
-	offset uint16
+	// It is randomized on each batch around initialOffset.
+	offset uint32
+
+	// The configured offset, used as a reference for the randomized one.
+	initialOffset uint32
+
+	// The ID of the first batch that will be generated.
+	// It is greater than 1 only when resuming from a checkpoint.
+	firstBatchID uint16
+
+	// A tracker used to commit the state of the manager at the end of each batch
+	// and to report the IDs dispatched to the subordinate workers. It can be nil.
+	tracker *checkpoint.Tracker
+
+	// Used to pause the manager and to change its HighestID between two batches.
+	control *managerControl
 
 	rand *rand.Rand
 }
 
+// run generates the batches until the manager is stopped (see managerControl.Stop).
 func (wkM *workersManager) run(
 	thresholdsWkIDsChan chan<- *wtypes.ItemFromBatchPacket,
 	thresholdsWkResultsChan <-chan *wtypes.ThresholdsWorkerResult,
 	subordinateWkChan chan<- *wtypes.ItemFromBatchPacket,
 	successfulItemsChan chan<- *wtypes.ContentElement,
 	state *wtypes.State,
-	batchLimits *assetshandler.BatchLimits,
+	sharedCfg *assetshandler.SharedConfig,
 ) {
 	var result *wtypes.ThresholdsWorkerResult
 	var highestThresholdID int = state.HighestID
-	var initialOffset uint16 = wkM.offset
+	var initialOffset uint32 = wkM.initialOffset
 
-	var batchID uint16 = 1
+	var batchID uint16 = max(wkM.firstBatchID, 1)
 
 	for {
+		wkM.control.waitIfPaused()
+		if wkM.control.stopped() {
+			return
+		}
+		if highestID, ok := wkM.control.takeHighestIDJump(); ok {
+			highestThresholdID = highestID
+
+			state.Mu.Lock()
+			state.HighestID = highestID
+			state.Mu.Unlock()
+		}
+
 		lastSuccID := highestThresholdID
 		thresholdsAmount := wkM.thresholdsController.GetThresholdsAmount()
 		results := make(map[int]*wtypes.ThresholdsWorkerResult, thresholdsAmount)
-		wkM.offset += uint16(wkM.rand.Intn(3) - 1) // -1, 0, or 1
+		// -1, 0, or 1, computed in int64 to not wrap around 0
+		wkM.offset = uint32(max(int64(wkM.offset)+int64(wkM.rand.Intn(3)-1), 0))
 
 		// update state for logging
 		state.Mu.Lock()
@@ -46,11 +76,11 @@ func (wkM *workersManager) run(
 		state.Mu.Unlock()
 
 		// avoid too big or too small / negative offsets
-		if wkM.offset >= 2*initialOffset || wkM.offset <= uint16(0.5*float32(initialOffset)) {
+		if uint64(wkM.offset) >= 2*uint64(initialOffset) || wkM.offset <= uint32(0.5*float64(initialOffset)) {
 			wkM.offset = initialOffset
 		}
 
-		for i := uint16(0); i < thresholdsAmount; i++ {
+		for i := uint32(0); i < thresholdsAmount; i++ {
 			highestThresholdID += int(wkM.offset)
 			thresholdsWkIDsChan <- &wtypes.ItemFromBatchPacket{
 				ItemID:  highestThresholdID,
@@ -73,6 +103,9 @@ func (wkM *workersManager) run(
 				successfulItemsChan <- &wtypes.ContentElement{
 					Content:   result.Item,
 					ContentID: result.ItemID,
+					BatchID:   result.BatchID,
+					Delay:     result.Timestamp,
+					FetchedAt: result.FetchedAt,
 				}
 			}
 
@@ -97,7 +130,10 @@ func (wkM *workersManager) run(
 		} else {
 			timestamp = result.Timestamp
 
-			if batchLimits.EnableBatchLimits && thresholdsAmount*wkM.offset > batchLimits.MaxBatchSize {
+			batchLimits := sharedCfg.Load().Core.BatchLimits
+			// the batch size is computed in uint64 as both factors are uint32
+			batchSize := uint64(thresholdsAmount) * uint64(wkM.offset)
+			if batchLimits.EnableBatchLimits && batchSize > uint64(batchLimits.MaxBatchSize) {
 				lastSuccID = highestThresholdID
 			} else {
 				// let thresholdsIDs be the set of IDs successfully fetched by the thresholds workers.
@@ -116,6 +152,7 @@ func (wkM *workersManager) run(
 				for count := 0; count < succThresholdIDsLen; count++ {
 					interruptID := succThresholdIDs[count]
 					for id := lastSuccID + 1; id < interruptID; id++ {
+						wkM.tracker.Dispatch(id, batchID)
 						subordinateWkChan <- &wtypes.ItemFromBatchPacket{
 							ItemID:  id,
 							BatchID: batchID,
@@ -132,7 +169,6 @@ func (wkM *workersManager) run(
 		state.HighestID = highestThresholdID
 		state.HitThresholdLevels = append(state.HitThresholdLevels, thresholdsAmount)
 		state.Mu.Unlock()
-		batchID++
 
 		wkM.thresholdsController.Update(
 			&thresholds.ThresholdsControllerInput{
@@ -140,5 +176,14 @@ func (wkM *workersManager) run(
 				Timestamp:      timestamp,
 			},
 		)
+
+		wkM.tracker.CommitBatch(checkpoint.BatchCommit{
+			HighestID:        highestThresholdID,
+			BatchID:          batchID,
+			Offset:           wkM.offset,
+			ThresholdsAmount: wkM.thresholdsController.GetThresholdsAmount(),
+			CurrentTimestamp: wkM.thresholdsController.GetCurrentTimestamp(),
+		})
+		batchID++
 	}
 }
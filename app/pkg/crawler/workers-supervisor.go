@@ -0,0 +1,117 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"crawler/app/pkg/assert"
+	wtypes "crawler/app/pkg/crawler/workers/workers-types"
+)
+
+// the amount of crashes kept for the admin API
+const keptCrashesAmount = 100
+
+// workersSupervisor runs the workers and restarts them with backoff when they crash,
+// so that a single malformed item does not stop the crawl. If more than maxRestarts
+// restarts happen within window, something is deeply wrong and the crawler is shut down.
+type workersSupervisor struct {
+	ctx     context.Context
+	outcome *wtypes.Outcome
+
+	maxRestarts    int
+	window         time.Duration
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	mu sync.Mutex
+	// the times of the restarts within window
+	restarts []time.Time
+	// the last keptCrashesAmount crashes, oldest first
+	crashes []wtypes.WorkerCrash
+}
+
+// supervise calls runWorker in a new goroutine, and again each time the worker
+// crashes. runWorker must build a new worker with onCrash as its OnCrash and run it
+// until it returns: the worker is not restarted if it returns without crashing
+// (e.g. because its context is done or its pool has been shrunk).
+func (ws *workersSupervisor) supervise(runWorker func(onCrash func(crash *wtypes.WorkerCrash))) {
+	go func() {
+		backoff := ws.initialBackoff
+		for {
+			var crash *wtypes.WorkerCrash
+			startedAt := time.Now()
+			runWorker(func(c *wtypes.WorkerCrash) { crash = c })
+			if crash == nil {
+				return
+			}
+
+			ws.record(crash)
+
+			// a worker that had been running for a while did not crash in a loop
+			if time.Since(startedAt) > ws.maxBackoff {
+				backoff = ws.initialBackoff
+			}
+			select {
+			case <-ws.ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, ws.maxBackoff)
+		}
+	}()
+}
+
+// record logs and stores crash, and shuts the crawler down if the restarts budget is exceeded.
+func (ws *workersSupervisor) record(crash *wtypes.WorkerCrash) {
+	var item string
+	if crash.ItemID != 0 {
+		item = fmt.Sprintf(" on item (ID %d)", crash.ItemID)
+	}
+	slog.Error(fmt.Sprintf(
+		"(WorkersSupervisor): %s worker %d crashed%s, restarting it: %s\n%s",
+		crash.Pool, crash.WorkerID, item, crash.Reason, crash.Stack,
+	))
+
+	ws.outcome.Mu.Lock()
+	ws.outcome.Crashes++
+	ws.outcome.Mu.Unlock()
+
+	ws.mu.Lock()
+	ws.crashes = append(ws.crashes, *crash)
+	if len(ws.crashes) > keptCrashesAmount {
+		ws.crashes = slices.Delete(ws.crashes, 0, len(ws.crashes)-keptCrashesAmount)
+	}
+
+	ws.restarts = append(ws.restarts, crash.At)
+	windowStart := crash.At.Add(-ws.window)
+	ws.restarts = slices.DeleteFunc(ws.restarts, func(restart time.Time) bool {
+		return restart.Before(windowStart)
+	})
+	restartsInWindow := len(ws.restarts)
+	ws.mu.Unlock()
+
+	assert.Assert(
+		restartsInWindow <= ws.maxRestarts,
+		"workers restarts budget exceeded, the crawler is shut down",
+		assert.AssertData{
+			"restarts":   restartsInWindow,
+			"window":     ws.window.String(),
+			"lastPool":   crash.Pool,
+			"lastWorker": crash.WorkerID,
+			"lastItemID": crash.ItemID,
+			"lastReason": crash.Reason,
+		},
+	)
+}
+
+// Crashes returns the last crashes, oldest first.
+func (ws *workersSupervisor) Crashes() []wtypes.WorkerCrash {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	// never nil, so that no crash is rendered as an empty list
+	return append([]wtypes.WorkerCrash{}, ws.crashes...)
+}
@@ -11,10 +11,12 @@ import (
 
 	"crawler/app/pkg/assert"
 	assetshandler "crawler/app/pkg/assets-handler"
+	"crawler/app/pkg/checkpoint"
 	"crawler/app/pkg/crawler/network"
 	wtypes "crawler/app/pkg/crawler/workers/workers-types"
 	ctypes "crawler/app/pkg/custom-types"
 	customerrors "crawler/app/pkg/custom-types/custom-errors"
+	"crawler/app/pkg/ratelimit"
 )
 
 type BackupWorker struct {
@@ -30,20 +32,32 @@ type BackupWorker struct {
 	// ResultsChan is used to send successful fetches results to something that processes them.
 	ResultsChan chan<- *wtypes.ContentElement
 
-	// MaxRetries specifies the maximum amount of retries the backup worker can do
-	// on each item before skipping it and labeling it as lost / non existing.
-	MaxRetries int16
+	// RateLimiter spreads the requests of all the workers within the configured
+	// budgets, and adapts them to the rate limits of the server. It can be nil.
+	RateLimiter *ratelimit.Limiter
 
-	// Delay specifies the amount of time in milliseconds the backup worker
-	// will wait between each request of the same item.
-	Delay uint64
+	// Tracker is used to report the items that have been either recovered or lost,
+	// so that they are not checkpointed anymore. It can be nil.
+	Tracker *checkpoint.Tracker
+
+	// Usage accumulates the time the worker spends working on items,
+	// for the pool utilization reported in the status log. It can be nil.
+	Usage *wtypes.PoolUsage
+
+	// StopChan is used to shrink the pool of the worker: the worker returns when
+	// it receives from it, never in the middle of an item. It can be nil.
+	StopChan <-chan struct{}
+
+	// OnCrash is called with the panics recovered in the worker, which then returns.
+	// The item the worker was working on is labeled as lost. If nil, the panic is propagated.
+	OnCrash func(crash *wtypes.WorkerCrash)
 
 	Rand  *rand.Rand
 	Fatal error
 }
 
 func (bWk *BackupWorker) Run(
-	cfg *assetshandler.Config,
+	sharedCfg *assetshandler.SharedConfig,
 	state *wtypes.State,
 	outcome *wtypes.Outcome,
 ) {
@@ -51,15 +65,26 @@ func (bWk *BackupWorker) Run(
 	defer close(logChan)
 	go bWk.log(logChan)
 
+	// true if the worker has been stopped through StopChan
+	var stopped bool
+
+	// the ID of the item the worker is responsible for, 0 once it has been resolved
+	var currentItemID int
+
 	defer func() {
 		if r := recover(); r != nil {
-			if err, ok := r.(error); ok {
-				bWk.Fatal = err
-			} else {
-				bWk.Fatal = fmt.Errorf("recover panic: %v", r)
+			bWk.Fatal = recoveredError(r)
+			if bWk.OnCrash == nil {
+				panic(r)
 			}
-			panic(r)
-		} else {
+
+			crash := newWorkerCrash("backup", bWk.ID, currentItemID, r)
+			if currentItemID != 0 {
+				bWk.Tracker.Resolve(currentItemID)
+				outcome.Update(func(o *wtypes.Outcome) { o.Lost++ })
+			}
+			bWk.OnCrash(crash)
+		} else if !stopped {
 			assert.NotNil(
 				bWk.Fatal,
 				"at this point worker must have a done ctx error. an unexpected error occurred",
@@ -77,16 +102,26 @@ func (bWk *BackupWorker) Run(
 		case <-bWk.Ctx.Done():
 			bWk.Fatal = fmt.Errorf("worker %v ctx done", bWk.ID)
 			return
-		case itemPacket := <-bWk.ItemsBackupPacketChan:
-			if func() int {
-				outcome.Mu.Lock()
-				defer outcome.Mu.Unlock()
-				return outcome.RateLimits
-			}() > cfg.Http.MaxRateLimitsPerSecond {
-				time.Sleep((time.Duration)(cfg.Http.RateLimitWait) * time.Second)
+		case <-bWk.StopChan:
+			stopped = true
+			logChan <- ctypes.LogData{
+				Level: slog.LevelDebug,
+				Msg:   "Worker stopped as its pool has been shrunk",
 			}
+			return
+		case itemPacket := <-bWk.ItemsBackupPacketChan:
+			busySince := time.Now()
+			cfg := sharedCfg.Load()
+
+			// the maximum amount of retries the worker can do on the item before
+			// skipping it and labeling it as lost / non existing
+			maxRetries := int16(cfg.Http.MaxRetriesPerItem) - 1
+
+			// the time waited for the rate limiter, which is not spent working on the item
+			var limiterWait time.Duration
 
 			var itemID int = itemPacket.ItemID
+			currentItemID = itemID
 			var appendedSuffix bool = itemPacket.AppendSuffix
 
 			var url string = cfg.Standard.Urls.ItemUrl + strconv.Itoa(itemID)
@@ -98,7 +133,7 @@ func (bWk *BackupWorker) Run(
 			var retriesAmount int16
 			var s401, s429, s404, sOther uint8
 			for {
-				if retriesAmount > bWk.MaxRetries {
+				if retriesAmount > maxRetries {
 					var retrySingPlur string
 					if retriesAmount > 0 {
 						retrySingPlur = "retries"
@@ -111,21 +146,45 @@ func (bWk *BackupWorker) Run(
 							itemID, retriesAmount, retrySingPlur, s401, s404, s429, sOther),
 					}
 
-					outcome.Mu.Lock()
-					outcome.Lost++
-					outcome.Mu.Unlock()
+					outcome.Update(func(o *wtypes.Outcome) { o.Lost++ })
+
+					bWk.Tracker.Resolve(itemID)
+					currentItemID = 0
 
 					break
 				}
 
 				if retriesAmount > 0 {
-					time.Sleep((time.Duration)(bWk.Delay) * time.Millisecond)
+					retryTimer := time.NewTimer((time.Duration)(cfg.Http.DelayBetweenRetries) * time.Millisecond)
+					select {
+					case <-bWk.Ctx.Done():
+						retryTimer.Stop()
+						// the item is left in the tracker, so that it is checkpointed
+						bWk.Fatal = fmt.Errorf("worker %v ctx done", bWk.ID)
+						return
+					case <-retryTimer.C:
+					}
+				}
+
+				waitSince := time.Now()
+				if err := bWk.RateLimiter.Wait(bWk.Ctx, ratelimit.ItemEndpoint); err != nil {
+					bWk.Fatal = fmt.Errorf("worker %v ctx done", bWk.ID)
+					return
 				}
+				limiterWait += time.Since(waitSince)
 
 				cookieJarSession := network.PickRandomCookieJarSession(bWk.Rand)
 
 				decodedResp, err := network.FetchDirectJSONUrl(bWk.Ctx, url, cookieJarSession.CookieJar, cfg.Http.Timeout, bWk.Rand)
+				bWk.RateLimiter.Observe(ratelimit.ItemEndpoint, errors.Is(err, customerrors.ErrorRateLimit))
 				if err != nil {
+					if bWk.Ctx.Err() != nil {
+						// the item is not labeled as lost but left in the tracker,
+						// so that it is checkpointed and fetched again on resume
+						bWk.Fatal = fmt.Errorf("worker %v ctx done", bWk.ID)
+						return
+					}
+
 					switch {
 					case errors.Is(err, customerrors.ErrorUnauthorized):
 						select {
@@ -144,35 +203,42 @@ func (bWk *BackupWorker) Run(
 					continue
 				}
 
-				bWk.ResultsChan <- &wtypes.ContentElement{
-					Content:   decodedResp,
-					ContentID: itemID,
-				}
+				fetchedAt := time.Now()
+
+				delay, err := parseItemDelay(cfg, decodedResp, appendedSuffix)
+				if err != nil {
+					// fetching it again would give the same content, so it is labeled as lost
+					bWk.Tracker.Resolve(itemID)
+					currentItemID = 0
 
-				outcome.Mu.Lock()
-				outcome.Recovered++
-				outcome.Mu.Unlock()
+					outcome.Update(func(o *wtypes.Outcome) { o.Malformed++ })
 
-				var tsKey string
-				if appendedSuffix {
-					tsKey = cfg.Standard.ItemResponse.TimestampSuffix
-				} else {
-					tsKey = cfg.Standard.ItemResponse.Timestamp
+					logChan <- ctypes.LogData{
+						Level: slog.LevelWarn,
+						Msg:   fmt.Sprintf("item (ID %v) skipped. %s", itemID, err.Error()),
+					}
+					break
 				}
 
-				item := decodedResp[cfg.Standard.ItemResponse.Item].(map[string]interface{})
-				rawTs := item[tsKey].(string)
-				parsedTs, err := time.Parse(cfg.Standard.TimestampFormat, rawTs)
-				assert.NoError(err, "timestamp must be parsed successfully")
+				select {
+				case <-bWk.Ctx.Done():
+					// the item is left in the tracker, so that it is checkpointed
+					bWk.Fatal = fmt.Errorf("worker %v ctx done", bWk.ID)
+					return
+				case bWk.ResultsChan <- &wtypes.ContentElement{
+					Content:   decodedResp,
+					ContentID: itemID,
+					BatchID:   itemPacket.BatchID,
+					Delay:     delay,
+					FetchedAt: fetchedAt,
+				}:
+				}
+				bWk.Tracker.Resolve(itemID)
+				currentItemID = 0
 
-				// it can happen that a server displays some items with a timestamp
-				// in the future for internal sync issues, so we make sure to keep
-				// the delay positive
-				delay := uint32(max(int(time.Since(parsedTs).Milliseconds()), 0))
+				outcome.Update(func(o *wtypes.Outcome) { o.Recovered++ })
 
-				state.Mu.Lock()
-				state.Delays = append(state.Delays, delay)
-				state.Mu.Unlock()
+				state.RecordDelay(delay)
 
 				// XXX: In production this can be removed for increased performance
 				var retrySingPlur string
@@ -189,6 +255,8 @@ func (bWk *BackupWorker) Run(
 
 				break
 			}
+
+			bWk.Usage.AddBusy(time.Since(busySince) - limiterWait)
 		}
 	}
 }
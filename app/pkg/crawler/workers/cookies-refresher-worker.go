@@ -28,23 +28,26 @@ type CookiesRefreshWorker struct {
 	// This is useful to notify the Run caller that the session is ready to be used.
 	OnSessionReady func()
 
+	// OnCrash is called with the panics recovered in the worker, which then returns.
+	// If nil, the panic is propagated.
+	OnCrash func(crash *wtypes.WorkerCrash)
+
 	Rand  *rand.Rand
 	Fatal error
 }
 
-func (cWk *CookiesRefreshWorker) Run(cfg *assetshandler.Config, targetCookieNames []string) {
+func (cWk *CookiesRefreshWorker) Run(sharedCfg *assetshandler.SharedConfig, targetCookieNames []string) {
 	logChan := make(chan ctypes.LogData, 1000)
 	defer close(logChan)
 	go cWk.log(logChan)
 
 	defer func() {
 		if r := recover(); r != nil {
-			if err, ok := r.(error); ok {
-				cWk.Fatal = err
-			} else {
-				cWk.Fatal = fmt.Errorf("recover panic: %v", r)
+			cWk.Fatal = recoveredError(r)
+			if cWk.OnCrash == nil {
+				panic(r)
 			}
-			panic(r)
+			cWk.OnCrash(newWorkerCrash("cookies", cWk.ID, 0, r))
 		} else {
 			assert.NotNil(
 				cWk.Fatal,
@@ -58,8 +61,9 @@ func (cWk *CookiesRefreshWorker) Run(cfg *assetshandler.Config, targetCookieName
 		}
 	}()
 
-	err := network.FetchCookie(cWk.Ctx, cfg, &cWk.CookieJarSession.CookieJar, targetCookieNames, cWk.Rand)
-	if cfg.Http.CrashOnFirstCookieFetchError {
+	err := network.FetchCookie(cWk.Ctx, sharedCfg.Load(), &cWk.CookieJarSession.CookieJar, targetCookieNames, cWk.Rand)
+	cWk.CookieJarSession.RecordRefresh(err)
+	if sharedCfg.Load().Http.CrashOnFirstCookieFetchError {
 		assert.NoError(err, "error fetching first cookie", assert.AssertData{"CWorkerID": cWk.ID})
 	}
 
@@ -70,7 +74,10 @@ func (cWk *CookiesRefreshWorker) Run(cfg *assetshandler.Config, targetCookieName
 		Msg:   "First cookie fetched",
 	}
 
-	go network.FetchCookieLoop(cWk.Ctx, cfg, &cWk.CookieJarSession.CookieJar, targetCookieNames, cWk.Rand, logChan)
+	go network.FetchCookieLoop(
+		cWk.Ctx, sharedCfg, &cWk.CookieJarSession.CookieJar, targetCookieNames, cWk.Rand, logChan,
+		cWk.CookieJarSession.RecordRefresh,
+	)
 
 	for {
 		select {
@@ -78,7 +85,14 @@ func (cWk *CookiesRefreshWorker) Run(cfg *assetshandler.Config, targetCookieName
 			cWk.Fatal = fmt.Errorf("worker %v ctx done", cWk.ID)
 			return
 		case <-cWk.CookieJarSession.RefreshChan:
-			network.FetchCookie(cWk.Ctx, cfg, &cWk.CookieJarSession.CookieJar, targetCookieNames, cWk.Rand)
+			err := network.FetchCookie(cWk.Ctx, sharedCfg.Load(), &cWk.CookieJarSession.CookieJar, targetCookieNames, cWk.Rand)
+			cWk.CookieJarSession.RecordRefresh(err)
+			if err != nil {
+				logChan <- ctypes.LogData{
+					Level: slog.LevelError,
+					Msg:   fmt.Sprintf("error force refreshing cookie: %v", err),
+				}
+			}
 
 			// discard all other refresh requests that might have come in while fetching the cookie
 		L:
@@ -0,0 +1,47 @@
+package workers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	assetshandler "crawler/app/pkg/assets-handler"
+)
+
+// ErrMalformedItem is returned when a fetched item does not have the shape
+// described by the config (see assetshandler.Config.Standard.ItemResponse).
+var ErrMalformedItem = errors.New("malformed item")
+
+// parseItemDelay returns the delay in milliseconds between the publication of the
+// item in decodedResp and now. appendedSuffix tells whether the item url suffix
+// has been appended in the request, which changes the timestamp key.
+func parseItemDelay(
+	cfg *assetshandler.Config,
+	decodedResp map[string]interface{},
+	appendedSuffix bool,
+) (uint32, error) {
+	var tsKey string
+	if appendedSuffix {
+		tsKey = cfg.Standard.ItemResponse.TimestampSuffix
+	} else {
+		tsKey = cfg.Standard.ItemResponse.Timestamp
+	}
+
+	item, ok := decodedResp[cfg.Standard.ItemResponse.Item].(map[string]interface{})
+	if !ok {
+		return 0, fmt.Errorf("%w: %q is not an object", ErrMalformedItem, cfg.Standard.ItemResponse.Item)
+	}
+	rawTs, ok := item[tsKey].(string)
+	if !ok {
+		return 0, fmt.Errorf("%w: %q is not a string", ErrMalformedItem, tsKey)
+	}
+	parsedTs, err := time.Parse(cfg.Standard.TimestampFormat, rawTs)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrMalformedItem, err)
+	}
+
+	// it can happen that a server displays some items with a timestamp
+	// in the future for internal sync issues, so we make sure to keep
+	// the delay positive
+	return uint32(max(int(time.Since(parsedTs).Milliseconds()), 0)), nil
+}
@@ -0,0 +1,235 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"crawler/app/pkg/assert"
+	wtypes "crawler/app/pkg/crawler/workers/workers-types"
+	ctypes "crawler/app/pkg/custom-types"
+	"crawler/app/pkg/sinks"
+)
+
+// SinkWorker is a worker that writes the contents received from ContentsChan
+// to a sink (e.g. the websocket servers, see the sinks package).
+//
+// The contents are written one at a time, in the order they are received, so a slow
+// sink slows the whole crawl down: a write is always waited for before the next one,
+// and it is reported in the logs while it takes longer than the slow write delay.
+//
+// This struct does not implement the Worker interface.
+// However, the directory is the same for structural organization purposes.
+type SinkWorker struct {
+	ID  int
+	Ctx context.Context
+
+	// ContentsChan is used to receive the contents to write to the sink.
+	// The received type is a ContentElement object that contains
+	// the result JSON object (represented with a map[string]interface{})
+	// along with the content ID (for logging).
+	ContentsChan <-chan *wtypes.ContentElement
+
+	// The sink all contents are written to.
+	// It is closed by the worker once Ctx is done.
+	Sink sinks.Sink
+
+	// StopChan is used to stop the worker on shutdown: the worker writes the
+	// contents already in ContentsChan, closes the received channel and returns,
+	// leaving the sink open to be flushed and closed by the caller. It can be nil.
+	StopChan <-chan chan struct{}
+
+	// The amount of seconds between two health checks of the sink.
+	// If it is 0, a default of 10 seconds is used.
+	HealthCheckSeconds int
+
+	// The amount of seconds after which a write still in progress is reported,
+	// and then again at the same interval. If it is 0, a default of 10 seconds is used.
+	SlowWriteSeconds int
+
+	// OnCrash is called with the panics recovered in the worker, which then returns.
+	// The content the worker was writing is dropped. If nil, the panic is propagated.
+	OnCrash func(crash *wtypes.WorkerCrash)
+
+	Fatal error
+}
+
+func (skWk *SinkWorker) Run() {
+	logChan := make(chan ctypes.LogData, 1000)
+	defer close(logChan)
+	go skWk.log(logChan)
+
+	// the ID of the content being written, 0 if none
+	var currentContentID int
+
+	// true if the worker has been stopped through StopChan
+	var stopped bool
+
+	defer func() {
+		if r := recover(); r != nil {
+			skWk.Fatal = recoveredError(r)
+			if skWk.OnCrash == nil {
+				panic(r)
+			}
+			skWk.OnCrash(newWorkerCrash("sink", skWk.ID, currentContentID, r))
+		} else if !stopped {
+			assert.NotNil(
+				skWk.Fatal,
+				"at this point worker must have a done ctx error. an unexpected error occurred",
+				assert.AssertData{"WorkerID": skWk.ID},
+			)
+			logChan <- ctypes.LogData{
+				Level: slog.LevelError,
+				Msg:   "Worker finished due to context done",
+			}
+		}
+	}()
+
+	healthCheckSeconds := skWk.HealthCheckSeconds
+	if healthCheckSeconds <= 0 {
+		healthCheckSeconds = 10
+	}
+	healthTicker := time.NewTicker((time.Duration)(healthCheckSeconds) * time.Second)
+	defer healthTicker.Stop()
+
+	slowWriteSeconds := skWk.SlowWriteSeconds
+	if slowWriteSeconds <= 0 {
+		slowWriteSeconds = 10
+	}
+	slowWrite := (time.Duration)(slowWriteSeconds) * time.Second
+
+	for {
+		select {
+		case <-skWk.Ctx.Done():
+			skWk.Fatal = fmt.Errorf("worker %v ctx done", skWk.ID)
+			skWk.closeSink(logChan)
+			return
+		case done := <-skWk.StopChan:
+			for drained := false; !drained; {
+				select {
+				case contentEl := <-skWk.ContentsChan:
+					currentContentID = contentEl.ContentID
+					if !skWk.write(contentEl, slowWrite, logChan) {
+						skWk.Fatal = fmt.Errorf("worker %v ctx done", skWk.ID)
+						return
+					}
+					currentContentID = 0
+				default:
+					drained = true
+				}
+			}
+			close(done)
+
+			stopped = true
+			logChan <- ctypes.LogData{
+				Level: slog.LevelInfo,
+				Msg:   "Worker stopped after writing the remaining contents",
+			}
+			return
+		case <-healthTicker.C:
+			if err := skWk.Sink.Health(); err != nil {
+				logChan <- ctypes.LogData{
+					Level: slog.LevelWarn,
+					Msg:   fmt.Sprintf("sink %s is unhealthy: %s", skWk.Sink.Name(), err.Error()),
+				}
+			}
+		case contentEl := <-skWk.ContentsChan:
+			currentContentID = contentEl.ContentID
+			if !skWk.write(contentEl, slowWrite, logChan) {
+				skWk.Fatal = fmt.Errorf("worker %v ctx done", skWk.ID)
+				return
+			}
+			currentContentID = 0
+		}
+	}
+}
+
+// sinkWriteResult is the outcome of a write, either its error or the value of its panic.
+type sinkWriteResult struct {
+	err   error
+	crash any
+}
+
+// write writes the content to the sink and waits for the write to return, reporting it
+// every slowWrite while it is in progress. The panics of the write are propagated to the worker.
+//
+// If Ctx is done during the write, the sink is closed and false is returned without
+// waiting for the write anymore, as the sink may wait for it to be closed: being the last
+// write of the worker, at most one write is left in progress.
+func (skWk *SinkWorker) write(
+	contentEl *wtypes.ContentElement,
+	slowWrite time.Duration,
+	logChan chan<- ctypes.LogData,
+) bool {
+	resultChan := make(chan sinkWriteResult, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				resultChan <- sinkWriteResult{crash: r}
+			}
+		}()
+		resultChan <- sinkWriteResult{err: skWk.Sink.Write(contentEl)}
+	}()
+
+	slowTicker := time.NewTicker(slowWrite)
+	defer slowTicker.Stop()
+	start := time.Now()
+
+	for {
+		select {
+		case result := <-resultChan:
+			if result.crash != nil {
+				panic(result.crash)
+			}
+			if result.err != nil {
+				logChan <- ctypes.LogData{
+					Level: slog.LevelError,
+					Msg: fmt.Sprintf(
+						"error writing item (ID %d) to sink %s: %s",
+						contentEl.ContentID, skWk.Sink.Name(), result.err.Error(),
+					),
+				}
+			}
+			return true
+		case <-slowTicker.C:
+			logChan <- ctypes.LogData{
+				Level: slog.LevelWarn,
+				Msg: fmt.Sprintf(
+					"write of item (ID %d) to sink %s not completed after %s, the following items are waiting for it",
+					contentEl.ContentID, skWk.Sink.Name(), time.Since(start).Round(time.Second),
+				),
+			}
+		case <-skWk.Ctx.Done():
+			skWk.closeSink(logChan)
+			return false
+		}
+	}
+}
+
+func (skWk *SinkWorker) closeSink(logChan chan<- ctypes.LogData) {
+	if err := skWk.Sink.Close(); err != nil {
+		logChan <- ctypes.LogData{
+			Level: slog.LevelError,
+			Msg:   fmt.Sprintf("error closing sink %s: %s", skWk.Sink.Name(), err.Error()),
+		}
+	}
+}
+
+func (skWk *SinkWorker) log(logChan <-chan ctypes.LogData) {
+	for {
+		select {
+		case <-skWk.Ctx.Done():
+			return
+		case data, ok := <-logChan:
+			if !ok {
+				return
+			}
+			slog.Log(skWk.Ctx, data.Level, skWk.logFormat(data.Msg))
+		}
+	}
+}
+
+func (skWk *SinkWorker) logFormat(text string) string {
+	return fmt.Sprintf("(SinkWorker K%d): %s", skWk.ID, text)
+}
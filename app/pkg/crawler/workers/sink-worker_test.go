@@ -0,0 +1,187 @@
+package workers
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	wtypes "crawler/app/pkg/crawler/workers/workers-types"
+	"crawler/app/pkg/sinks"
+)
+
+func TestSinkWorkerWritesToMemorySink(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	contentsChan := make(chan *wtypes.ContentElement, 10)
+	stopChan := make(chan chan struct{})
+	sink := sinks.NewMemorySink()
+
+	skWk := &SinkWorker{
+		ID:           1,
+		Ctx:          ctx,
+		ContentsChan: contentsChan,
+		Sink:         sink,
+		StopChan:     stopChan,
+	}
+	workerDone := make(chan struct{})
+	go func() {
+		defer close(workerDone)
+		skWk.Run()
+	}()
+
+	for id := 1; id <= 5; id++ {
+		contentsChan <- &wtypes.ContentElement{ContentID: id, Content: map[string]interface{}{"id": id}}
+	}
+
+	// the worker writes the contents still in ContentsChan before returning
+	stopped := make(chan struct{})
+	stopChan <- stopped
+	<-stopped
+	<-workerDone
+
+	elements := sink.Elements()
+	if len(elements) != 5 {
+		t.Fatalf("expected 5 elements written, got %d", len(elements))
+	}
+	for idx, el := range elements {
+		if el.ContentID != idx+1 {
+			t.Errorf("expected element %d to have ID %d, got %d", idx, idx+1, el.ContentID)
+		}
+	}
+	if err := sink.Health(); err != nil {
+		t.Errorf("expected the sink to be left open on stop, got %v", err)
+	}
+}
+
+func TestSinkWorkerClosesSinkOnCtxDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sink := sinks.NewMemorySink()
+	skWk := &SinkWorker{
+		ID:           1,
+		Ctx:          ctx,
+		ContentsChan: make(chan *wtypes.ContentElement),
+		Sink:         sink,
+	}
+	workerDone := make(chan struct{})
+	go func() {
+		defer close(workerDone)
+		skWk.Run()
+	}()
+
+	cancel()
+	<-workerDone
+
+	if skWk.Fatal == nil {
+		t.Error("expected the worker to have a ctx done error")
+	}
+	if err := sink.Health(); err == nil {
+		t.Error("expected the sink to be closed")
+	}
+}
+
+// stuckSink is a MemorySink whose writes of the content with ID stuckID block until release is closed.
+type stuckSink struct {
+	*sinks.MemorySink
+	stuckID int
+	release chan struct{}
+	once    sync.Once
+}
+
+func (sS *stuckSink) Write(el *wtypes.ContentElement) error {
+	if el.ContentID == sS.stuckID {
+		<-sS.release
+	}
+	return sS.MemorySink.Write(el)
+}
+
+func TestSinkWorkerWaitsForSlowWrites(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	contentsChan := make(chan *wtypes.ContentElement, 10)
+	stopChan := make(chan chan struct{})
+	sink := &stuckSink{MemorySink: sinks.NewMemorySink(), stuckID: 1, release: make(chan struct{})}
+	defer sink.once.Do(func() { close(sink.release) })
+
+	skWk := &SinkWorker{
+		ID:               1,
+		Ctx:              ctx,
+		ContentsChan:     contentsChan,
+		Sink:             sink,
+		StopChan:         stopChan,
+		SlowWriteSeconds: 1,
+	}
+	go skWk.Run()
+
+	for id := 1; id <= 3; id++ {
+		contentsChan <- &wtypes.ContentElement{ContentID: id, Content: map[string]interface{}{"id": id}}
+	}
+
+	// the contents after the stuck one wait for it, past the slow write delay
+	time.Sleep(1500 * time.Millisecond)
+	if elements := sink.Elements(); len(elements) != 0 {
+		t.Fatalf("expected no content written while the first write is stuck, got %d", len(elements))
+	}
+
+	// the worker only reports being stopped once every content has been written
+	stopped := make(chan struct{})
+	go func() { stopChan <- stopped }()
+	sink.once.Do(func() { close(sink.release) })
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the worker to stop once the stuck write completed")
+	}
+
+	elements := sink.Elements()
+	if len(elements) != 3 {
+		t.Fatalf("expected 3 elements written, got %d", len(elements))
+	}
+	for idx, el := range elements {
+		if el.ContentID != idx+1 {
+			t.Errorf("expected element %d to have ID %d, got %d", idx, idx+1, el.ContentID)
+		}
+	}
+}
+
+// panickingSink is a MemorySink whose writes panic after a delay.
+type panickingSink struct {
+	*sinks.MemorySink
+	delay time.Duration
+}
+
+func (pS *panickingSink) Write(el *wtypes.ContentElement) error {
+	time.Sleep(pS.delay)
+	panic("write failed")
+}
+
+func TestSinkWorkerPropagatesSlowWritePanics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	contentsChan := make(chan *wtypes.ContentElement, 1)
+	crashChan := make(chan *wtypes.WorkerCrash, 1)
+	skWk := &SinkWorker{
+		ID:               1,
+		Ctx:              ctx,
+		ContentsChan:     contentsChan,
+		Sink:             &panickingSink{MemorySink: sinks.NewMemorySink(), delay: 1500 * time.Millisecond},
+		SlowWriteSeconds: 1,
+		OnCrash:          func(crash *wtypes.WorkerCrash) { crashChan <- crash },
+	}
+	go skWk.Run()
+
+	contentsChan <- &wtypes.ContentElement{ContentID: 7}
+
+	select {
+	case crash := <-crashChan:
+		if crash.ItemID != 7 {
+			t.Errorf("expected the crash of content 7, got %d", crash.ItemID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the panic of the slow write to crash the worker")
+	}
+}
@@ -0,0 +1,151 @@
+package workers
+
+import (
+	wtypes "crawler/app/pkg/crawler/workers/workers-types"
+	"crawler/app/pkg/metrics"
+)
+
+// StatusMetrics exposes what Outcome and State track as metrics.
+//
+// Outcome and State are reset by LogAndResetVarsLoop at every status log,
+// so they are accumulated into the metrics right before each reset.
+type StatusMetrics struct {
+	successes    *metrics.Counter
+	notFounds    *metrics.Counter
+	rateLimits   *metrics.Counter
+	unauthorized *metrics.Counter
+	otherErrs    *metrics.Counter
+
+	recovered   *metrics.Counter
+	lost        *metrics.Counter
+	ackTimeouts *metrics.Counter
+	ackDropped  *metrics.Counter
+	lateItems   *metrics.Counter
+	duplicates  *metrics.Counter
+	malformed   *metrics.Counter
+	crashes     *metrics.Counter
+
+	highestID *metrics.Gauge
+	batchID   *metrics.Gauge
+
+	delays             *metrics.Histogram
+	delayP50           *metrics.Gauge
+	delayP90           *metrics.Gauge
+	delayP99           *metrics.Gauge
+	delayMax           *metrics.Gauge
+	thresholdsAmounts  *metrics.Histogram
+	thresholdsOffsets  *metrics.Histogram
+	hitThresholdLevels *metrics.Histogram
+}
+
+func NewStatusMetrics(registry *metrics.Registry) *StatusMetrics {
+	const requestsName = "crawler_requests_total"
+	const requestsHelp = "Item requests by result."
+
+	const delayQuantilesName = "crawler_item_delay_quantile_seconds"
+	const delayQuantilesHelp = "Delay quantiles of the items fetched in the last status interval."
+
+	// from 1 to 32768
+	thresholdsBuckets := metrics.ExponentialBuckets(1, 2, 16)
+
+	return &StatusMetrics{
+		successes:    registry.NewCounter(requestsName, requestsHelp, metrics.Labels{"result": "success"}),
+		notFounds:    registry.NewCounter(requestsName, requestsHelp, metrics.Labels{"result": "not_found"}),
+		rateLimits:   registry.NewCounter(requestsName, requestsHelp, metrics.Labels{"result": "rate_limited"}),
+		unauthorized: registry.NewCounter(requestsName, requestsHelp, metrics.Labels{"result": "unauthorized"}),
+		otherErrs:    registry.NewCounter(requestsName, requestsHelp, metrics.Labels{"result": "other_error"}),
+
+		recovered: registry.NewCounter(
+			"crawler_backup_recovered_total", "Items fetched successfully by the backup workers.", nil,
+		),
+		lost: registry.NewCounter(
+			"crawler_backup_lost_total", "Items given up by the backup workers.", nil,
+		),
+		ackTimeouts: registry.NewCounter(
+			"crawler_ack_timeouts_total", "Websocket deliveries not acknowledged within the timeout.", nil,
+		),
+		ackDropped: registry.NewCounter(
+			"crawler_ack_dropped_total", "Websocket deliveries given up after too many unacknowledged attempts.", nil,
+		),
+		lateItems: registry.NewCounter(
+			"crawler_late_items_total", "Items delivered out of order by the ordered websocket output.", nil,
+		),
+		duplicates: registry.NewCounter(
+			"crawler_duplicates_total", "Items not delivered as their ID had already been delivered recently.", nil,
+		),
+		malformed: registry.NewCounter(
+			"crawler_malformed_items_total", "Items fetched without the expected content, labeled as lost.", nil,
+		),
+		crashes: registry.NewCounter(
+			"crawler_worker_crashes_total", "Panics recovered in the workers, which have been restarted.", nil,
+		),
+
+		highestID: registry.NewGauge("crawler_highest_id", "Highest item ID found.", nil),
+		batchID:   registry.NewGauge("crawler_batch_id", "ID of the current batch of generated item IDs.", nil),
+
+		delays: registry.NewHistogram(
+			"crawler_item_delay_seconds", "Delay between the publication of an item and its fetch.", nil,
+			metrics.ExponentialBuckets(0.05, 2, 14),
+		),
+		delayP50: registry.NewGauge(delayQuantilesName, delayQuantilesHelp, metrics.Labels{"quantile": "0.5"}),
+		delayP90: registry.NewGauge(delayQuantilesName, delayQuantilesHelp, metrics.Labels{"quantile": "0.9"}),
+		delayP99: registry.NewGauge(delayQuantilesName, delayQuantilesHelp, metrics.Labels{"quantile": "0.99"}),
+		delayMax: registry.NewGauge(delayQuantilesName, delayQuantilesHelp, metrics.Labels{"quantile": "1"}),
+		thresholdsAmounts: registry.NewHistogram(
+			"crawler_thresholds_amount", "Amount of thresholds of each batch.", nil, thresholdsBuckets,
+		),
+		thresholdsOffsets: registry.NewHistogram(
+			"crawler_thresholds_offset", "Thresholds offset of each batch.", nil, thresholdsBuckets,
+		),
+		hitThresholdLevels: registry.NewHistogram(
+			"crawler_hit_threshold_level", "Highest threshold level with a found item of each batch.", nil, thresholdsBuckets,
+		),
+	}
+}
+
+// observe accumulates the current values of outcome and state.
+// It must be called with both their locks held. A nil StatusMetrics is a no-op.
+func (sm *StatusMetrics) observe(state *wtypes.State, outcome *wtypes.Outcome) {
+	if sm == nil {
+		return
+	}
+
+	sm.successes.Add(float64(outcome.Successes))
+	sm.notFounds.Add(float64(outcome.NotFounds))
+	sm.rateLimits.Add(float64(outcome.RateLimits))
+	sm.unauthorized.Add(float64(outcome.Unauthorized))
+	sm.otherErrs.Add(float64(outcome.OtherErrs))
+	sm.recovered.Add(float64(outcome.Recovered))
+	sm.lost.Add(float64(outcome.Lost))
+	sm.ackTimeouts.Add(float64(outcome.AckTimeouts))
+	sm.ackDropped.Add(float64(outcome.AckDropped))
+	sm.lateItems.Add(float64(outcome.LateItems))
+	sm.duplicates.Add(float64(outcome.Duplicates))
+	sm.malformed.Add(float64(outcome.Malformed))
+	sm.crashes.Add(float64(outcome.Crashes))
+
+	sm.highestID.Set(float64(state.HighestID))
+	sm.batchID.Set(float64(state.BatchID))
+
+	// the delays are counted as the highest value of their bucket,
+	// the sum is the exact one so that it is not biased upwards
+	state.Delays.ForEach(func(delay uint32, count uint64) {
+		sm.delays.CountN(float64(delay)/1000, count)
+	})
+	sm.delays.AddSum(float64(state.Delays.Sum()) / 1000)
+	if state.Delays.Count() > 0 {
+		sm.delayP50.Set(float64(state.Delays.Percentile(0.5)) / 1000)
+		sm.delayP90.Set(float64(state.Delays.Percentile(0.9)) / 1000)
+		sm.delayP99.Set(float64(state.Delays.Percentile(0.99)) / 1000)
+		sm.delayMax.Set(float64(state.Delays.Max()) / 1000)
+	}
+	for _, amount := range state.ThresholdsAmounts {
+		sm.thresholdsAmounts.Observe(float64(amount))
+	}
+	for _, offset := range state.ThresholdsOffsets {
+		sm.thresholdsOffsets.Observe(float64(offset))
+	}
+	for _, level := range state.HitThresholdLevels {
+		sm.hitThresholdLevels.Observe(float64(level))
+	}
+}
@@ -2,87 +2,137 @@ package workers
 
 import (
 	"fmt"
-	"os"
+	"io"
+	"log/slog"
 	"time"
 
 	wtypes "crawler/app/pkg/crawler/workers/workers-types"
-	"crawler/app/pkg/utils/slicex"
+	"crawler/app/pkg/status"
 )
 
+// LogAndResetVarsLoop takes a snapshot of state, outcome and the pools usage
+// every interval, writes it to logFile with the renderer and resets them.
 func LogAndResetVarsLoop(
 	state *wtypes.State,
 	outcome *wtypes.Outcome,
-	seconds int,
-	logFile *os.File,
+	pools []*wtypes.PoolUsage,
+	interval time.Duration,
+	renderer status.Renderer,
+	logFile io.Writer,
+	statusMetrics *StatusMetrics,
 ) {
+	lastSnapshotAt := time.Now()
 	for {
-		time.Sleep((time.Duration)(seconds) * time.Second)
+		time.Sleep(interval)
 
+		now := time.Now()
+		elapsed := now.Sub(lastSnapshotAt)
+		lastSnapshotAt = now
+
+		state.Mu.Lock()
 		outcome.Mu.Lock()
-		var totalRequests int = outcome.Successes + outcome.NotFounds +
-			outcome.RateLimits + outcome.OtherErrs
-		var successRate float32
-		if totalRequests > 0 {
-			successRate = (float32)(outcome.Successes) / (float32)(totalRequests) * 100
-		}
+		snapshot := takeSnapshot(state, outcome, now, elapsed)
+		statusMetrics.observe(state, outcome)
+		resetVars(state, outcome)
 		outcome.Mu.Unlock()
+		state.Mu.Unlock()
 
-		state.Mu.Lock()
-		var avgThreshAmount, avgThreshOffset, avgHitThreshLevel, avgDelay float32
-		if count := len(state.ThresholdsAmounts); count > 0 {
-			avgThreshAmount = (float32)(slicex.Sum(state.ThresholdsAmounts)) / (float32)(count)
-		}
-		if count := len(state.ThresholdsOffsets); count > 0 {
-			avgThreshOffset = (float32)(slicex.Sum(state.ThresholdsOffsets)) / (float32)(count)
+		snapshot.Pools = make([]status.Pool, len(pools))
+		for idx, pool := range pools {
+			snapshot.Pools[idx] = status.Pool{
+				Name:        pool.Name,
+				Workers:     pool.Workers(),
+				Utilization: poolUtilization(pool, elapsed),
+			}
 		}
-		if count := len(state.HitThresholdLevels); count > 0 {
-			avgHitThreshLevel = (float32)(slicex.Sum(state.HitThresholdLevels)) / (float32)(count)
-		}
-		if count := len(state.Delays); count > 0 {
-			avgDelay = (float32)(slicex.Sum(state.Delays)) / (float32)(count)
+
+		if err := renderer.Render(logFile, snapshot); err != nil {
+			slog.Error(fmt.Sprintf("(Status): error writing status snapshot: %s", err.Error()))
 		}
-		state.Mu.Unlock()
+	}
+}
 
-		logFile.WriteString(
-			func() string {
-				state.Mu.Lock()
-				outcome.Mu.Lock()
-				defer outcome.Mu.Unlock()
-				defer state.Mu.Unlock()
-				return fmt.Sprintf(
-					time.Now().Format("2006-01-02 15:04:05.0")+" STATUS\n"+
-						"Reqs: %d, Success: %.2f%%\n"+
-						"RateLimits (429): %d, NotFounds (404): %d, "+
-						"OtherErrs: %d\n"+
-						"Recovered from backup: %d, Lost from backup: %d\n"+
-						"BatchID: %d, HighestID: %d\n"+
-						"AvgThreshAmount: %.2f, AvgThreshOffset: %.2f\n"+
-						"AvgHitThreshLevel: %.2f, AvgDelay: %.2f"+
-						"\n\n",
-					totalRequests, successRate,
-					outcome.RateLimits, outcome.NotFounds, outcome.OtherErrs,
-					outcome.Recovered, outcome.Lost,
-					state.BatchID, state.HighestID,
-					avgThreshAmount, avgThreshOffset,
-					avgHitThreshLevel, avgDelay,
-				)
-			}(),
-		)
+// takeSnapshot must be called with the locks of state and outcome held.
+func takeSnapshot(state *wtypes.State, outcome *wtypes.Outcome, now time.Time, elapsed time.Duration) *status.Snapshot {
+	totalRequests := outcome.Successes + outcome.NotFounds +
+		outcome.RateLimits + outcome.Unauthorized + outcome.OtherErrs
+	var successRate float64
+	if totalRequests > 0 {
+		successRate = float64(outcome.Successes) / float64(totalRequests) * 100
+	}
 
-		outcome.Mu.Lock()
-		outcome.RateLimits = 0
-		outcome.NotFounds = 0
-		outcome.OtherErrs = 0
-		outcome.Successes = 0
-		outcome.Recovered = 0
-		outcome.Lost = 0
-		outcome.Mu.Unlock()
+	return &status.Snapshot{
+		Time:            now,
+		IntervalSeconds: elapsed.Seconds(),
+		Requests: status.Requests{
+			Total:        totalRequests,
+			SuccessRate:  successRate,
+			Successes:    outcome.Successes,
+			NotFounds:    outcome.NotFounds,
+			RateLimits:   outcome.RateLimits,
+			Unauthorized: outcome.Unauthorized,
+			OtherErrs:    outcome.OtherErrs,
+		},
+		Backup: status.Backup{
+			Recovered: outcome.Recovered,
+			Lost:      outcome.Lost,
+		},
+		Delivery: status.Delivery{
+			AckTimeouts: outcome.AckTimeouts,
+			AckDropped:  outcome.AckDropped,
+			LateItems:   outcome.LateItems,
+			Duplicates:  outcome.Duplicates,
+		},
+		MalformedItems:    outcome.Malformed,
+		WorkerCrashes:     outcome.Crashes,
+		BatchID:           state.BatchID,
+		HighestID:         state.HighestID,
+		Delays:            status.NewHistogramSummary(&state.Delays),
+		ThresholdsAmount:  status.NewSummary(state.ThresholdsAmounts),
+		ThresholdsOffset:  status.NewSummary(state.ThresholdsOffsets),
+		HitThresholdLevel: status.NewSummary(state.HitThresholdLevels),
+	}
+}
 
-		state.Mu.Lock()
-		state.ThresholdsAmounts = state.ThresholdsAmounts[:0]
-		state.ThresholdsOffsets = state.ThresholdsOffsets[:0]
-		state.HitThresholdLevels = state.HitThresholdLevels[:0]
-		state.Delays = state.Delays[:0]
-		state.Mu.Unlock()
+// resetVars must be called with the locks of state and outcome held.
+// The delay percentiles of the interval are kept in state.LastDelays,
+// unless no item has been fetched in the interval.
+func resetVars(state *wtypes.State, outcome *wtypes.Outcome) {
+	outcome.RateLimits = 0
+	outcome.NotFounds = 0
+	outcome.Unauthorized = 0
+	outcome.OtherErrs = 0
+	outcome.Successes = 0
+	outcome.Recovered = 0
+	outcome.Lost = 0
+	outcome.AckTimeouts = 0
+	outcome.AckDropped = 0
+	outcome.LateItems = 0
+	outcome.Duplicates = 0
+	outcome.Malformed = 0
+	outcome.Crashes = 0
+
+	state.ThresholdsAmounts = state.ThresholdsAmounts[:0]
+	state.ThresholdsOffsets = state.ThresholdsOffsets[:0]
+	state.HitThresholdLevels = state.HitThresholdLevels[:0]
+	if state.Delays.Count() > 0 {
+		state.LastDelays = wtypes.DelayPercentiles{
+			P50: state.Delays.Percentile(0.5),
+			P90: state.Delays.Percentile(0.9),
+			P99: state.Delays.Percentile(0.99),
+			Max: state.Delays.Max(),
+		}
+	}
+	state.Delays.Reset()
+}
+
+// poolUtilization returns the fraction of the elapsed time the workers of the pool
+// have been busy, capped at 1 as an item can span multiple intervals.
+func poolUtilization(pool *wtypes.PoolUsage, elapsed time.Duration) float64 {
+	workers := pool.Workers()
+	if workers <= 0 || elapsed <= 0 {
+		return 0
 	}
+	utilization := float64(pool.TakeBusy()) / (float64(elapsed) * float64(workers))
+	return min(utilization, 1)
 }
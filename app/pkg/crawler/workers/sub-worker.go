@@ -10,10 +10,12 @@ import (
 
 	"crawler/app/pkg/assert"
 	assetshandler "crawler/app/pkg/assets-handler"
+	"crawler/app/pkg/checkpoint"
 	"crawler/app/pkg/crawler/network"
 	wtypes "crawler/app/pkg/crawler/workers/workers-types"
 	ctypes "crawler/app/pkg/custom-types"
 	customerrors "crawler/app/pkg/custom-types/custom-errors"
+	"crawler/app/pkg/ratelimit"
 )
 
 type SubordinateWorker struct {
@@ -33,12 +35,32 @@ type SubordinateWorker struct {
 	// request.
 	BackupChan chan<- *wtypes.BackupPacket
 
+	// RateLimiter spreads the requests of all the workers within the configured
+	// budgets, and adapts them to the rate limits of the server. It can be nil.
+	RateLimiter *ratelimit.Limiter
+
+	// Tracker is used to report the items that are still being worked on,
+	// so that they can be checkpointed. It can be nil.
+	Tracker *checkpoint.Tracker
+
+	// Usage accumulates the time the worker spends working on items,
+	// for the pool utilization reported in the status log. It can be nil.
+	Usage *wtypes.PoolUsage
+
+	// StopChan is used to shrink the pool of the worker: the worker returns when
+	// it receives from it, never in the middle of an item. It can be nil.
+	StopChan <-chan struct{}
+
+	// OnCrash is called with the panics recovered in the worker, which then returns.
+	// The item the worker was working on is labeled as lost. If nil, the panic is propagated.
+	OnCrash func(crash *wtypes.WorkerCrash)
+
 	Rand  *rand.Rand
 	Fatal error
 }
 
 func (sWk *SubordinateWorker) Run(
-	cfg *assetshandler.Config,
+	sharedCfg *assetshandler.SharedConfig,
 	state *wtypes.State,
 	outcome *wtypes.Outcome,
 ) {
@@ -46,15 +68,27 @@ func (sWk *SubordinateWorker) Run(
 	defer close(logChan)
 	go sWk.log(logChan)
 
+	// true if the worker has been stopped through StopChan
+	var stopped bool
+
+	// the ID of the item the worker is responsible for, 0 once it has been handed off
+	var currentItemID int
+
 	defer func() {
 		if r := recover(); r != nil {
-			if err, ok := r.(error); ok {
-				sWk.Fatal = err
-			} else {
-				sWk.Fatal = fmt.Errorf("recover panic: %v", r)
+			sWk.Fatal = recoveredError(r)
+			if sWk.OnCrash == nil {
+				panic(r)
+			}
+
+			crash := newWorkerCrash("subordinate", sWk.ID, currentItemID, r)
+			if currentItemID != 0 {
+				// so that it does not hold the checkpoint back
+				sWk.Tracker.Resolve(currentItemID)
+				outcome.Update(func(o *wtypes.Outcome) { o.Lost++ })
 			}
-			panic(r)
-		} else {
+			sWk.OnCrash(crash)
+		} else if !stopped {
 			assert.NotNil(
 				sWk.Fatal,
 				"at this point worker must have a done ctx error. an unexpected error occurred",
@@ -72,43 +106,61 @@ func (sWk *SubordinateWorker) Run(
 		case <-sWk.Ctx.Done():
 			sWk.Fatal = fmt.Errorf("worker %v ctx done", sWk.ID)
 			return
+		case <-sWk.StopChan:
+			stopped = true
+			logChan <- ctypes.LogData{
+				Level: slog.LevelDebug,
+				Msg:   "Worker stopped as its pool has been shrunk",
+			}
+			return
 		case itemRequest := <-sWk.ItemsIDsChan:
 			itemID := itemRequest.ItemID
-			if func() int {
-				outcome.Mu.Lock()
-				defer outcome.Mu.Unlock()
-				return outcome.RateLimits
-			}() > cfg.Http.MaxRateLimitsPerSecond {
-				time.Sleep((time.Duration)(cfg.Http.RateLimitWait) * time.Second)
+			currentItemID = itemID
+
+			// the time waited for the rate limiter is not spent working on the item
+			if err := sWk.RateLimiter.Wait(sWk.Ctx, ratelimit.ItemEndpoint); err != nil {
+				// the item is left in the tracker, so that it is checkpointed
+				sWk.Fatal = fmt.Errorf("worker %v ctx done", sWk.ID)
+				return
 			}
 
+			busySince := time.Now()
+			cfg := sharedCfg.Load()
+
 			cookieJarSession := network.PickRandomCookieJarSession(sWk.Rand)
 
 			decodedResp, appendedSuffix, err := network.FetchItem(sWk.Ctx, cfg, cookieJarSession.CookieJar, itemID, sWk.Rand)
+			sWk.RateLimiter.Observe(ratelimit.ItemEndpoint, errors.Is(err, customerrors.ErrorRateLimit))
 			if err != nil {
-				sWk.BackupChan <- &wtypes.BackupPacket{
+				backupPacket := &wtypes.BackupPacket{
 					ItemID:       itemID,
 					AppendSuffix: appendedSuffix,
+					BatchID:      itemRequest.BatchID,
 				}
+				sWk.Tracker.MoveToBackup(backupPacket)
+				select {
+				case <-sWk.Ctx.Done():
+					// the backup workers are stopped too, the item is left
+					// in the tracker so that it is checkpointed
+					sWk.Fatal = fmt.Errorf("worker %v ctx done", sWk.ID)
+					return
+				case sWk.BackupChan <- backupPacket:
+				}
+				currentItemID = 0
 
 				switch {
 				case errors.Is(err, customerrors.ErrorUnauthorized):
-					cookieJarSession.RefreshChan <- struct{}{}
-					outcome.Mu.Lock()
-					outcome.OtherErrs++
-					outcome.Mu.Unlock()
+					select {
+					case cookieJarSession.RefreshChan <- struct{}{}:
+					default: // channel is full, the refresher is already working on this
+					}
+					outcome.Update(func(o *wtypes.Outcome) { o.Unauthorized++ })
 				case errors.Is(err, customerrors.ErrorRateLimit):
-					outcome.Mu.Lock()
-					outcome.RateLimits++
-					outcome.Mu.Unlock()
+					outcome.Update(func(o *wtypes.Outcome) { o.RateLimits++ })
 				case errors.Is(err, customerrors.ErrorNotFound):
-					outcome.Mu.Lock()
-					outcome.NotFounds++
-					outcome.Mu.Unlock()
+					outcome.Update(func(o *wtypes.Outcome) { o.NotFounds++ })
 				default:
-					outcome.Mu.Lock()
-					outcome.OtherErrs++
-					outcome.Mu.Unlock()
+					outcome.Update(func(o *wtypes.Outcome) { o.OtherErrs++ })
 				}
 				logChan <- ctypes.LogData{
 					Level: slog.LevelWarn,
@@ -117,44 +169,55 @@ func (sWk *SubordinateWorker) Run(
 						itemID, itemRequest.BatchID, err.Error(),
 					),
 				}
+				sWk.Usage.AddBusy(time.Since(busySince))
 				continue
 			}
 
-			outcome.Mu.Lock()
-			outcome.Successes++
-			outcome.Mu.Unlock()
+			fetchedAt := time.Now()
 
-			sWk.ResultsChan <- &wtypes.ContentElement{
-				Content:   decodedResp,
-				ContentID: itemID,
-			}
+			delay, err := parseItemDelay(cfg, decodedResp, appendedSuffix)
+			if err != nil {
+				// fetching it again would give the same content, so it is labeled as lost
+				sWk.Tracker.Resolve(itemID)
+				currentItemID = 0
+
+				outcome.Update(func(o *wtypes.Outcome) { o.Malformed++ })
 
-			var tsKey string
-			if appendedSuffix {
-				tsKey = cfg.Standard.ItemResponse.TimestampSuffix
-			} else {
-				tsKey = cfg.Standard.ItemResponse.Timestamp
+				logChan <- ctypes.LogData{
+					Level: slog.LevelWarn,
+					Msg:   fmt.Sprintf("item (ID %d, B %d) skipped. %s", itemID, itemRequest.BatchID, err.Error()),
+				}
+				sWk.Usage.AddBusy(time.Since(busySince))
+				continue
 			}
 
-			item := decodedResp[cfg.Standard.ItemResponse.Item].(map[string]interface{})
-			rawTs := item[tsKey].(string)
-			parsedTs, err := time.Parse(cfg.Standard.TimestampFormat, rawTs)
-			assert.NoError(err, "timestamp must be parsed successfully")
+			select {
+			case <-sWk.Ctx.Done():
+				// the item is left in the tracker, so that it is checkpointed
+				sWk.Fatal = fmt.Errorf("worker %v ctx done", sWk.ID)
+				return
+			case sWk.ResultsChan <- &wtypes.ContentElement{
+				Content:   decodedResp,
+				ContentID: itemID,
+				BatchID:   itemRequest.BatchID,
+				Delay:     delay,
+				FetchedAt: fetchedAt,
+			}:
+			}
+			sWk.Tracker.Resolve(itemID)
+			currentItemID = 0
 
-			// it can happen that a server displays some items with a timestamp
-			// in the future for internal sync issues, so we make sure to keep
-			// the delay positive
-			delay := uint32(max(int(time.Since(parsedTs).Milliseconds()), 0))
+			outcome.Update(func(o *wtypes.Outcome) { o.Successes++ })
 
-			state.Mu.Lock()
-			state.Delays = append(state.Delays, delay)
-			state.Mu.Unlock()
+			state.RecordDelay(delay)
 
 			// XXX: In production this can be removed for increased performance
 			logChan <- ctypes.LogData{
 				Level: slog.LevelDebug,
 				Msg:   fmt.Sprintf("item (ID %d, B %d) fetched ----- %d", itemID, itemRequest.BatchID, delay),
 			}
+
+			sWk.Usage.AddBusy(time.Since(busySince))
 		}
 	}
 }
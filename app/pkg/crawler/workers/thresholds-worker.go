@@ -14,6 +14,7 @@ import (
 	wtypes "crawler/app/pkg/crawler/workers/workers-types"
 	ctypes "crawler/app/pkg/custom-types"
 	customerrors "crawler/app/pkg/custom-types/custom-errors"
+	"crawler/app/pkg/ratelimit"
 )
 
 type ThresholdsWorker struct {
@@ -31,12 +32,29 @@ type ThresholdsWorker struct {
 	// the associated metadata and the hit threshold level.
 	ResultsChan chan<- *wtypes.ThresholdsWorkerResult
 
+	// RateLimiter spreads the requests of all the workers within the configured
+	// budgets, and adapts them to the rate limits of the server. It can be nil.
+	RateLimiter *ratelimit.Limiter
+
+	// Usage accumulates the time the worker spends working on items,
+	// for the pool utilization reported in the status log. It can be nil.
+	Usage *wtypes.PoolUsage
+
+	// StopChan is used to shrink the pool of the worker: the worker returns when
+	// it receives from it, never in the middle of an item. It can be nil.
+	StopChan <-chan struct{}
+
+	// OnCrash is called with the panics recovered in the worker, which then returns.
+	// A failed result is sent for the item the worker was working on, so that the
+	// workers manager does not wait for it. If nil, the panic is propagated.
+	OnCrash func(crash *wtypes.WorkerCrash)
+
 	Rand  *rand.Rand
 	Fatal error
 }
 
 func (tWk *ThresholdsWorker) Run(
-	cfg *assetshandler.Config,
+	sharedCfg *assetshandler.SharedConfig,
 	state *wtypes.State,
 	outcome *wtypes.Outcome,
 ) {
@@ -44,15 +62,37 @@ func (tWk *ThresholdsWorker) Run(
 	defer close(logChan)
 	go tWk.log(logChan)
 
+	// true if the worker has been stopped through StopChan
+	var stopped bool
+
+	// the item the worker has to send a result for, nil once it has been sent
+	var currentItem *wtypes.ItemFromBatchPacket
+
 	defer func() {
 		if r := recover(); r != nil {
-			if err, ok := r.(error); ok {
-				tWk.Fatal = err
-			} else {
-				tWk.Fatal = fmt.Errorf("recover panic: %v", r)
+			tWk.Fatal = recoveredError(r)
+			if tWk.OnCrash == nil {
+				panic(r)
+			}
+
+			var itemID int
+			if currentItem != nil {
+				itemID = currentItem.ItemID
 			}
-			panic(r)
-		} else {
+			crash := newWorkerCrash("thresholds", tWk.ID, itemID, r)
+
+			if currentItem != nil {
+				select {
+				case <-tWk.Ctx.Done():
+				case tWk.ResultsChan <- &wtypes.ThresholdsWorkerResult{
+					ItemID:  currentItem.ItemID,
+					Success: false,
+					BatchID: currentItem.BatchID,
+				}:
+				}
+			}
+			tWk.OnCrash(crash)
+		} else if !stopped {
 			assert.NotNil(
 				tWk.Fatal,
 				"at this point worker must have a done ctx error. an unexpected error occurred",
@@ -70,39 +110,42 @@ func (tWk *ThresholdsWorker) Run(
 		case <-tWk.Ctx.Done():
 			tWk.Fatal = fmt.Errorf("worker %v ctx done", tWk.ID)
 			return
+		case <-tWk.StopChan:
+			stopped = true
+			logChan <- ctypes.LogData{
+				Level: slog.LevelDebug,
+				Msg:   "Worker stopped as its pool has been shrunk",
+			}
+			return
 		case itemRequest := <-tWk.ItemsIDsChan:
-			if func() int {
-				outcome.Mu.Lock()
-				defer outcome.Mu.Unlock()
-				return outcome.RateLimits
-			}() > cfg.Http.MaxRateLimitsPerSecond {
-				time.Sleep((time.Duration)(cfg.Http.RateLimitWait) * time.Second)
+			currentItem = itemRequest
+
+			// the time waited for the rate limiter is not spent working on the item
+			if err := tWk.RateLimiter.Wait(tWk.Ctx, ratelimit.ItemEndpoint); err != nil {
+				tWk.Fatal = fmt.Errorf("worker %v ctx done", tWk.ID)
+				return
 			}
 
+			busySince := time.Now()
+			cfg := sharedCfg.Load()
+
 			itemID := itemRequest.ItemID
 
 			cookieJarSession := network.PickRandomCookieJarSession(tWk.Rand)
 
 			decodedResp, appendedSuffix, err := network.FetchItem(tWk.Ctx, cfg, cookieJarSession.CookieJar, itemID, tWk.Rand)
+			tWk.RateLimiter.Observe(ratelimit.ItemEndpoint, errors.Is(err, customerrors.ErrorRateLimit))
 			if err != nil {
 				switch {
 				case errors.Is(err, customerrors.ErrorUnauthorized):
 					cookieJarSession.RefreshChan <- struct{}{}
-					outcome.Mu.Lock()
-					outcome.OtherErrs++
-					outcome.Mu.Unlock()
+					outcome.Update(func(o *wtypes.Outcome) { o.Unauthorized++ })
 				case errors.Is(err, customerrors.ErrorRateLimit):
-					outcome.Mu.Lock()
-					outcome.RateLimits++
-					outcome.Mu.Unlock()
+					outcome.Update(func(o *wtypes.Outcome) { o.RateLimits++ })
 				case errors.Is(err, customerrors.ErrorNotFound):
-					outcome.Mu.Lock()
-					outcome.NotFounds++
-					outcome.Mu.Unlock()
+					outcome.Update(func(o *wtypes.Outcome) { o.NotFounds++ })
 				default:
-					outcome.Mu.Lock()
-					outcome.OtherErrs++
-					outcome.Mu.Unlock()
+					outcome.Update(func(o *wtypes.Outcome) { o.OtherErrs++ })
 				}
 				logChan <- ctypes.LogData{
 					Level: slog.LevelWarn,
@@ -117,48 +160,60 @@ func (tWk *ThresholdsWorker) Run(
 					ItemID:    itemID,
 					Success:   false,
 					Timestamp: 0,
+					BatchID:   itemRequest.BatchID,
 				}
+				currentItem = nil
 
+				tWk.Usage.AddBusy(time.Since(busySince))
 				continue
 			}
 
-			outcome.Mu.Lock()
-			outcome.Successes++
-			outcome.Mu.Unlock()
+			fetchedAt := time.Now()
 
-			var tsKey string
-			if appendedSuffix {
-				tsKey = cfg.Standard.ItemResponse.TimestampSuffix
-			} else {
-				tsKey = cfg.Standard.ItemResponse.Timestamp
-			}
+			delay, err := parseItemDelay(cfg, decodedResp, appendedSuffix)
+			if err != nil {
+				outcome.Update(func(o *wtypes.Outcome) { o.Malformed++ })
 
-			item := decodedResp[cfg.Standard.ItemResponse.Item].(map[string]interface{})
-			rawTs := item[tsKey].(string)
-			parsedTs, err := time.Parse(cfg.Standard.TimestampFormat, rawTs)
-			assert.NoError(err, "timestamp must be parsed successfully")
+				logChan <- ctypes.LogData{
+					Level: slog.LevelWarn,
+					Msg: fmt.Sprintf(
+						"threshold item (ID %d, B %d) skipped. %s", itemID, itemRequest.BatchID, err.Error(),
+					),
+				}
 
-			// it can happen that a server displays some items with a timestamp
-			// in the future for internal sync issues, so we make sure to keep
-			// the delay positive
-			delay := uint32(max(int(time.Since(parsedTs).Milliseconds()), 0))
+				// the item exists, but without a timestamp it cannot be used to adjust the thresholds
+				tWk.ResultsChan <- &wtypes.ThresholdsWorkerResult{
+					ItemID:  itemID,
+					Success: false,
+					BatchID: itemRequest.BatchID,
+				}
+				currentItem = nil
+
+				tWk.Usage.AddBusy(time.Since(busySince))
+				continue
+			}
+
+			outcome.Update(func(o *wtypes.Outcome) { o.Successes++ })
 
 			tWk.ResultsChan <- &wtypes.ThresholdsWorkerResult{
 				Item:      decodedResp,
 				ItemID:    itemID,
-				Success:   err == nil,
+				Success:   true,
 				Timestamp: delay,
+				BatchID:   itemRequest.BatchID,
+				FetchedAt: fetchedAt,
 			}
+			currentItem = nil
 
-			state.Mu.Lock()
-			state.Delays = append(state.Delays, delay)
-			state.Mu.Unlock()
+			state.RecordDelay(delay)
 
 			// XXX: In production this can be removed for increased performance
 			logChan <- ctypes.LogData{
 				Level: slog.LevelDebug,
 				Msg:   fmt.Sprintf("threshold item (ID %d B %d) fetched ----- %d", itemID, itemRequest.BatchID, delay),
 			}
+
+			tWk.Usage.AddBusy(time.Since(busySince))
 		}
 	}
 }
@@ -0,0 +1,32 @@
+package workers
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	wtypes "crawler/app/pkg/crawler/workers/workers-types"
+)
+
+// newWorkerCrash describes the value r recovered in a worker of the given pool,
+// while working on the item with ID itemID (0 if none).
+// It must be called by the deferred function that recovered r, so that the
+// stack includes the frames of the panic.
+func newWorkerCrash(pool string, workerID int, itemID int, r any) *wtypes.WorkerCrash {
+	return &wtypes.WorkerCrash{
+		Pool:     pool,
+		WorkerID: workerID,
+		ItemID:   itemID,
+		Reason:   fmt.Sprint(r),
+		Stack:    string(debug.Stack()),
+		At:       time.Now(),
+	}
+}
+
+// recoveredError converts the value r recovered from a panic to the Fatal error of a worker.
+func recoveredError(r any) error {
+	if err, ok := r.(error); ok {
+		return err
+	}
+	return fmt.Errorf("recover panic: %v", r)
+}
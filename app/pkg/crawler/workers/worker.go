@@ -7,7 +7,7 @@ import (
 )
 
 type Worker interface {
-	Run(cfg *assetshandler.Config,
+	Run(sharedCfg *assetshandler.SharedConfig,
 		state *wtypes.State,
 		outcome *wtypes.Outcome,
 	)
@@ -3,26 +3,142 @@ package workerstypes
 import (
 	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"crawler/app/pkg/histogram"
 )
 
 type State struct {
 	BatchID            uint16
 	HighestID          int
-	ThresholdsAmounts  []uint16
-	ThresholdsOffsets  []uint16
-	HitThresholdLevels []uint16
-	Delays             []uint32
-	Mu                 sync.Mutex
+	ThresholdsAmounts  []uint32
+	ThresholdsOffsets  []uint32
+	HitThresholdLevels []uint32
+
+	// the delays (in milliseconds) of the items fetched in the current status interval
+	Delays histogram.Histogram
+
+	// the delay percentiles of the last status interval
+	LastDelays DelayPercentiles
+
+	Mu sync.Mutex
+}
+
+// RecordDelay records the delay of a fetched item. The lock is released even if
+// it panics, so that a crashed worker does not block the other ones.
+func (s *State) RecordDelay(delay uint32) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	s.Delays.Record(delay)
+}
+
+// DelayPercentiles are the percentiles of the delays (in milliseconds)
+// between the publication of the items and their fetch.
+type DelayPercentiles struct {
+	P50 uint32
+	P90 uint32
+	P99 uint32
+	Max uint32
 }
 
 type Outcome struct {
-	RateLimits int
-	NotFounds  int
-	OtherErrs  int
-	Successes  int
-	Recovered  int
-	Lost       int
-	Mu         sync.Mutex
+	RateLimits   int
+	NotFounds    int
+	Unauthorized int
+	OtherErrs    int
+	Successes    int
+	Recovered    int
+	Lost         int
+
+	// items fetched successfully whose content does not have the expected shape
+	// (e.g. a missing or unparsable timestamp), labeled as lost
+	Malformed int
+
+	// panics recovered in the workers, which have been restarted
+	Crashes int
+
+	// deliveries not acknowledged by a websocket consumer within the timeout
+	// (and thus retried) and deliveries given up after too many attempts.
+	AckTimeouts int
+	AckDropped  int
+
+	// items delivered out of order by the ordered websocket output,
+	// as they arrived after a higher ID had already been delivered.
+	LateItems int
+
+	// items not delivered as their ID had already been delivered recently
+	Duplicates int
+
+	Mu sync.Mutex
+}
+
+// Update calls update with the lock held. The lock is released even if update
+// panics, so that a crashed worker does not block the other ones.
+func (o *Outcome) Update(update func(o *Outcome)) {
+	o.Mu.Lock()
+	defer o.Mu.Unlock()
+	update(o)
+}
+
+// PoolUsage accumulates the time the workers of a pool spend working on items,
+// from when they receive an item until they are ready to receive the next one.
+type PoolUsage struct {
+	Name string
+
+	// the amount of workers of the pool, changed when the pool is scaled
+	workers atomic.Int64
+
+	// reset by TakeBusy
+	busyNanos atomic.Int64
+
+	// never reset, see Totals
+	totalBusyNanos atomic.Int64
+	totalItems     atomic.Int64
+}
+
+// SetWorkers sets the amount of workers of the pool.
+func (pu *PoolUsage) SetWorkers(workers int) {
+	pu.workers.Store(int64(workers))
+}
+
+// Workers returns the amount of workers of the pool.
+func (pu *PoolUsage) Workers() int {
+	return int(pu.workers.Load())
+}
+
+// AddBusy adds the time spent working on an item. A nil PoolUsage is a no-op.
+func (pu *PoolUsage) AddBusy(busy time.Duration) {
+	if pu == nil {
+		return
+	}
+	pu.busyNanos.Add(int64(busy))
+	pu.totalBusyNanos.Add(int64(busy))
+	pu.totalItems.Add(1)
+}
+
+// TakeBusy returns the time accumulated since the previous call and resets it.
+func (pu *PoolUsage) TakeBusy() time.Duration {
+	return time.Duration(pu.busyNanos.Swap(0))
+}
+
+// Totals returns the time spent working on items and the amount of items
+// since the pool has been created. Unlike TakeBusy, it does not reset anything.
+func (pu *PoolUsage) Totals() (busy time.Duration, items int64) {
+	return time.Duration(pu.totalBusyNanos.Load()), pu.totalItems.Load()
+}
+
+// WorkerCrash describes a panic recovered in a worker.
+type WorkerCrash struct {
+	Pool     string `json:"pool"`
+	WorkerID int    `json:"worker_id"`
+
+	// the ID of the item the worker was working on, 0 if none
+	ItemID int `json:"item_id,omitempty"`
+
+	Reason string    `json:"reason"`
+	Stack  string    `json:"stack"`
+	At     time.Time `json:"at"`
 }
 
 type ThresholdsWorkerResult struct {
@@ -34,16 +150,71 @@ type ThresholdsWorkerResult struct {
 
 	// the timestamp of the item
 	Timestamp uint32
+
+	// the ID of the batch the item belongs to
+	BatchID uint16
+
+	// the time the item has been fetched at
+	FetchedAt time.Time
 }
 
 type BackupPacket struct {
 	ItemID       int
 	AppendSuffix bool
+
+	// the ID of the batch the item belongs to
+	BatchID uint16
 }
 
 type CookieJarSession struct {
 	CookieJar   http.CookieJar
 	RefreshChan chan struct{}
+
+	// updated by the refresh worker of the session after each fetch
+	health   CookieJarSessionHealth
+	healthMu sync.Mutex
+}
+
+type CookieJarSessionHealth struct {
+	LastRefreshAt       time.Time `json:"last_refresh_at"`
+	LastError           string    `json:"last_error,omitempty"`
+	Refreshes           uint32    `json:"refreshes"`
+	Failures            uint32    `json:"failures"`
+	ConsecutiveFailures uint32    `json:"consecutive_failures"`
+}
+
+// RecordRefresh updates the health of the session with the result of a cookie fetch.
+func (cjs *CookieJarSession) RecordRefresh(err error) {
+	cjs.healthMu.Lock()
+	defer cjs.healthMu.Unlock()
+
+	cjs.health.LastRefreshAt = time.Now()
+	cjs.health.Refreshes++
+	if err != nil {
+		cjs.health.LastError = err.Error()
+		cjs.health.Failures++
+		cjs.health.ConsecutiveFailures++
+	} else {
+		cjs.health.LastError = ""
+		cjs.health.ConsecutiveFailures = 0
+	}
+}
+
+func (cjs *CookieJarSession) Health() CookieJarSessionHealth {
+	cjs.healthMu.Lock()
+	defer cjs.healthMu.Unlock()
+	return cjs.health
+}
+
+// RequestRefresh asks the refresh worker of the session to fetch a new cookie.
+// It returns false if the request has been dropped because too many are pending.
+func (cjs *CookieJarSession) RequestRefresh() bool {
+	select {
+	case cjs.RefreshChan <- struct{}{}:
+		return true
+	default:
+		return false
+	}
 }
 
 type ContentElement struct {
@@ -52,6 +223,15 @@ type ContentElement struct {
 	Content map[string]interface{}
 
 	ContentID int
+
+	// the ID of the batch the content belongs to.
+	BatchID uint16
+
+	// the delay in milliseconds between the item publication and its fetch.
+	Delay uint32
+
+	// the time the content has been fetched at.
+	FetchedAt time.Time
 }
 
 // ItemFromBatchPacket is used to pass the ID of the item to fetch along with
@@ -0,0 +1,16 @@
+package customerrors
+
+import (
+	"errors"
+)
+
+// The failures of a proxy, before the request reaches the server.
+// They are wrapped along with their cause.
+var (
+	// ErrorProxyConnect is returned when the proxy cannot be reached or
+	// refuses to open a tunnel to the server (CONNECT)
+	ErrorProxyConnect = errors.New("proxy connect error")
+	// ErrorTLSHandshake is returned when the TLS handshake with the server
+	// fails over the tunnel of the proxy
+	ErrorTLSHandshake = errors.New("TLS handshake error")
+)
@@ -0,0 +1,148 @@
+package dedup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"crawler/app/pkg/utils/filex"
+)
+
+// Set is a bounded and time-windowed set of item IDs.
+//
+// An ID is remembered for the window duration after it has been first seen.
+// When the set is full, the oldest IDs are forgotten first.
+//
+// The zero value is not usable, use NewSet.
+type Set struct {
+	window  time.Duration
+	maxSize int
+
+	seenAt map[int]time.Time
+	// the IDs in insertion order, used for expiration and eviction.
+	// order[head:] are the IDs still in the set.
+	order []int
+	head  int
+
+	mu sync.Mutex
+}
+
+// entry is the persisted representation of an ID of the set.
+type entry struct {
+	ID     int       `json:"id"`
+	SeenAt time.Time `json:"seen_at"`
+}
+
+func NewSet(window time.Duration, maxSize int) *Set {
+	return &Set{
+		window:  window,
+		maxSize: max(maxSize, 1),
+		seenAt:  make(map[int]time.Time),
+	}
+}
+
+// Contains reports whether the ID is in the set.
+func (s *Set) Contains(id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(time.Now())
+	_, ok := s.seenAt[id]
+	return ok
+}
+
+// Add adds the ID to the set, unless it is already in it.
+func (s *Set) Add(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.expire(now)
+	if _, ok := s.seenAt[id]; !ok {
+		s.add(id, now)
+	}
+}
+
+func (s *Set) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.seenAt)
+}
+
+// Save writes the IDs of the set to path, replacing the file atomically.
+func (s *Set) Save(path string) error {
+	s.mu.Lock()
+	s.expire(time.Now())
+	entries := make([]entry, 0, len(s.seenAt))
+	for _, id := range s.order[s.head:] {
+		entries = append(entries, entry{ID: id, SeenAt: s.seenAt[id]})
+	}
+	s.mu.Unlock()
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("could not encode dedup set: %w", err)
+	}
+
+	if err := filex.WriteAtomic(path, data); err != nil {
+		return fmt.Errorf("could not write dedup file: %w", err)
+	}
+	return nil
+}
+
+// Load adds the IDs saved in path to the set, skipping the expired ones.
+// If the file does not exist the returned error wraps os.ErrNotExist.
+func (s *Set) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var entries []entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("could not decode dedup file %s: %w", path, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the entries are saved in insertion order, so the order of the set is preserved
+	for _, entry := range entries {
+		if _, ok := s.seenAt[entry.ID]; !ok {
+			s.add(entry.ID, entry.SeenAt)
+		}
+	}
+	s.expire(time.Now())
+
+	return nil
+}
+
+// add must be called with the lock held and the ID not in the set.
+func (s *Set) add(id int, seenAt time.Time) {
+	s.seenAt[id] = seenAt
+	s.order = append(s.order, id)
+
+	for len(s.seenAt) > s.maxSize {
+		s.forgetOldest()
+	}
+}
+
+// expire must be called with the lock held.
+func (s *Set) expire(now time.Time) {
+	for s.head < len(s.order) && now.Sub(s.seenAt[s.order[s.head]]) >= s.window {
+		s.forgetOldest()
+	}
+}
+
+func (s *Set) forgetOldest() {
+	delete(s.seenAt, s.order[s.head])
+	s.head++
+
+	// compacts the order slice once the forgotten IDs take most of it
+	if s.head > 1024 && s.head*2 > len(s.order) {
+		s.order = append([]int(nil), s.order[s.head:]...)
+		s.head = 0
+	}
+}
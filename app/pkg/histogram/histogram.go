@@ -0,0 +1,125 @@
+package histogram
+
+import (
+	"math"
+	"math/bits"
+)
+
+const (
+	// Values are recorded with subBucketBits bits of precision,
+	// i.e. with a relative error lower than 1/2^(subBucketBits-1) (~1.6%).
+	subBucketBits  = 7
+	subBucketCount = 1 << subBucketBits
+	subBucketHalf  = subBucketCount / 2
+
+	// values lower than subBucketCount are recorded exactly, the following
+	// powers of 2 (up to 2^32) are split into subBucketHalf buckets each
+	bucketsAmount = subBucketCount + (32-subBucketBits)*subBucketHalf
+)
+
+// Histogram is a streaming HDR-style histogram of uint32 values (e.g. delays
+// in milliseconds). It uses a fixed amount of memory regardless of the amount
+// of recorded values, while percentiles keep a bounded relative error.
+//
+// The zero value is an empty histogram ready to use.
+// A Histogram is not safe for concurrent use.
+type Histogram struct {
+	counts [bucketsAmount]uint64
+
+	count uint64
+	sum   uint64
+	min   uint32
+	max   uint32
+}
+
+func (h *Histogram) Record(value uint32) {
+	h.counts[bucketIndex(value)]++
+
+	if h.count == 0 || value < h.min {
+		h.min = value
+	}
+	h.max = max(h.max, value)
+	h.count++
+	h.sum += uint64(value)
+}
+
+func (h *Histogram) Count() uint64 {
+	return h.count
+}
+
+// Min and Max return the exact lowest and highest recorded values, 0 if the histogram is empty.
+func (h *Histogram) Min() uint32 {
+	return h.min
+}
+
+func (h *Histogram) Max() uint32 {
+	return h.max
+}
+
+// Sum returns the exact sum of the recorded values.
+func (h *Histogram) Sum() uint64 {
+	return h.sum
+}
+
+// Mean returns the exact mean of the recorded values, 0 if the histogram is empty.
+func (h *Histogram) Mean() float64 {
+	if h.count == 0 {
+		return 0
+	}
+	return float64(h.sum) / float64(h.count)
+}
+
+// Percentile returns the value below which the quantile (in [0, 1]) of the
+// recorded values fall, 0 if the histogram is empty.
+// The result is the highest value of its bucket, capped at Max.
+func (h *Histogram) Percentile(quantile float64) uint32 {
+	if h.count == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(min(max(quantile, 0), 1) * float64(h.count)))
+	rank = max(rank, 1)
+
+	var seen uint64
+	for idx, bucketCount := range h.counts {
+		seen += bucketCount
+		if seen >= rank {
+			return min(bucketHighestValue(idx), h.max)
+		}
+	}
+	return h.max
+}
+
+// ForEach calls fn for each non empty bucket, in ascending order, with the
+// highest value of the bucket (capped at Max) and the amount of values recorded in it.
+func (h *Histogram) ForEach(fn func(value uint32, count uint64)) {
+	for idx, bucketCount := range h.counts {
+		if bucketCount > 0 {
+			fn(min(bucketHighestValue(idx), h.max), bucketCount)
+		}
+	}
+}
+
+func (h *Histogram) Reset() {
+	*h = Histogram{}
+}
+
+func bucketIndex(value uint32) int {
+	if value < subBucketCount {
+		return int(value)
+	}
+
+	// value has bits.Len32(value) significant bits, only the highest subBucketBits are kept
+	shift := bits.Len32(value) - subBucketBits
+	return subBucketCount + (shift-1)*subBucketHalf + int(value>>shift) - subBucketHalf
+}
+
+func bucketHighestValue(idx int) uint32 {
+	if idx < subBucketCount {
+		return uint32(idx)
+	}
+
+	shift := (idx-subBucketCount)/subBucketHalf + 1
+	subBucket := uint64((idx-subBucketCount)%subBucketHalf + subBucketHalf)
+	return uint32(min((subBucket+1)<<shift-1, math.MaxUint32))
+}
@@ -0,0 +1,54 @@
+package histogram
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBuckets(t *testing.T) {
+	tests := []struct {
+		value        uint32
+		index        int
+		highestValue uint32
+	}{
+		{value: 0, index: 0, highestValue: 0},
+		{value: 1, index: 1, highestValue: 1},
+		{value: 127, index: 127, highestValue: 127},
+		{value: 128, index: 128, highestValue: 129},
+		{value: 129, index: 128, highestValue: 129},
+		{value: 255, index: 191, highestValue: 255},
+		{value: 256, index: 192, highestValue: 259},
+		{value: 1<<16 - 1, index: 703, highestValue: 1<<16 - 1},
+		{value: 1 << 16, index: 704, highestValue: 65<<10 - 1},
+		{value: 1<<31 - 1, index: 1663, highestValue: 1<<31 - 1},
+		{value: 1 << 31, index: 1664, highestValue: 65<<25 - 1},
+		{value: math.MaxUint32, index: bucketsAmount - 1, highestValue: math.MaxUint32},
+	}
+
+	for _, test := range tests {
+		index := bucketIndex(test.value)
+		if index != test.index {
+			t.Errorf("expected value %d in bucket %d, got %d", test.value, test.index, index)
+			continue
+		}
+		if highestValue := bucketHighestValue(index); highestValue != test.highestValue {
+			t.Errorf("expected bucket %d to have highest value %d, got %d", index, test.highestValue, highestValue)
+		}
+		// the value must not fit in the previous bucket
+		if index > 0 && bucketHighestValue(index-1) >= test.value {
+			t.Errorf("expected value %d to be higher than the highest value of bucket %d, got %d",
+				test.value, index-1, bucketHighestValue(index-1))
+		}
+	}
+}
+
+func TestSumIsExact(t *testing.T) {
+	var h Histogram
+	for _, value := range []uint32{128, 129, 1000, 1001, math.MaxUint32} {
+		h.Record(value)
+	}
+
+	if sum := h.Sum(); sum != 128+129+1000+1001+math.MaxUint32 {
+		t.Errorf("expected sum %d, got %d", uint64(128+129+1000+1001+math.MaxUint32), sum)
+	}
+}
@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Labels are the constant labels of a metric, e.g. {"channel": "backup"}.
+type Labels map[string]string
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	bits atomic.Uint64
+}
+
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		return
+	}
+	addFloat(&c.bits, delta)
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+func (g *Gauge) Set(value float64) {
+	g.bits.Store(math.Float64bits(value))
+}
+
+func (g *Gauge) Add(delta float64) {
+	addFloat(&g.bits, delta)
+}
+
+func (g *Gauge) value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+// gaugeFunc is a gauge whose value is computed on each scrape.
+type gaugeFunc struct {
+	fn func() float64
+}
+
+func (gf *gaugeFunc) value() float64 {
+	return gf.fn()
+}
+
+// Histogram counts the observed values in cumulative buckets.
+type Histogram struct {
+	// the sorted upper bounds of the buckets, +Inf excluded
+	upperBounds []float64
+
+	// counts[i] is the amount of values in (upperBounds[i-1], upperBounds[i]],
+	// the last one being the amount of values greater than all the upper bounds.
+	counts []uint64
+	sum    float64
+	count  uint64
+
+	mu sync.Mutex
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	upperBounds := append([]float64(nil), buckets...)
+	sort.Float64s(upperBounds)
+
+	return &Histogram{
+		upperBounds: upperBounds,
+		counts:      make([]uint64, len(upperBounds)+1),
+	}
+}
+
+func (h *Histogram) Observe(value float64) {
+	h.ObserveN(value, 1)
+}
+
+// ObserveN observes the same value n times.
+func (h *Histogram) ObserveN(value float64, n uint64) {
+	idx := sort.SearchFloat64s(h.upperBounds, value)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.counts[idx] += n
+	h.sum += value * float64(n)
+	h.count += n
+}
+
+// CountN counts n values in the bucket of value without adding them to the sum,
+// which is then added with AddSum. It is meant for values only known approximately
+// (e.g. through the buckets of another histogram) whose sum is known exactly.
+func (h *Histogram) CountN(value float64, n uint64) {
+	idx := sort.SearchFloat64s(h.upperBounds, value)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.counts[idx] += n
+	h.count += n
+}
+
+// AddSum adds delta to the sum of the values, see CountN.
+func (h *Histogram) AddSum(delta float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.sum += delta
+}
+
+// snapshot returns the cumulative counts of the buckets (+Inf included), the sum and the count.
+func (h *Histogram) snapshot() (cumulative []uint64, sum float64, count uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cumulative = make([]uint64, len(h.counts))
+	var total uint64
+	for idx, bucketCount := range h.counts {
+		total += bucketCount
+		cumulative[idx] = total
+	}
+	return cumulative, h.sum, h.count
+}
+
+// ExponentialBuckets returns count upper bounds starting from start,
+// each one factor times the previous one.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for idx := range buckets {
+		buckets[idx] = start
+		start *= factor
+	}
+	return buckets
+}
+
+func addFloat(bits *atomic.Uint64, delta float64) {
+	for {
+		old := bits.Load()
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if bits.CompareAndSwap(old, updated) {
+			return
+		}
+	}
+}
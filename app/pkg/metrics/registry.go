@@ -0,0 +1,184 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// Registry holds the metrics and writes them in the Prometheus text exposition format.
+//
+// Metrics sharing a name (with different labels) are grouped in the same family,
+// which must have a single type.
+type Registry struct {
+	families []*family
+	byName   map[string]*family
+	mu       sync.Mutex
+}
+
+type family struct {
+	name    string
+	help    string
+	typ     string
+	metrics []*labeledMetric
+}
+
+type labeledMetric struct {
+	// the formatted labels, e.g. `channel="backup"`, empty if there are none
+	labels string
+	metric any
+}
+
+func NewRegistry() *Registry {
+	return &Registry{byName: make(map[string]*family)}
+}
+
+func (r *Registry) NewCounter(name, help string, labels Labels) *Counter {
+	counter := &Counter{}
+	r.register(name, help, typeCounter, labels, counter)
+	return counter
+}
+
+func (r *Registry) NewGauge(name, help string, labels Labels) *Gauge {
+	gauge := &Gauge{}
+	r.register(name, help, typeGauge, labels, gauge)
+	return gauge
+}
+
+// NewGaugeFunc registers a gauge whose value is computed by fn on each scrape.
+// fn must be safe for concurrent use.
+func (r *Registry) NewGaugeFunc(name, help string, labels Labels, fn func() float64) {
+	r.register(name, help, typeGauge, labels, &gaugeFunc{fn: fn})
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds.
+// The +Inf bucket is implicit.
+func (r *Registry) NewHistogram(name, help string, labels Labels, buckets []float64) *Histogram {
+	histogram := newHistogram(buckets)
+	r.register(name, help, typeHistogram, labels, histogram)
+	return histogram
+}
+
+// register panics if a family with the same name but a different type
+// already exists, as it is a programming error.
+func (r *Registry) register(name, help, typ string, labels Labels, metric any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fam, ok := r.byName[name]
+	if !ok {
+		fam = &family{name: name, help: help, typ: typ}
+		r.byName[name] = fam
+		r.families = append(r.families, fam)
+	} else if fam.typ != typ {
+		panic(fmt.Sprintf("metric %s registered as both %s and %s", name, fam.typ, typ))
+	}
+
+	fam.metrics = append(fam.metrics, &labeledMetric{labels: formatLabels(labels), metric: metric})
+}
+
+// Expose writes all the metrics in the Prometheus text exposition format.
+func (r *Registry) Expose(w io.Writer) error {
+	r.mu.Lock()
+	families := append([]*family(nil), r.families...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, fam := range families {
+		fmt.Fprintf(bw, "# HELP %s %s\n", fam.name, escapeHelp(fam.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", fam.name, fam.typ)
+
+		for _, lm := range fam.metrics {
+			switch metric := lm.metric.(type) {
+			case *Counter:
+				writeSample(bw, fam.name, lm.labels, metric.value())
+			case *Gauge:
+				writeSample(bw, fam.name, lm.labels, metric.value())
+			case *gaugeFunc:
+				writeSample(bw, fam.name, lm.labels, metric.value())
+			case *Histogram:
+				writeHistogram(bw, fam.name, lm.labels, metric)
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+func writeHistogram(w io.Writer, name, labels string, histogram *Histogram) {
+	cumulative, sum, count := histogram.snapshot()
+
+	for idx, upperBound := range histogram.upperBounds {
+		writeSample(w, name+"_bucket", joinLabels(labels, `le="`+formatFloat(upperBound)+`"`), float64(cumulative[idx]))
+	}
+	writeSample(w, name+"_bucket", joinLabels(labels, `le="+Inf"`), float64(cumulative[len(cumulative)-1]))
+	writeSample(w, name+"_sum", labels, sum)
+	writeSample(w, name+"_count", labels, float64(count))
+}
+
+func writeSample(w io.Writer, name, labels string, value float64) {
+	if labels != "" {
+		fmt.Fprintf(w, "%s{%s} %s\n", name, labels, formatFloat(value))
+	} else {
+		fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+	}
+}
+
+// formatLabels formats the labels sorted by name, so that the output is stable.
+func formatLabels(labels Labels) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, len(names))
+	for idx, name := range names {
+		pairs[idx] = name + `="` + escapeLabelValue(labels[name]) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func joinLabels(labels, extra string) string {
+	if labels == "" {
+		return extra
+	}
+	return labels + "," + extra
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+var (
+	helpReplacer       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpReplacer.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
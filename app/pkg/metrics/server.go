@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Handler returns an http.Handler serving the metrics of the registry.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := r.Expose(w); err != nil {
+			slog.Warn(fmt.Sprintf("(Metrics): error writing metrics response: %s", err.Error()))
+		}
+	})
+}
+
+// Serve serves the metrics of the registry at path on address until ctx is done.
+// It returns an error if the server cannot listen on address.
+func Serve(ctx context.Context, address, path string, registry *Registry) error {
+	mux := http.NewServeMux()
+	mux.Handle(path, registry.Handler())
+
+	server := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package proxypool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"time"
+
+	customerrors "crawler/app/pkg/custom-types/custom-errors"
+)
+
+const (
+	// the weight of the last outcome in the success rate of a proxy,
+	// and of the last latency in its average latency
+	successRateWeight = 0.2
+	latencyWeight     = 0.1
+
+	// the minimum weight of a proxy, so that a failing proxy keeps being
+	// picked once in a while until it is evicted or works again
+	minWeight = 0.01
+
+	// the success rate a proxy is reinstated with after a successful probe,
+	// so that it is picked less than the healthy ones until it proves to work
+	reinstatedSuccessRate = 0.5
+
+	// how often the evicted proxies are checked for a due probe
+	probeCheckInterval = time.Second
+
+	// the maximum amount of probes running at once, so that a whole list of dead
+	// proxies does not open thousands of connections at the same time
+	maxConcurrentProbes = 16
+)
+
+// weight returns the probability of the proxy to be accepted by a pick, in
+// [minWeight, 1]. The failures weigh more than the latency, a proxy answering
+// in 1 second being picked half as much as an instant one.
+// It must be called with the lock held.
+func (proxy *Proxy) weight() float64 {
+	latencyFactor := 1 / (1 + proxy.avgLatency.Seconds())
+	return max(proxy.successRate*proxy.successRate*latencyFactor, minWeight)
+}
+
+// Report records the outcome of a request sent through proxy: err is the
+// error of the request, if any, and latency the time taken by the response.
+//
+// The responses of the server, whatever their status, count as successes of the
+// proxy, the rate limited proxies being put into cooldown. Only the failures of the
+// proxy itself (customerrors.ErrorProxyConnect and customerrors.ErrorTLSHandshake)
+// count as failures, and a proxy is evicted after too many consecutive ones.
+// The other errors (e.g. timeouts, aborted requests or slow responses) might be
+// caused by the server, so they say nothing about the proxy.
+func (p *Pool) Report(proxy *Proxy, latency time.Duration, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case err == nil || errors.As(err, new(customerrors.ErrorHttpResponse)):
+		p.onResponse(proxy, latency)
+		if errors.Is(err, customerrors.ErrorRateLimit) {
+			p.onRateLimited(proxy)
+		} else if err == nil {
+			proxy.cooldown = 0
+		}
+	case errors.Is(err, customerrors.ErrorProxyConnect), errors.Is(err, customerrors.ErrorTLSHandshake):
+		p.onFailure(proxy, err)
+	}
+}
+
+// onResponse must be called with the lock held.
+func (p *Pool) onResponse(proxy *Proxy, latency time.Duration) {
+	proxy.successRate += successRateWeight * (1 - proxy.successRate)
+	proxy.consecutiveFailures = 0
+
+	if latency > 0 {
+		if proxy.avgLatency == 0 {
+			proxy.avgLatency = latency
+		} else {
+			proxy.avgLatency += time.Duration(latencyWeight * float64(latency-proxy.avgLatency))
+		}
+	}
+}
+
+// onRateLimited must be called with the lock held.
+func (p *Pool) onRateLimited(proxy *Proxy) {
+	proxy.rateLimits++
+	initialCooldown := (time.Duration)(p.perProxy.CooldownSeconds) * time.Second
+	if initialCooldown <= 0 {
+		return
+	}
+
+	// the requests sent before the cooldown keep getting rate limited for a while,
+	// they must not extend it
+	now := time.Now()
+	if now.Before(proxy.cooldownUntil) {
+		return
+	}
+
+	maxCooldown := max((time.Duration)(p.perProxy.MaxCooldownSeconds)*time.Second, initialCooldown)
+	proxy.cooldown = min(max(proxy.cooldown*2, initialCooldown), maxCooldown)
+	proxy.cooldownUntil = now.Add(proxy.cooldown)
+
+	slog.Debug(fmt.Sprintf(
+		"(ProxyPool): proxy %s rate limited, cooling down for %s", proxy.URL.Redacted(), proxy.cooldown,
+	))
+}
+
+// onFailure must be called with the lock held.
+func (p *Pool) onFailure(proxy *Proxy, err error) {
+	proxy.failures++
+	switch {
+	case errors.Is(err, customerrors.ErrorProxyConnect):
+		proxy.connectFailures++
+	case errors.Is(err, customerrors.ErrorTLSHandshake):
+		proxy.tlsFailures++
+	}
+	proxy.successRate -= successRateWeight * proxy.successRate
+	proxy.consecutiveFailures++
+
+	if proxy.evicted || p.health.EvictionFailures == 0 || proxy.consecutiveFailures < p.health.EvictionFailures {
+		return
+	}
+
+	proxy.evicted = true
+	p.evicted++
+	proxy.nextProbe = time.Now().Add(p.probeInterval())
+	slog.Warn(fmt.Sprintf(
+		"(ProxyPool): proxy %s evicted after %d consecutive failures, probing it every %s: %s",
+		proxy.URL.Redacted(), proxy.consecutiveFailures, p.probeInterval(), err.Error(),
+	))
+}
+
+// reinstate must be called with the lock held, on an evicted proxy.
+func (p *Pool) reinstate(proxy *Proxy) {
+	proxy.evicted = false
+	p.evicted--
+	proxy.consecutiveFailures = 0
+	proxy.successRate = max(proxy.successRate, reinstatedSuccessRate)
+}
+
+// probeInterval must be called with the lock held.
+func (p *Pool) probeInterval() time.Duration {
+	return (time.Duration)(p.health.ProbeIntervalSeconds) * time.Second
+}
+
+// ProbeLoop probes the evicted proxies every probe interval until ctx is done,
+// at most maxConcurrentProbes at once. A proxy is reinstated once probe returns
+// no error through it.
+func (p *Pool) ProbeLoop(ctx context.Context, probe func(ctx context.Context, proxyUrl *url.URL) error) {
+	ticker := time.NewTicker(probeCheckInterval)
+	defer ticker.Stop()
+
+	// a buffered channel used as a semaphore to limit the concurrent probes
+	slots := make(chan struct{}, maxConcurrentProbes)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// the proxies left out are due on the next tick
+		for _, proxy := range p.dueProbes(time.Now(), maxConcurrentProbes-len(slots)) {
+			slots <- struct{}{}
+			go func() {
+				defer func() { <-slots }()
+				err := probe(ctx, proxy.URL)
+				p.onProbed(proxy, err)
+			}()
+		}
+	}
+}
+
+// dueProbes returns at most limit evicted proxies whose probe is due, marking them as probing.
+func (p *Pool) dueProbes(now time.Time, limit int) []*Proxy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.evicted == 0 || limit <= 0 {
+		return nil
+	}
+
+	var due []*Proxy
+	for _, proxy := range p.proxies {
+		if len(due) == limit {
+			break
+		}
+		if proxy.evicted && !proxy.probing && !now.Before(proxy.nextProbe) {
+			proxy.probing = true
+			due = append(due, proxy)
+		}
+	}
+	return due
+}
+
+func (p *Pool) onProbed(proxy *Proxy, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	proxy.probing = false
+	// the proxy might have been reinstated or removed from the pool meanwhile
+	if !proxy.evicted || !slices.Contains(p.proxies, proxy) {
+		return
+	}
+
+	if err != nil {
+		proxy.nextProbe = time.Now().Add(p.probeInterval())
+		slog.Debug(fmt.Sprintf("(ProxyPool): probe of evicted proxy %s failed: %s", proxy.URL.Redacted(), err.Error()))
+		return
+	}
+
+	p.reinstate(proxy)
+	slog.Info(fmt.Sprintf("(ProxyPool): proxy %s reinstated after a successful probe", proxy.URL.Redacted()))
+}
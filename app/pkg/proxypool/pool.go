@@ -0,0 +1,301 @@
+package proxypool
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net/url"
+	"sync"
+	"time"
+
+	assetshandler "crawler/app/pkg/assets-handler"
+)
+
+// the amount of random proxies tried by Pick before scanning the whole pool,
+// so that the pick is cheap while most proxies are available
+const randomPickAttempts = 8
+
+// Pool is the set of the proxies the requests are sent through.
+//
+// The rate limits are enforced per egress IP, so each proxy has its own budget
+// of requests per second and is put into cooldown when it is rate limited,
+// the cooldown doubling on consecutive rate limits. Pick only returns the proxies
+// that are not cooling down and that have budget left, so that a hot proxy does
+// not slow the whole crawl down.
+//
+// Among them, the proxies are picked weighted by their health (see health.go):
+// the failing and slow proxies are picked less, and the ones that keep failing
+// are evicted until a probe succeeds through them.
+//
+// It is safe for concurrent use.
+type Pool struct {
+	mu       sync.Mutex
+	proxies  []*Proxy
+	perProxy assetshandler.PerProxyCfg
+	health   assetshandler.ProxyHealthCfg
+	// the amount of evicted proxies
+	evicted int
+}
+
+// Proxy is a proxy of the pool along with its state.
+// Its fields other than URL are guarded by the mutex of the pool.
+type Proxy struct {
+	URL *url.URL
+
+	// the budget of the proxy, unused if the pool has no requests per second
+	tokens     float64
+	lastRefill time.Time
+
+	cooldownUntil time.Time
+	// the last cooldown, doubled on consecutive rate limits and reset on a success
+	cooldown time.Duration
+
+	// the exponential moving average of the outcomes, 1 being a response from the server
+	successRate         float64
+	consecutiveFailures int
+	evicted             bool
+	// whether a probe is running, and when the next one is due while evicted
+	probing   bool
+	nextProbe time.Time
+
+	requests        int64
+	rateLimits      int64
+	failures        int64
+	connectFailures int64
+	tlsFailures     int64
+	// the exponential moving average of the latency of the responses
+	avgLatency time.Duration
+}
+
+func New(perProxy *assetshandler.PerProxyCfg, health *assetshandler.ProxyHealthCfg) *Pool {
+	return &Pool{perProxy: *perProxy, health: *health}
+}
+
+// Load replaces the proxies of the pool. The state of the proxies
+// that were already in the pool (by URL) is kept.
+func (p *Pool) Load(proxies []*url.URL) error {
+	if len(proxies) == 0 {
+		return errors.New("tried to load pool with an empty proxies slice")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	previous := make(map[string]*Proxy, len(p.proxies))
+	for _, proxy := range p.proxies {
+		previous[proxy.URL.String()] = proxy
+	}
+
+	now := time.Now()
+	loaded := make([]*Proxy, len(proxies))
+	p.evicted = 0
+	for idx, proxyUrl := range proxies {
+		proxy, ok := previous[proxyUrl.String()]
+		if !ok {
+			// the new proxies are trusted until they fail
+			proxy = &Proxy{URL: proxyUrl, tokens: p.burst(), lastRefill: now, successRate: 1}
+		}
+		if proxy.evicted {
+			p.evicted++
+		}
+		loaded[idx] = proxy
+	}
+	p.proxies = loaded
+	return nil
+}
+
+// Configure changes the budget, the cooldown and the eviction of the proxies.
+// The current cooldowns are kept, the evicted proxies are reinstated if the eviction is disabled.
+func (p *Pool) Configure(perProxy *assetshandler.PerProxyCfg, health *assetshandler.ProxyHealthCfg) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for _, proxy := range p.proxies {
+		p.refill(proxy, now)
+	}
+	p.perProxy = *perProxy
+	p.health = *health
+	for _, proxy := range p.proxies {
+		proxy.tokens = min(proxy.tokens, p.burst())
+		if p.health.EvictionFailures == 0 && proxy.evicted {
+			p.reinstate(proxy)
+		}
+	}
+}
+
+// Pick returns a proxy among the available ones, weighted by their health,
+// taking a request of its budget. If none is available, it waits for the
+// first one to be, or returns the ctx error if ctx is done before.
+//
+// The evicted proxies are only picked if every proxy is evicted.
+func (p *Pool) Pick(ctx context.Context, randGen *rand.Rand) (*Proxy, error) {
+	for {
+		proxy, wait := p.tryPick(randGen, time.Now())
+		if proxy != nil {
+			return proxy, nil
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// tryPick returns an available proxy or, if none is, the time until the first one is.
+func (p *Pool) tryPick(randGen *rand.Rand, now time.Time) (*Proxy, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	skipEvicted := p.evicted < len(p.proxies)
+
+	// a random proxy is accepted with a probability of its weight (at most 1),
+	// which picks the proxies weighted by their weight
+	for range min(randomPickAttempts, len(p.proxies)) {
+		proxy := p.proxies[randGen.Intn(len(p.proxies))]
+		if (skipEvicted && proxy.evicted) || p.availableIn(proxy, now) > 0 {
+			continue
+		}
+		if randGen.Float64() < proxy.weight() {
+			p.take(proxy)
+			return proxy, 0
+		}
+	}
+
+	// most proxies are unavailable or unhealthy, the available ones are picked from a full scan
+	wait := time.Duration(math.MaxInt64)
+	var available []*Proxy
+	var totalWeight float64
+	for _, proxy := range p.proxies {
+		if skipEvicted && proxy.evicted {
+			continue
+		}
+		proxyWait := p.availableIn(proxy, now)
+		if proxyWait == 0 {
+			available = append(available, proxy)
+			totalWeight += proxy.weight()
+		}
+		wait = min(wait, proxyWait)
+	}
+	if len(available) == 0 {
+		return nil, wait
+	}
+
+	target := randGen.Float64() * totalWeight
+	proxy := available[len(available)-1]
+	for _, candidate := range available {
+		target -= candidate.weight()
+		if target < 0 {
+			proxy = candidate
+			break
+		}
+	}
+	p.take(proxy)
+	return proxy, 0
+}
+
+// availableIn returns the time until the proxy can be picked, 0 if it can be now.
+// It must be called with the lock held.
+func (p *Pool) availableIn(proxy *Proxy, now time.Time) time.Duration {
+	wait := max(proxy.cooldownUntil.Sub(now), 0)
+	if p.perProxy.RequestsPerSecond > 0 {
+		p.refill(proxy, now)
+		if proxy.tokens < 1 {
+			wait = max(wait, time.Duration((1-proxy.tokens)/p.perProxy.RequestsPerSecond*float64(time.Second)))
+		}
+	}
+	return wait
+}
+
+// take must be called with the lock held.
+func (p *Pool) take(proxy *Proxy) {
+	if p.perProxy.RequestsPerSecond > 0 {
+		proxy.tokens--
+	}
+	proxy.requests++
+}
+
+// refill must be called with the lock held.
+func (p *Pool) refill(proxy *Proxy, now time.Time) {
+	if elapsed := now.Sub(proxy.lastRefill); elapsed > 0 {
+		proxy.tokens = min(proxy.tokens+elapsed.Seconds()*p.perProxy.RequestsPerSecond, p.burst())
+		proxy.lastRefill = now
+	}
+}
+
+// burst must be called with the lock held.
+func (p *Pool) burst() float64 {
+	if p.perProxy.Burst > 0 {
+		return float64(p.perProxy.Burst)
+	}
+	return max(math.Ceil(p.perProxy.RequestsPerSecond), 1)
+}
+
+// Stats is the state of a proxy, as exposed by the admin API.
+type Stats struct {
+	// the URL with its password redacted
+	URL             string     `json:"url"`
+	Score           float64    `json:"score"`
+	Evicted         bool       `json:"evicted"`
+	Requests        int64      `json:"requests"`
+	RateLimits      int64      `json:"rate_limits"`
+	Failures        int64      `json:"failures"`
+	ConnectFailures int64      `json:"connect_failures"`
+	TLSFailures     int64      `json:"tls_failures"`
+	AvgLatencyMilli int64      `json:"avg_latency_milli"`
+	CooldownUntil   *time.Time `json:"cooldown_until,omitempty"`
+}
+
+// Stats returns the state of each proxy, in the order of the pool.
+func (p *Pool) Stats() []Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	stats := make([]Stats, len(p.proxies))
+	for idx, proxy := range p.proxies {
+		stats[idx] = Stats{
+			URL:             proxy.URL.Redacted(),
+			Score:           proxy.weight(),
+			Evicted:         proxy.evicted,
+			Requests:        proxy.requests,
+			RateLimits:      proxy.rateLimits,
+			Failures:        proxy.failures,
+			ConnectFailures: proxy.connectFailures,
+			TLSFailures:     proxy.tlsFailures,
+			AvgLatencyMilli: proxy.avgLatency.Milliseconds(),
+		}
+		if proxy.cooldownUntil.After(now) {
+			// copied, as the field is guarded by the lock while the stats are read after it is released
+			cooldownUntil := proxy.cooldownUntil
+			stats[idx].CooldownUntil = &cooldownUntil
+		}
+	}
+	return stats
+}
+
+// Counts is the amount of proxies of the pool in each state.
+type Counts struct {
+	CoolingDown int
+	Evicted     int
+	Total       int
+}
+
+func (p *Pool) Counts() Counts {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	counts := Counts{Evicted: p.evicted, Total: len(p.proxies)}
+	for _, proxy := range p.proxies {
+		if proxy.cooldownUntil.After(now) {
+			counts.CoolingDown++
+		}
+	}
+	return counts
+}
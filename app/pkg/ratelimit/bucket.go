@@ -0,0 +1,126 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// the minimum time between two decreases of the rate of a bucket: the requests
+// sent before a decrease keep getting rate limited for a while, and they must
+// not decrease the rate again
+const decreaseCooldown = time.Second
+
+// bucket is a token bucket whose rate adapts to the rate limits of the server
+// (AIMD): it is multiplied by decreaseFactor on a rate limit, and increased by
+// increasePerSecond each second while the requests succeed, within [minRate, maxRate].
+//
+// The tokens are reserved in advance, so they go below 0 when the requests are
+// queued: each request waits for its own token, spreading the requests at the rate.
+type bucket struct {
+	mu sync.Mutex
+
+	maxRate           float64
+	minRate           float64
+	burst             float64
+	decreaseFactor    float64
+	increasePerSecond float64
+
+	rate         float64
+	tokens       float64
+	lastRefill   time.Time
+	lastDecrease time.Time
+}
+
+func newBucket(maxRate, minRate float64, burst int, decreaseFactor, increasePerSecond float64, now time.Time) *bucket {
+	b := &bucket{rate: maxRate, lastRefill: now}
+	b.configure(maxRate, minRate, burst, decreaseFactor, increasePerSecond, now)
+	b.tokens = b.burst
+	return b
+}
+
+// configure changes the budget of the bucket, keeping its current rate within the new bounds.
+func (b *bucket) configure(maxRate, minRate float64, burst int, decreaseFactor, increasePerSecond float64, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	b.maxRate = maxRate
+	b.minRate = min(minRate, maxRate)
+	b.burst = float64(burst)
+	if burst <= 0 {
+		b.burst = math.Ceil(maxRate)
+	}
+	b.decreaseFactor = decreaseFactor
+	b.increasePerSecond = increasePerSecond
+
+	b.setRate(min(max(b.rate, b.minRate), b.maxRate))
+	b.tokens = min(b.tokens, b.burst)
+}
+
+// reserve takes a token and returns how long to wait before using it.
+func (b *bucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel gives back a token reserved and not used.
+func (b *bucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.tokens+1, b.burst)
+}
+
+func (b *bucket) onRateLimited(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if now.Sub(b.lastDecrease) < decreaseCooldown {
+		return
+	}
+	b.refill(now)
+	b.setRate(max(b.rate*b.decreaseFactor, b.minRate))
+	// the burst would be rate limited too
+	b.tokens = min(b.tokens, 0)
+	b.lastDecrease = now
+}
+
+func (b *bucket) onSuccess(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	// a success every 1/rate seconds, so the rate grows by increasePerSecond each second
+	b.setRate(min(b.rate+b.increasePerSecond/b.rate, b.maxRate))
+}
+
+func (b *bucket) currentRate() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rate
+}
+
+// setRate must be called with the lock held, after a refill.
+// The tokens already reserved are sent at the previous rate, so the debt is
+// rescaled for the next reservations to be queued right after them.
+func (b *bucket) setRate(rate float64) {
+	if b.tokens < 0 {
+		b.tokens *= rate / b.rate
+	}
+	b.rate = rate
+}
+
+// refill must be called with the lock held, before changing the rate.
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.lastRefill); elapsed > 0 {
+		b.tokens = min(b.tokens+elapsed.Seconds()*b.rate, b.burst)
+		b.lastRefill = now
+	}
+}
@@ -0,0 +1,155 @@
+package ratelimit
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	assetshandler "crawler/app/pkg/assets-handler"
+)
+
+// Endpoint identifies the URL a request is sent to, each one having its own budget.
+type Endpoint int
+
+const (
+	// ItemEndpoint is the URL of a single item (standard.urls.item_url).
+	ItemEndpoint Endpoint = iota
+	// ItemsEndpoint is the URL of the latest items (standard.urls.items_url).
+	// It is only requested once at startup, so it has no budget of its own.
+	ItemsEndpoint
+
+	endpointsAmount
+)
+
+func (e Endpoint) String() string {
+	switch e {
+	case ItemEndpoint:
+		return "item_url"
+	case ItemsEndpoint:
+		return "items_url"
+	default:
+		return "unknown"
+	}
+}
+
+// Limiter spreads the requests of all the workers within a global budget and a
+// budget per endpoint, each one being an adaptive token bucket (see bucket).
+// A request waits for a token of both budgets. A rate limit decreases the rate of
+// the endpoint budget if it is set, otherwise the global one: the other endpoints
+// might not be rate limited.
+//
+// The unset budgets (0 requests per second) are unlimited.
+// All methods can be called on a nil *Limiter, in which case nothing is limited.
+type Limiter struct {
+	// nil while unlimited
+	global    atomic.Pointer[bucket]
+	endpoints [endpointsAmount]atomic.Pointer[bucket]
+}
+
+func New(cfg *assetshandler.RateLimitCfg) *Limiter {
+	l := &Limiter{}
+	l.Configure(cfg)
+	return l
+}
+
+// Configure changes the budgets. The current rates are kept within the new bounds.
+func (l *Limiter) Configure(cfg *assetshandler.RateLimitCfg) {
+	if l == nil {
+		return
+	}
+
+	now := time.Now()
+	configure := func(slot *atomic.Pointer[bucket], budget assetshandler.RateBudget) {
+		if budget.RequestsPerSecond <= 0 {
+			slot.Store(nil)
+			return
+		}
+		if b := slot.Load(); b != nil {
+			b.configure(budget.RequestsPerSecond, cfg.MinRequestsPerSecond, budget.Burst,
+				cfg.DecreaseFactor, cfg.IncreasePerSecond, now)
+			return
+		}
+		slot.Store(newBucket(budget.RequestsPerSecond, cfg.MinRequestsPerSecond, budget.Burst,
+			cfg.DecreaseFactor, cfg.IncreasePerSecond, now))
+	}
+
+	configure(&l.global, cfg.Global)
+	configure(&l.endpoints[ItemEndpoint], cfg.Endpoints.ItemUrl)
+}
+
+// Wait blocks until a request can be sent to endpoint.
+// It returns the ctx error if ctx is done before, in which case no token is taken.
+func (l *Limiter) Wait(ctx context.Context, endpoint Endpoint) error {
+	if l == nil {
+		return ctx.Err()
+	}
+
+	now := time.Now()
+	var reserved []*bucket
+	var wait time.Duration
+	for _, b := range []*bucket{l.global.Load(), l.endpoints[endpoint].Load()} {
+		if b != nil {
+			reserved = append(reserved, b)
+			wait = max(wait, b.reserve(now))
+		}
+	}
+
+	if wait <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		for _, b := range reserved {
+			b.cancel()
+		}
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Observe adapts the rates to the outcome of a request sent to endpoint.
+func (l *Limiter) Observe(endpoint Endpoint, rateLimited bool) {
+	if l == nil {
+		return
+	}
+
+	now := time.Now()
+	global, endpointBucket := l.global.Load(), l.endpoints[endpoint].Load()
+	if rateLimited {
+		if endpointBucket != nil {
+			endpointBucket.onRateLimited(now)
+		} else if global != nil {
+			global.onRateLimited(now)
+		}
+		return
+	}
+
+	for _, b := range []*bucket{global, endpointBucket} {
+		if b != nil {
+			b.onSuccess(now)
+		}
+	}
+}
+
+// Rates returns the current rate of each budget, in requests per second, by name
+// ("global" or the name of the endpoint). The unlimited budgets are omitted.
+func (l *Limiter) Rates() map[string]float64 {
+	rates := make(map[string]float64)
+	if l == nil {
+		return rates
+	}
+
+	if b := l.global.Load(); b != nil {
+		rates["global"] = b.currentRate()
+	}
+	for endpoint := range endpointsAmount {
+		if b := l.endpoints[endpoint].Load(); b != nil {
+			rates[endpoint.String()] = b.currentRate()
+		}
+	}
+	return rates
+}
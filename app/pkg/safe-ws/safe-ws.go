@@ -2,10 +2,18 @@ package safews
 
 import (
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// SafeConn wraps a websocket connection allowing concurrent writes.
+//
+// Only the data messages are serialized: the control messages and Close can be
+// called concurrently with the other methods, so that a write blocked on a broken
+// connection never blocks its pings nor its closing.
+// Reads are not synchronized, as the underlying connection supports one
+// concurrent reader, so ReadMessage must only be called by a single goroutine.
 type SafeConn struct {
 	conn *websocket.Conn
 	mu   sync.Mutex
@@ -15,8 +23,44 @@ func NewSafeConn(conn *websocket.Conn) *SafeConn {
 	return &SafeConn{conn: conn}
 }
 
-func (sc *SafeConn) WriteMessage(messageType int, data []byte) error {
+// WriteMessage sends a data message that must be written within timeout.
+func (sc *SafeConn) WriteMessage(messageType int, data []byte, timeout time.Duration) error {
 	sc.mu.Lock()
 	defer sc.mu.Unlock()
+	if err := sc.conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
 	return sc.conn.WriteMessage(messageType, data)
 }
+
+// WritePing sends a ping control message that must be written within timeout.
+func (sc *SafeConn) WritePing(timeout time.Duration) error {
+	return sc.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(timeout))
+}
+
+// WriteClose sends a normal closure control message that must be written within timeout.
+// The connection is not closed, Close must still be called.
+func (sc *SafeConn) WriteClose(timeout time.Duration) error {
+	return sc.conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(timeout),
+	)
+}
+
+func (sc *SafeConn) ReadMessage() (messageType int, data []byte, err error) {
+	return sc.conn.ReadMessage()
+}
+
+func (sc *SafeConn) SetReadDeadline(t time.Time) error {
+	return sc.conn.SetReadDeadline(t)
+}
+
+func (sc *SafeConn) SetPongHandler(handler func(appData string) error) {
+	sc.conn.SetPongHandler(handler)
+}
+
+// Close closes the connection, unblocking a pending WriteMessage.
+func (sc *SafeConn) Close() error {
+	return sc.conn.Close()
+}
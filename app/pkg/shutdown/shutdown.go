@@ -5,22 +5,98 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
+var (
+	hooks    []func()
+	hooksMu  sync.Mutex
+	hooksRan atomic.Bool
+
+	drain atomic.Pointer[func() bool]
+)
+
+// OnShutdown registers a function that will be run by Shutdown before the process exits.
+// Hooks are run once, in registration order, even if Shutdown is called multiple times.
+func OnShutdown(hook func()) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	hooks = append(hooks, hook)
+}
+
+// SetDrain registers the function HandleSIGTERM runs before cancelling the context,
+// which must stop taking new work and wait for the in-flight one to be done.
+// It returns whether all the in-flight work has been done, which makes the process exit with 0.
+// Only the last registered function is run.
+func SetDrain(drainFunc func() bool) {
+	drain.Store(&drainFunc)
+}
+
+// HandleSIGTERM waits for an interrupt and exits the process.
+// If a drain function has been registered (see SetDrain), it is run first,
+// unless a second interrupt is received, which stops the program at once.
 func HandleSIGTERM(cancel context.CancelFunc) {
 	c := make(chan os.Signal, 2)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
 	<-c
 
-	cancel()
-	slog.Error("Keyboard Interrupt Received (SIGTERM): Stopped program and aborted all requests.")
-	Shutdown()
+	drainFunc := drain.Load()
+	if drainFunc == nil {
+		cancel()
+		slog.Error("Keyboard Interrupt Received (SIGTERM): Stopped program and aborted all requests.")
+		Shutdown()
+	}
+
+	slog.Warn("Keyboard Interrupt Received (SIGTERM): draining the in-flight requests, interrupt again to abort them.")
+	drained := make(chan bool, 1)
+	go func() {
+		drained <- (*drainFunc)()
+	}()
+
+	select {
+	case clean := <-drained:
+		cancel()
+		if !clean {
+			slog.Error("Stopped program, some in-flight items could not be drained.")
+			Shutdown()
+		}
+		slog.Info("Stopped program, all in-flight items have been drained.")
+		Exit(0)
+	case <-c:
+		cancel()
+		slog.Error("Keyboard Interrupt Received (SIGTERM) again: Stopped program and aborted all requests.")
+		Shutdown()
+	}
 }
 
+// Shutdown runs the hooks and exits the process with status 1.
 func Shutdown() {
+	Exit(1)
+}
+
+// Exit runs the hooks and exits the process with the given status.
+func Exit(code int) {
+	runHooks()
 	time.Sleep(250 * time.Millisecond)
-	os.Exit(1)
+	os.Exit(code)
+}
+
+// a CompareAndSwap is used instead of a sync.Once so that a hook that ends up
+// calling Shutdown (e.g. through a failed assert) does not deadlock
+func runHooks() {
+	if !hooksRan.CompareAndSwap(false, true) {
+		return
+	}
+
+	hooksMu.Lock()
+	registered := hooks
+	hooksMu.Unlock()
+
+	for _, hook := range registered {
+		hook()
+	}
 }
@@ -0,0 +1,117 @@
+package sinks
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// wsEnvelope is the message sent to the websocket consumers when acks are enabled.
+// The consumer must reply with a wsAck containing the same delivery ID.
+// A retried delivery keeps its delivery ID, so consumers can use it to detect duplicates.
+type wsEnvelope struct {
+	DeliveryID uint64                 `json:"delivery_id"`
+	Item       map[string]interface{} `json:"item"`
+}
+
+type wsAck struct {
+	Ack *uint64 `json:"ack"`
+}
+
+// parseAck returns the delivery ID acknowledged by a consumer message.
+// ok is false if the message is not an ack.
+func parseAck(data []byte) (deliveryID uint64, ok bool) {
+	var ack wsAck
+	if err := json.Unmarshal(data, &ack); err != nil || ack.Ack == nil {
+		return 0, false
+	}
+	return *ack.Ack, true
+}
+
+type pendingDelivery struct {
+	msg      *wsMessage
+	endpoint *wsEndpoint
+	sentAt   time.Time
+
+	// the attempts of msg when the delivery expired, as msg.attempts
+	// is guarded by the lock of the tracker
+	attempts int
+}
+
+// ackTracker keeps track of the deliveries that have been written to a
+// connection but not acknowledged by the consumer yet.
+type ackTracker struct {
+	timeout     time.Duration
+	maxAttempts int
+
+	lastDeliveryID atomic.Uint64
+
+	pending map[uint64]*pendingDelivery
+	mu      sync.Mutex
+}
+
+func newAckTracker(timeout time.Duration, maxAttempts int) *ackTracker {
+	return &ackTracker{
+		timeout:     timeout,
+		maxAttempts: maxAttempts,
+		pending:     make(map[uint64]*pendingDelivery),
+	}
+}
+
+func (at *ackTracker) nextDeliveryID() uint64 {
+	return at.lastDeliveryID.Add(1)
+}
+
+// track marks the message as written to the endpoint connection.
+func (at *ackTracker) track(msg *wsMessage, endpoint *wsEndpoint) {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+
+	msg.attempts++
+	at.pending[msg.deliveryID] = &pendingDelivery{
+		msg:      msg,
+		endpoint: endpoint,
+		sentAt:   time.Now(),
+	}
+}
+
+// untrack reverts track for a message whose write failed.
+func (at *ackTracker) untrack(msg *wsMessage) {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+
+	msg.attempts--
+	delete(at.pending, msg.deliveryID)
+}
+
+// ack removes the delivery from the pending ones.
+// Acks of unknown (or already expired) deliveries are ignored.
+func (at *ackTracker) ack(deliveryID uint64) {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	delete(at.pending, deliveryID)
+}
+
+// expired removes and returns the deliveries not acknowledged within the timeout.
+func (at *ackTracker) expired() []*pendingDelivery {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+
+	var expired []*pendingDelivery
+	now := time.Now()
+	for deliveryID, delivery := range at.pending {
+		if now.Sub(delivery.sentAt) >= at.timeout {
+			delivery.attempts = delivery.msg.attempts
+			expired = append(expired, delivery)
+			delete(at.pending, deliveryID)
+		}
+	}
+	return expired
+}
+
+func (at *ackTracker) len() int {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	return len(at.pending)
+}
@@ -0,0 +1,83 @@
+package sinks
+
+import (
+	"fmt"
+
+	assetshandler "crawler/app/pkg/assets-handler"
+	wtypes "crawler/app/pkg/crawler/workers/workers-types"
+	"crawler/app/pkg/utils/pathx"
+)
+
+const (
+	KindWebsocket = "websocket"
+	KindNdjson    = "ndjson"
+	KindWebhook   = "webhook"
+)
+
+// Build creates all the sinks enabled in cfg.Standard.Sinks and composes them.
+// If no sink is enabled, the websocket one is used.
+//
+// If a single sink is enabled it is returned as is, otherwise the sinks are
+// wrapped in a MultiSink. If dedup is enabled, the result is wrapped in a DedupSink.
+//
+// The sinks that track delivery statistics (e.g. acknowledgements) report them in outcome.
+func Build(cfg *assetshandler.Config, outcome *wtypes.Outcome) (Sink, error) {
+	enabled := cfg.Standard.Sinks.Enabled
+	if err := checkError(func(report assetshandler.ReportFunc) { checkEnabled(enabled, "enabled", report) }); err != nil {
+		return nil, err
+	}
+	if len(enabled) == 0 {
+		enabled = []string{KindWebsocket}
+	}
+
+	built := make([]Sink, 0, len(enabled))
+	for _, kind := range enabled {
+		var sink Sink
+		var err error
+
+		switch kind {
+		case KindWebsocket:
+			sink, err = NewWebsocketSink(&cfg.Standard.WebSocket, outcome)
+			if err == nil && cfg.Standard.WebSocket.Ordered.Enabled {
+				sink = NewOrderedSink(sink, &cfg.Standard.WebSocket.Ordered, outcome)
+			}
+		case KindNdjson:
+			ndjsonCfg := cfg.Standard.Sinks.Ndjson
+			ndjsonCfg.Dir = pathx.FromCwd(ndjsonCfg.Dir)
+			sink, err = NewNdjsonSink(&ndjsonCfg)
+		case KindWebhook:
+			sink, err = NewWebhookSink(&cfg.Standard.Sinks.Webhook, outcome)
+		default:
+			err = fmt.Errorf("unknown sink kind %q", kind)
+		}
+		if err != nil {
+			for _, builtSink := range built {
+				builtSink.Close()
+			}
+			return nil, fmt.Errorf("error building %s sink: %w", kind, err)
+		}
+
+		built = append(built, sink)
+	}
+
+	var sink Sink
+	if len(built) == 1 {
+		sink = built[0]
+	} else {
+		sink = NewMultiSink(built...)
+	}
+
+	if dedupCfg := cfg.Standard.Sinks.Dedup; dedupCfg.Enabled {
+		if dedupCfg.File != "" {
+			dedupCfg.File = pathx.FromCwd(dedupCfg.File)
+		}
+		dedupSink, err := NewDedupSink(sink, &dedupCfg, outcome)
+		if err != nil {
+			sink.Close()
+			return nil, fmt.Errorf("error building dedup stage: %w", err)
+		}
+		sink = dedupSink
+	}
+
+	return sink, nil
+}
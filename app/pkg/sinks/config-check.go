@@ -0,0 +1,123 @@
+package sinks
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	assetshandler "crawler/app/pkg/assets-handler"
+)
+
+// CheckConfig reports the problems of the sinks settings of cfg that would make Build fail.
+// It is meant to be passed to assetshandler.ValidateConfigFile.
+//
+// Build and the constructors of the sinks run the same checks, so that the
+// settings accepted by the validation are always accepted by them.
+func CheckConfig(cfg *assetshandler.Config, report assetshandler.ReportFunc) {
+	enabled := cfg.Standard.Sinks.Enabled
+	if len(enabled) == 0 {
+		enabled = []string{KindWebsocket}
+	}
+
+	checkEnabled(cfg.Standard.Sinks.Enabled, "standard.sinks.enabled", report)
+	if cfg.Standard.Sinks.HealthCheckSeconds < 0 {
+		report("standard.sinks.health_check_seconds", "must not be negative")
+	}
+	if cfg.Standard.Sinks.SlowWriteSeconds < 0 {
+		report("standard.sinks.slow_write_seconds", "must not be negative")
+	}
+	if slices.Contains(enabled, KindWebsocket) {
+		checkWebsocketCfg(&cfg.Standard.WebSocket, "standard.websocket", report)
+	}
+	if slices.Contains(enabled, KindNdjson) {
+		checkNdjsonCfg(&cfg.Standard.Sinks.Ndjson, "standard.sinks.ndjson", report)
+	}
+	if slices.Contains(enabled, KindWebhook) {
+		checkWebhookCfg(&cfg.Standard.Sinks.Webhook, "standard.sinks.webhook", report)
+	}
+	if cfg.Standard.Sinks.Dedup.Enabled {
+		checkDedupCfg(&cfg.Standard.Sinks.Dedup, "standard.sinks.dedup", report)
+	}
+}
+
+func checkEnabled(enabled []string, path string, report assetshandler.ReportFunc) {
+	for idx, kind := range enabled {
+		kindPath := fmt.Sprintf("%s[%d]", path, idx)
+		switch {
+		case kind != KindWebsocket && kind != KindNdjson && kind != KindWebhook:
+			report(kindPath, "unknown sink kind %q, should be one of %s, %s or %s",
+				kind, KindWebsocket, KindNdjson, KindWebhook)
+		case slices.Index(enabled, kind) != idx:
+			report(kindPath, "sink kind %q is enabled more than once", kind)
+		}
+	}
+}
+
+func checkWebsocketCfg(cfg *assetshandler.WebsocketCfg, path string, report assetshandler.ReportFunc) {
+	if len(cfg.WsUrls) == 0 {
+		report(keyPath(path, "ws_urls"), "at least one websocket url is required")
+	}
+	for idx, wsUrl := range cfg.WsUrls {
+		assetshandler.CheckURL(report, fmt.Sprintf("%s[%d]", keyPath(path, "ws_urls"), idx), wsUrl, "ws", "wss")
+	}
+
+	switch cfg.Routing.Mode {
+	case "", RoutingRoundRobin, RoutingHashID:
+	case RoutingHashField:
+		if cfg.Routing.Field == "" {
+			report(keyPath(path, "routing.field"), "a field is required with the %s routing", RoutingHashField)
+		}
+	default:
+		report(keyPath(path, "routing.mode"), "unknown routing mode %q, should be one of %s, %s or %s",
+			cfg.Routing.Mode, RoutingRoundRobin, RoutingHashID, RoutingHashField)
+	}
+}
+
+func checkNdjsonCfg(cfg *assetshandler.NdjsonSinkCfg, path string, report assetshandler.ReportFunc) {
+	if cfg.Dir == "" {
+		report(keyPath(path, "dir"), "must not be empty when the ndjson sink is enabled")
+	}
+	switch cfg.Compression {
+	case "", CompressionNone, CompressionGzip, CompressionZstd:
+	default:
+		report(keyPath(path, "compression"), "unknown compression %q, should be one of %s, %s or %s",
+			cfg.Compression, CompressionNone, CompressionGzip, CompressionZstd)
+	}
+}
+
+func checkWebhookCfg(cfg *assetshandler.WebhookSinkCfg, path string, report assetshandler.ReportFunc) {
+	if len(cfg.Urls) == 0 {
+		report(keyPath(path, "urls"), "at least one webhook url is required")
+	}
+	for idx, webhookUrl := range cfg.Urls {
+		assetshandler.CheckURL(report, fmt.Sprintf("%s[%d]", keyPath(path, "urls"), idx), webhookUrl, "http", "https")
+	}
+}
+
+func checkDedupCfg(cfg *assetshandler.DedupCfg, path string, report assetshandler.ReportFunc) {
+	if cfg.WindowSeconds < 0 {
+		report(keyPath(path, "window_seconds"), "must not be negative")
+	}
+	if cfg.MaxIDs < 0 {
+		report(keyPath(path, "max_ids"), "must not be negative")
+	}
+}
+
+// checkError runs check and returns the problems it reports joined in an error,
+// nil if none. It is used by the constructors to reject the settings reported
+// by CheckConfig, the paths being relative to the settings of the sink.
+func checkError(check func(report assetshandler.ReportFunc)) error {
+	var errs []error
+	check(func(path string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...)))
+	})
+	return errors.Join(errs...)
+}
+
+// keyPath returns the path of key within the settings at path, key itself if path is empty.
+func keyPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,148 @@
+package sinks
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	assetshandler "crawler/app/pkg/assets-handler"
+	wtypes "crawler/app/pkg/crawler/workers/workers-types"
+	"crawler/app/pkg/dedup"
+)
+
+// DedupSink drops the elements whose ID has already been written within
+// the dedup window, before they reach the wrapped sink.
+// The suppressed duplicates are reported in outcome.
+//
+// If a file is configured, the seen IDs are loaded from it on creation and
+// saved to it periodically and on Close, so that duplicates are also
+// suppressed across restarts.
+type DedupSink struct {
+	sink    Sink
+	outcome *wtypes.Outcome
+
+	seen *dedup.Set
+
+	// empty if the persistence is disabled
+	file     string
+	stopChan chan struct{}
+	loopDone chan struct{}
+
+	closeOnce sync.Once
+}
+
+func NewDedupSink(sink Sink, cfg *assetshandler.DedupCfg, outcome *wtypes.Outcome) (*DedupSink, error) {
+	if err := checkError(func(report assetshandler.ReportFunc) { checkDedupCfg(cfg, "", report) }); err != nil {
+		return nil, err
+	}
+
+	windowSeconds := cfg.WindowSeconds
+	if windowSeconds <= 0 {
+		windowSeconds = 3600
+	}
+	maxIDs := cfg.MaxIDs
+	if maxIDs <= 0 {
+		maxIDs = 1_000_000
+	}
+
+	dS := &DedupSink{
+		sink:     sink,
+		outcome:  outcome,
+		seen:     dedup.NewSet((time.Duration)(windowSeconds)*time.Second, maxIDs),
+		file:     cfg.File,
+		stopChan: make(chan struct{}),
+		loopDone: make(chan struct{}),
+	}
+
+	if dS.file == "" {
+		close(dS.loopDone)
+		return dS, nil
+	}
+
+	if err := dS.seen.Load(dS.file); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		slog.Info(fmt.Sprintf("(DedupSink): no dedup file found at %s, starting with an empty set", dS.file))
+	} else {
+		slog.Info(fmt.Sprintf("(DedupSink): loaded %d IDs from %s", dS.seen.Len(), dS.file))
+	}
+
+	persistSeconds := cfg.PersistIntervalSeconds
+	if persistSeconds <= 0 {
+		persistSeconds = 30
+	}
+	go dS.persistLoop((time.Duration)(persistSeconds) * time.Second)
+
+	return dS, nil
+}
+
+func (dS *DedupSink) Name() string {
+	return "dedup(" + dS.sink.Name() + ")"
+}
+
+// Write writes the element to the wrapped sink, unless its ID has already been written.
+// The ID is only remembered once the write succeeds, so that a failed item is
+// not suppressed when it is emitted again.
+func (dS *DedupSink) Write(el *wtypes.ContentElement) error {
+	if dS.seen.Contains(el.ContentID) {
+		dS.outcome.Mu.Lock()
+		dS.outcome.Duplicates++
+		dS.outcome.Mu.Unlock()
+		return nil
+	}
+
+	if err := dS.sink.Write(el); err != nil {
+		return err
+	}
+	dS.seen.Add(el.ContentID)
+	return nil
+}
+
+func (dS *DedupSink) Flush() error {
+	return dS.sink.Flush()
+}
+
+// Close closes the wrapped sink and saves the seen IDs, if the persistence is enabled.
+func (dS *DedupSink) Close() error {
+	var err error
+	dS.closeOnce.Do(func() {
+		close(dS.stopChan)
+		<-dS.loopDone
+
+		err = dS.sink.Close()
+		if dS.file != "" {
+			err = errors.Join(err, dS.seen.Save(dS.file))
+		}
+	})
+	return err
+}
+
+func (dS *DedupSink) Health() error {
+	return dS.sink.Health()
+}
+
+func (dS *DedupSink) Unwrap() []Sink {
+	return []Sink{dS.sink}
+}
+
+func (dS *DedupSink) persistLoop(interval time.Duration) {
+	defer close(dS.loopDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-dS.stopChan:
+			return
+		case <-ticker.C:
+			if err := dS.seen.Save(dS.file); err != nil {
+				slog.Error(fmt.Sprintf("(DedupSink): error saving seen IDs: %s", err.Error()))
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package sinks
+
+import (
+	"errors"
+	"testing"
+
+	assetshandler "crawler/app/pkg/assets-handler"
+	wtypes "crawler/app/pkg/crawler/workers/workers-types"
+)
+
+// failingSink is a MemorySink whose first writes fail, as many as failures.
+type failingSink struct {
+	*MemorySink
+	failures int
+}
+
+func (fS *failingSink) Write(el *wtypes.ContentElement) error {
+	if fS.failures > 0 {
+		fS.failures--
+		return errors.New("write failed")
+	}
+	return fS.MemorySink.Write(el)
+}
+
+func TestDedupSinkDeliversItemAfterFailedWrite(t *testing.T) {
+	sink := &failingSink{MemorySink: NewMemorySink(), failures: 1}
+	outcome := &wtypes.Outcome{}
+	dS, err := NewDedupSink(sink, &assetshandler.DedupCfg{Enabled: true}, outcome)
+	if err != nil {
+		t.Fatalf("unexpected error creating the dedup sink: %v", err)
+	}
+	defer dS.Close()
+
+	el := &wtypes.ContentElement{ContentID: 1, Content: map[string]interface{}{"id": 1}}
+	if err := dS.Write(el); err == nil {
+		t.Fatal("expected the first write to fail")
+	}
+	if err := dS.Write(el); err != nil {
+		t.Fatalf("expected the re-emitted item to be written, got %v", err)
+	}
+
+	if elements := sink.Elements(); len(elements) != 1 || elements[0].ContentID != 1 {
+		t.Fatalf("expected the item to be delivered once, got %d elements", len(elements))
+	}
+	if outcome.Duplicates != 0 {
+		t.Errorf("expected no duplicates, got %d", outcome.Duplicates)
+	}
+}
+
+func TestDedupSinkDropsDuplicates(t *testing.T) {
+	sink := NewMemorySink()
+	outcome := &wtypes.Outcome{}
+	dS, err := NewDedupSink(sink, &assetshandler.DedupCfg{Enabled: true}, outcome)
+	if err != nil {
+		t.Fatalf("unexpected error creating the dedup sink: %v", err)
+	}
+	defer dS.Close()
+
+	for _, id := range []int{1, 2, 1, 3, 2} {
+		if err := dS.Write(&wtypes.ContentElement{ContentID: id}); err != nil {
+			t.Fatalf("unexpected error writing item %d: %v", id, err)
+		}
+	}
+
+	if elements := sink.Elements(); len(elements) != 3 {
+		t.Errorf("expected 3 elements delivered, got %d", len(elements))
+	}
+	if outcome.Duplicates != 2 {
+		t.Errorf("expected 2 duplicates, got %d", outcome.Duplicates)
+	}
+}
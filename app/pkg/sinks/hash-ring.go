@@ -0,0 +1,77 @@
+package sinks
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// hashRing is a consistent hash ring of endpoints.
+//
+// Each endpoint is placed on the ring multiple times (virtual nodes) so that
+// the keys are evenly distributed, and a key belongs to the first endpoint
+// found walking the ring clockwise from the hash of the key.
+// Adding or removing an endpoint only moves the keys of its neighbours.
+type hashRing struct {
+	nodes []hashRingNode
+
+	endpointsAmount int
+}
+
+type hashRingNode struct {
+	hash     uint64
+	endpoint int
+}
+
+// newHashRing creates a ring of the given keys (e.g. the endpoint urls), identified
+// by their index. The same keys always produce the same ring, regardless of their order.
+func newHashRing(keys []string, virtualNodes int) *hashRing {
+	virtualNodes = max(virtualNodes, 1)
+
+	hr := &hashRing{
+		nodes:           make([]hashRingNode, 0, len(keys)*virtualNodes),
+		endpointsAmount: len(keys),
+	}
+	for endpointIdx, key := range keys {
+		for vnode := range virtualNodes {
+			hr.nodes = append(hr.nodes, hashRingNode{
+				hash:     hashKey(key + "#" + strconv.Itoa(vnode)),
+				endpoint: endpointIdx,
+			})
+		}
+	}
+	sort.Slice(hr.nodes, func(i, j int) bool {
+		if hr.nodes[i].hash == hr.nodes[j].hash {
+			return keys[hr.nodes[i].endpoint] < keys[hr.nodes[j].endpoint]
+		}
+		return hr.nodes[i].hash < hr.nodes[j].hash
+	})
+
+	return hr
+}
+
+// lookup returns all the endpoints in the order they are found walking the ring
+// from the key: the first one owns the key, the following ones are its fallbacks.
+func (hr *hashRing) lookup(key string) []int {
+	hash := hashKey(key)
+	start := sort.Search(len(hr.nodes), func(i int) bool { return hr.nodes[i].hash >= hash })
+
+	endpoints := make([]int, 0, hr.endpointsAmount)
+	seen := make([]bool, hr.endpointsAmount)
+	for offset := 0; offset < len(hr.nodes) && len(endpoints) < hr.endpointsAmount; offset++ {
+		node := hr.nodes[(start+offset)%len(hr.nodes)]
+		if !seen[node.endpoint] {
+			seen[node.endpoint] = true
+			endpoints = append(endpoints, node.endpoint)
+		}
+	}
+	return endpoints
+}
+
+// hashKey uses md5 (as ketama does) for its good distribution over similar keys,
+// e.g. "url#1" and "url#2", and its stability across restarts.
+func hashKey(key string) uint64 {
+	sum := md5.Sum([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
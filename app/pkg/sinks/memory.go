@@ -0,0 +1,66 @@
+package sinks
+
+import (
+	"errors"
+	"sync"
+
+	wtypes "crawler/app/pkg/crawler/workers/workers-types"
+)
+
+// MemorySink keeps all the written elements in memory.
+// It is meant to be used in tests and for debugging purposes.
+type MemorySink struct {
+	elements []*wtypes.ContentElement
+	closed   bool
+	mu       sync.Mutex
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+func (ms *MemorySink) Name() string {
+	return "memory"
+}
+
+func (ms *MemorySink) Write(el *wtypes.ContentElement) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.closed {
+		return errors.New("write on closed sink")
+	}
+	ms.elements = append(ms.elements, el)
+	return nil
+}
+
+func (ms *MemorySink) Flush() error {
+	return nil
+}
+
+func (ms *MemorySink) Close() error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.closed = true
+	return nil
+}
+
+func (ms *MemorySink) Health() error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.closed {
+		return errors.New("sink is closed")
+	}
+	return nil
+}
+
+// Elements returns a copy of all the elements written so far, in write order.
+func (ms *MemorySink) Elements() []*wtypes.ContentElement {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	elements := make([]*wtypes.ContentElement, len(ms.elements))
+	copy(elements, ms.elements)
+	return elements
+}
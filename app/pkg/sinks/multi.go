@@ -0,0 +1,57 @@
+package sinks
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	wtypes "crawler/app/pkg/crawler/workers/workers-types"
+)
+
+// MultiSink fans out every element to all of its sinks.
+// A failure of one sink does not prevent the delivery to the others.
+type MultiSink struct {
+	sinks []Sink
+}
+
+func NewMultiSink(sinks ...Sink) *MultiSink {
+	return &MultiSink{sinks: sinks}
+}
+
+func (ms *MultiSink) Name() string {
+	names := make([]string, len(ms.sinks))
+	for idx, sink := range ms.sinks {
+		names[idx] = sink.Name()
+	}
+	return "multi(" + strings.Join(names, ",") + ")"
+}
+
+func (ms *MultiSink) Write(el *wtypes.ContentElement) error {
+	return ms.forEach(func(sink Sink) error { return sink.Write(el) })
+}
+
+func (ms *MultiSink) Flush() error {
+	return ms.forEach(Sink.Flush)
+}
+
+func (ms *MultiSink) Close() error {
+	return ms.forEach(Sink.Close)
+}
+
+func (ms *MultiSink) Health() error {
+	return ms.forEach(Sink.Health)
+}
+
+func (ms *MultiSink) Unwrap() []Sink {
+	return ms.sinks
+}
+
+func (ms *MultiSink) forEach(fn func(sink Sink) error) error {
+	var errs []error
+	for _, sink := range ms.sinks {
+		if err := fn(sink); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sink.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,327 @@
+package sinks
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	assetshandler "crawler/app/pkg/assets-handler"
+	wtypes "crawler/app/pkg/crawler/workers/workers-types"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// NdjsonSink appends each element as a JSON line (see itemRecord) to a local file.
+//
+// The active file (<dir>/<prefix>.ndjson) is rotated when it exceeds the max size
+// or when it has been open for longer than the rotate interval.
+// Rotated segments are renamed to <prefix>-<rotation time>.ndjson and, if a
+// compression is configured, compressed in background (the uncompressed
+// segment is removed once the compression succeeds).
+type NdjsonSink struct {
+	dir            string
+	prefix         string
+	maxSize        int64
+	rotateInterval time.Duration
+	compression    string
+
+	file     *os.File
+	writer   *bufio.Writer
+	size     int64
+	openedAt time.Time
+	lastErr  error
+	closed   bool
+	mu       sync.Mutex
+
+	stopChan     chan struct{}
+	loopDone     chan struct{}
+	compressions sync.WaitGroup
+}
+
+func NewNdjsonSink(cfg *assetshandler.NdjsonSinkCfg) (*NdjsonSink, error) {
+	if err := checkError(func(report assetshandler.ReportFunc) { checkNdjsonCfg(cfg, "", report) }); err != nil {
+		return nil, err
+	}
+
+	compression := cfg.Compression
+	if compression == "" {
+		compression = CompressionNone
+	}
+
+	prefix := cfg.FilePrefix
+	if prefix == "" {
+		prefix = "items"
+	}
+
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create the ndjson sink directory: %w", err)
+	}
+
+	ndS := &NdjsonSink{
+		dir:            cfg.Dir,
+		prefix:         prefix,
+		maxSize:        int64(max(cfg.MaxSizeMB, 0)) * 1024 * 1024,
+		rotateInterval: (time.Duration)(max(cfg.RotateIntervalSeconds, 0)) * time.Second,
+		compression:    compression,
+		stopChan:       make(chan struct{}),
+		loopDone:       make(chan struct{}),
+	}
+
+	if err := ndS.openActiveFile(); err != nil {
+		return nil, err
+	}
+
+	go ndS.flushLoop()
+
+	return ndS, nil
+}
+
+func (ndS *NdjsonSink) Name() string {
+	return "ndjson"
+}
+
+func (ndS *NdjsonSink) Write(el *wtypes.ContentElement) error {
+	line, err := json.Marshal(newItemRecord(el))
+	if err != nil {
+		return fmt.Errorf("error marshalling item (ID %d) to json: %w", el.ContentID, err)
+	}
+	line = append(line, '\n')
+
+	ndS.mu.Lock()
+	defer ndS.mu.Unlock()
+
+	if ndS.closed {
+		return errors.New("write on closed sink")
+	}
+
+	if ndS.maxSize > 0 && ndS.size > 0 && ndS.size+int64(len(line)) > ndS.maxSize {
+		if err := ndS.rotate(); err != nil {
+			ndS.lastErr = err
+			return err
+		}
+	}
+
+	n, err := ndS.writer.Write(line)
+	ndS.size += int64(n)
+	if err != nil {
+		ndS.lastErr = fmt.Errorf("error writing item (ID %d) to file: %w", el.ContentID, err)
+		return ndS.lastErr
+	}
+
+	ndS.lastErr = nil
+	return nil
+}
+
+func (ndS *NdjsonSink) Flush() error {
+	ndS.mu.Lock()
+	defer ndS.mu.Unlock()
+
+	if ndS.closed {
+		return nil
+	}
+	return ndS.flush()
+}
+
+// Close flushes and closes the active file and waits for the pending
+// compressions to complete. The active file is not rotated.
+func (ndS *NdjsonSink) Close() error {
+	ndS.mu.Lock()
+	if ndS.closed {
+		ndS.mu.Unlock()
+		return nil
+	}
+	ndS.closed = true
+	close(ndS.stopChan)
+	err := errors.Join(ndS.flush(), ndS.file.Close())
+	ndS.mu.Unlock()
+
+	<-ndS.loopDone
+	ndS.compressions.Wait()
+
+	return err
+}
+
+func (ndS *NdjsonSink) Health() error {
+	ndS.mu.Lock()
+	defer ndS.mu.Unlock()
+
+	if ndS.closed {
+		return errors.New("sink is closed")
+	}
+	return ndS.lastErr
+}
+
+// flushLoop periodically flushes the buffered lines and rotates the active
+// file if it has been open for longer than the rotate interval.
+func (ndS *NdjsonSink) flushLoop() {
+	defer close(ndS.loopDone)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ndS.stopChan:
+			return
+		case <-ticker.C:
+			ndS.mu.Lock()
+			if ndS.closed {
+				ndS.mu.Unlock()
+				return
+			}
+
+			err := ndS.flush()
+			if err == nil && ndS.rotateInterval > 0 && ndS.size > 0 &&
+				time.Since(ndS.openedAt) >= ndS.rotateInterval {
+				err = ndS.rotate()
+			}
+			ndS.lastErr = err
+			ndS.mu.Unlock()
+
+			if err != nil {
+				slog.Error(fmt.Sprintf("(NdjsonSink): %s", err.Error()))
+			}
+		}
+	}
+}
+
+// must be called with ndS.mu held.
+func (ndS *NdjsonSink) flush() error {
+	if err := ndS.writer.Flush(); err != nil {
+		return fmt.Errorf("error flushing active file: %w", err)
+	}
+	return nil
+}
+
+func (ndS *NdjsonSink) activeFilePath() string {
+	return filepath.Join(ndS.dir, ndS.prefix+".ndjson")
+}
+
+// must be called with ndS.mu held (or before the sink is shared).
+func (ndS *NdjsonSink) openActiveFile() error {
+	file, err := os.OpenFile(ndS.activeFilePath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("could not open active file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("could not stat active file: %w", err)
+	}
+
+	ndS.file = file
+	ndS.writer = bufio.NewWriterSize(file, 64*1024)
+	ndS.size = info.Size()
+	ndS.openedAt = time.Now()
+	return nil
+}
+
+// must be called with ndS.mu held.
+func (ndS *NdjsonSink) rotate() error {
+	if err := ndS.flush(); err != nil {
+		return err
+	}
+	if err := ndS.file.Close(); err != nil {
+		return fmt.Errorf("error closing active file: %w", err)
+	}
+
+	segmentPath := filepath.Join(
+		ndS.dir,
+		fmt.Sprintf("%s-%s.ndjson", ndS.prefix, time.Now().UTC().Format("20060102T150405.000000000")),
+	)
+	renameErr := os.Rename(ndS.activeFilePath(), segmentPath)
+
+	// the active file must be reopened even if the rename failed,
+	// otherwise all the following writes would fail
+	if err := ndS.openActiveFile(); err != nil {
+		return errors.Join(renameErr, err)
+	}
+	if renameErr != nil {
+		return fmt.Errorf("error renaming active file: %w", renameErr)
+	}
+
+	if ndS.compression != CompressionNone {
+		ndS.compressions.Add(1)
+		go func() {
+			defer ndS.compressions.Done()
+			if err := compressSegment(segmentPath, ndS.compression); err != nil {
+				slog.Error(fmt.Sprintf("(NdjsonSink): error compressing segment %s: %s", segmentPath, err.Error()))
+			}
+		}()
+	}
+
+	return nil
+}
+
+// compressSegment compresses the file at path into path + .gz / .zst and removes it.
+// If the compression fails the original file is kept.
+func compressSegment(path string, compression string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	var dstPath string
+	switch compression {
+	case CompressionGzip:
+		dstPath = path + ".gz"
+	case CompressionZstd:
+		dstPath = path + ".zst"
+	default:
+		return fmt.Errorf("unknown compression %q", compression)
+	}
+
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	compressed := false
+	defer func() {
+		if !compressed {
+			os.Remove(dstPath)
+		}
+	}()
+
+	var compressor io.WriteCloser
+	if compression == CompressionGzip {
+		compressor = gzip.NewWriter(dst)
+	} else {
+		compressor, err = zstd.NewWriter(dst)
+		if err != nil {
+			dst.Close()
+			return err
+		}
+	}
+
+	if _, err = io.Copy(compressor, src); err != nil {
+		compressor.Close()
+		dst.Close()
+		return err
+	}
+	if err = compressor.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+	compressed = true
+
+	return os.Remove(path)
+}
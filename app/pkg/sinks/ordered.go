@@ -0,0 +1,306 @@
+package sinks
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	assetshandler "crawler/app/pkg/assets-handler"
+	wtypes "crawler/app/pkg/crawler/workers/workers-types"
+)
+
+// OrderedSink reorders the elements by ID before writing them to the wrapped sink.
+//
+// The elements are held in a bounded window and the one with the lowest ID is released when:
+//   - its ID directly follows the last released one;
+//   - an element of the window has been waiting for more than the max wait
+//     (e.g. a lower ID is missing because it is being retried by the backup workers,
+//     or because it does not exist at all);
+//   - the window is full.
+//
+// Elements arriving after a higher ID has already been released cannot be
+// reordered anymore: they are written immediately and reported in outcome as late.
+//
+// The released elements are written to the wrapped sink without holding the lock
+// of the window, so that a slow sink does not block the writes and the release loop.
+type OrderedSink struct {
+	sink    Sink
+	outcome *wtypes.Outcome
+
+	windowSize int
+	maxWait    time.Duration
+
+	// the held elements, as a min heap by ID
+	pending orderedHeap
+	// the elements held or released and not written yet, readable without the lock
+	held atomic.Int64
+	// the held elements in arrival order, used to find the one waiting the longest.
+	// released entries are removed lazily.
+	arrivals []*orderedEntry
+
+	lastID   int
+	released bool
+
+	// the released elements waiting to be written to the wrapped sink, in order
+	outbox []*wtypes.ContentElement
+	// true while a goroutine is writing the outbox, only one does at a time
+	writing bool
+	// signaled when the outbox has been written
+	written *sync.Cond
+
+	stopChan chan struct{}
+	loopDone chan struct{}
+
+	closed bool
+	mu     sync.Mutex
+}
+
+type orderedEntry struct {
+	el        *wtypes.ContentElement
+	arrivedAt time.Time
+	released  bool
+}
+
+func NewOrderedSink(
+	sink Sink,
+	cfg *assetshandler.OrderedDeliveryCfg,
+	outcome *wtypes.Outcome,
+) *OrderedSink {
+	windowSize := cfg.WindowSize
+	if windowSize <= 0 {
+		windowSize = 1000
+	}
+	maxWaitMilli := cfg.MaxWaitMilli
+	if maxWaitMilli <= 0 {
+		maxWaitMilli = 5000
+	}
+
+	oS := &OrderedSink{
+		sink:       sink,
+		outcome:    outcome,
+		windowSize: windowSize,
+		maxWait:    (time.Duration)(maxWaitMilli) * time.Millisecond,
+		stopChan:   make(chan struct{}),
+		loopDone:   make(chan struct{}),
+	}
+	oS.written = sync.NewCond(&oS.mu)
+
+	go oS.releaseLoop()
+
+	return oS
+}
+
+func (oS *OrderedSink) Name() string {
+	return "ordered(" + oS.sink.Name() + ")"
+}
+
+// Write adds the element to the window and writes the elements that are
+// ready to the wrapped sink, returning their write errors. If another goroutine
+// is already writing, the ready elements are left to it and nil is returned.
+func (oS *OrderedSink) Write(el *wtypes.ContentElement) error {
+	oS.mu.Lock()
+	if oS.closed {
+		oS.mu.Unlock()
+		return errors.New("write on closed sink")
+	}
+
+	oS.held.Add(1)
+	if oS.released && el.ContentID <= oS.lastID {
+		oS.outcome.Update(func(o *wtypes.Outcome) { o.LateItems++ })
+		oS.outbox = append(oS.outbox, el)
+	} else {
+		entry := &orderedEntry{el: el, arrivedAt: time.Now()}
+		heap.Push(&oS.pending, entry)
+		oS.arrivals = append(oS.arrivals, entry)
+		oS.releaseReady(time.Now())
+	}
+	oS.mu.Unlock()
+
+	return oS.writeReleased(false)
+}
+
+// Flush releases all the held elements, in order, and flushes the wrapped sink.
+func (oS *OrderedSink) Flush() error {
+	oS.mu.Lock()
+	oS.releaseAll()
+	oS.mu.Unlock()
+
+	err := oS.writeReleased(true)
+	return errors.Join(err, oS.sink.Flush())
+}
+
+// Close releases all the held elements, in order, and closes the wrapped sink.
+func (oS *OrderedSink) Close() error {
+	oS.mu.Lock()
+	if oS.closed {
+		oS.mu.Unlock()
+		return nil
+	}
+	oS.closed = true
+	close(oS.stopChan)
+	oS.mu.Unlock()
+
+	<-oS.loopDone
+
+	oS.mu.Lock()
+	oS.releaseAll()
+	oS.mu.Unlock()
+
+	err := oS.writeReleased(true)
+	return errors.Join(err, oS.sink.Close())
+}
+
+func (oS *OrderedSink) Health() error {
+	return oS.sink.Health()
+}
+
+// Buffered returns the amount of elements held in the window or waiting to be written.
+func (oS *OrderedSink) Buffered() int {
+	return int(oS.held.Load())
+}
+
+// Unwrap returns the sink the elements are released to.
+func (oS *OrderedSink) Unwrap() []Sink {
+	return []Sink{oS.sink}
+}
+
+// releaseLoop periodically releases the elements that have been waiting for too long,
+// as no Write may happen for a while.
+func (oS *OrderedSink) releaseLoop() {
+	defer close(oS.loopDone)
+
+	ticker := time.NewTicker(max(oS.maxWait/10, 10*time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-oS.stopChan:
+			return
+		case now := <-ticker.C:
+			oS.mu.Lock()
+			oS.releaseReady(now)
+			oS.mu.Unlock()
+
+			if err := oS.writeReleased(false); err != nil {
+				slog.Error(fmt.Sprintf("(OrderedSink): error writing to sink %s: %s", oS.sink.Name(), err.Error()))
+			}
+		}
+	}
+}
+
+// releaseReady must be called with the lock held.
+func (oS *OrderedSink) releaseReady(now time.Time) {
+	for oS.pending.Len() > 0 {
+		lowest := oS.pending[0]
+		ready := (oS.released && lowest.el.ContentID == oS.lastID+1) ||
+			oS.pending.Len() > oS.windowSize ||
+			now.Sub(oS.oldestArrival()) >= oS.maxWait
+		if !ready {
+			break
+		}
+		oS.releaseLowest()
+	}
+}
+
+// releaseAll must be called with the lock held.
+func (oS *OrderedSink) releaseAll() {
+	for oS.pending.Len() > 0 {
+		oS.releaseLowest()
+	}
+}
+
+// releaseLowest moves the lowest held element to the outbox.
+// It must be called with the lock held.
+func (oS *OrderedSink) releaseLowest() {
+	entry := heap.Pop(&oS.pending).(*orderedEntry)
+	entry.released = true
+	oS.lastID = entry.el.ContentID
+	oS.released = true
+	oS.outbox = append(oS.outbox, entry.el)
+}
+
+// writeReleased writes the outbox to the wrapped sink, in order, without holding
+// the lock, and returns the write errors. If another goroutine is already writing,
+// it returns nil at once or, if wait is true, once the outbox has been written.
+func (oS *OrderedSink) writeReleased(wait bool) error {
+	oS.mu.Lock()
+	for oS.writing {
+		if !wait {
+			oS.mu.Unlock()
+			return nil
+		}
+		oS.written.Wait()
+	}
+	oS.writing = true
+
+	// a panicking sink must not leave the outbox locked for the other goroutines
+	defer func() {
+		oS.writing = false
+		oS.written.Broadcast()
+		oS.mu.Unlock()
+	}()
+
+	var errs []error
+	for len(oS.outbox) > 0 {
+		batch := oS.outbox
+		oS.outbox = nil
+		oS.mu.Unlock()
+		errs = append(errs, oS.writeBatch(batch)...)
+		oS.mu.Lock()
+	}
+	return errors.Join(errs...)
+}
+
+// writeBatch must be called without the lock held. If a write panics,
+// the lock is taken back before the panic goes on.
+func (oS *OrderedSink) writeBatch(batch []*wtypes.ContentElement) (errs []error) {
+	defer func() {
+		if r := recover(); r != nil {
+			oS.mu.Lock()
+			panic(r)
+		}
+	}()
+
+	for _, el := range batch {
+		if err := oS.sink.Write(el); err != nil {
+			errs = append(errs, fmt.Errorf("item (ID %d): %w", el.ContentID, err))
+		}
+		oS.held.Add(-1)
+	}
+	return errs
+}
+
+// oldestArrival returns the arrival time of the element waiting the longest.
+// There must be at least one held element.
+func (oS *OrderedSink) oldestArrival() time.Time {
+	idx := 0
+	for oS.arrivals[idx].released {
+		oS.arrivals[idx] = nil
+		idx++
+	}
+	oS.arrivals = oS.arrivals[idx:]
+	return oS.arrivals[0].arrivedAt
+}
+
+// orderedHeap implements heap.Interface, ordering the entries by content ID.
+type orderedHeap []*orderedEntry
+
+func (oh orderedHeap) Len() int           { return len(oh) }
+func (oh orderedHeap) Less(i, j int) bool { return oh[i].el.ContentID < oh[j].el.ContentID }
+func (oh orderedHeap) Swap(i, j int)      { oh[i], oh[j] = oh[j], oh[i] }
+
+func (oh *orderedHeap) Push(x any) {
+	*oh = append(*oh, x.(*orderedEntry))
+}
+
+func (oh *orderedHeap) Pop() any {
+	old := *oh
+	last := old[len(old)-1]
+	old[len(old)-1] = nil
+	*oh = old[:len(old)-1]
+	return last
+}
@@ -0,0 +1,27 @@
+package sinks
+
+import (
+	"time"
+
+	wtypes "crawler/app/pkg/crawler/workers/workers-types"
+)
+
+// itemRecord is the JSON representation of an element used by the sinks
+// that deliver the item along with its metadata.
+type itemRecord struct {
+	ItemID       int                    `json:"item_id"`
+	BatchID      uint16                 `json:"batch_id"`
+	FetchDelayMs uint32                 `json:"fetch_delay_ms"`
+	FetchedAt    time.Time              `json:"fetched_at"`
+	Item         map[string]interface{} `json:"item"`
+}
+
+func newItemRecord(el *wtypes.ContentElement) *itemRecord {
+	return &itemRecord{
+		ItemID:       el.ContentID,
+		BatchID:      el.BatchID,
+		FetchDelayMs: el.Delay,
+		FetchedAt:    el.FetchedAt,
+		Item:         el.Content,
+	}
+}
@@ -0,0 +1,66 @@
+package sinks
+
+import "sync"
+
+// replayBuffer is a bounded FIFO queue of messages that could not be delivered.
+// When the buffer is full, the oldest message is dropped to make room for the new one.
+type replayBuffer struct {
+	messages []*wsMessage
+	capacity int
+	mu       sync.Mutex
+}
+
+func newReplayBuffer(capacity int) *replayBuffer {
+	return &replayBuffer{capacity: capacity}
+}
+
+// push appends a message to the buffer and returns the dropped one, if any.
+func (rb *replayBuffer) push(msg *wsMessage) (dropped *wsMessage) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if rb.capacity <= 0 {
+		return msg
+	}
+
+	if len(rb.messages) >= rb.capacity {
+		dropped = rb.messages[0]
+		rb.messages[0] = nil
+		rb.messages = rb.messages[1:]
+	}
+	rb.messages = append(rb.messages, msg)
+	return dropped
+}
+
+// pushFront puts back a message that has been popped but could not be delivered,
+// so that it is the next one to be replayed.
+// If the buffer is full, the message is dropped instead.
+func (rb *replayBuffer) pushFront(msg *wsMessage) (dropped bool) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if len(rb.messages) >= rb.capacity {
+		return true
+	}
+	rb.messages = append([]*wsMessage{msg}, rb.messages...)
+	return false
+}
+
+func (rb *replayBuffer) pop() (msg *wsMessage, ok bool) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if len(rb.messages) == 0 {
+		return nil, false
+	}
+	msg = rb.messages[0]
+	rb.messages[0] = nil
+	rb.messages = rb.messages[1:]
+	return msg, true
+}
+
+func (rb *replayBuffer) len() int {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return len(rb.messages)
+}
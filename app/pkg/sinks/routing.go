@@ -0,0 +1,104 @@
+package sinks
+
+import (
+	"strconv"
+	"strings"
+
+	wtypes "crawler/app/pkg/crawler/workers/workers-types"
+)
+
+const (
+	RoutingRoundRobin = "round_robin"
+	RoutingHashID     = "hash_id"
+	RoutingHashField  = "hash_field"
+)
+
+// wsRouter selects the endpoints an element is sent to.
+type wsRouter struct {
+	// nil with the round robin routing
+	ring *hashRing
+
+	// the path of the routed field with the hash_field routing,
+	// e.g. ["seller", "id"] for "seller.id"
+	fieldPath []string
+
+	// if true, an element is only sent to the endpoint owning its key
+	strict bool
+}
+
+// newWsRouter returns the router of the routing mode, which must have been
+// checked (see checkWebsocketCfg).
+func newWsRouter(mode, field string, virtualNodes int, strict bool, urls []string) *wsRouter {
+	if mode == "" || mode == RoutingRoundRobin {
+		return &wsRouter{}
+	}
+
+	if virtualNodes <= 0 {
+		virtualNodes = 100
+	}
+
+	router := &wsRouter{
+		ring:   newHashRing(urls, virtualNodes),
+		strict: strict,
+	}
+	if mode == RoutingHashField {
+		router.fieldPath = strings.Split(field, ".")
+	}
+	return router
+}
+
+// routingKey returns the key the element is routed by.
+// It is empty with the round robin routing.
+// If the routed field is missing, the item ID is used as key.
+func (r *wsRouter) routingKey(el *wtypes.ContentElement) string {
+	if r.ring == nil {
+		return ""
+	}
+	if r.fieldPath != nil {
+		if key, ok := lookupField(el.Content, r.fieldPath); ok {
+			return key
+		}
+	}
+	return strconv.Itoa(el.ContentID)
+}
+
+// endpoints returns the indexes of the endpoints to try for the key, in order.
+// It returns nil with the round robin routing.
+func (r *wsRouter) endpoints(key string) []int {
+	if r.ring == nil {
+		return nil
+	}
+	endpoints := r.ring.lookup(key)
+	if r.strict {
+		return endpoints[:1]
+	}
+	return endpoints
+}
+
+// lookupField returns the value at path in content, formatted as a string.
+// Only scalar values can be looked up.
+func lookupField(content map[string]interface{}, path []string) (string, bool) {
+	var value interface{} = content
+	for _, key := range path {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if value, ok = obj[key]; !ok {
+			return "", false
+		}
+	}
+
+	switch typedValue := value.(type) {
+	case string:
+		return typedValue, true
+	case float64:
+		return strconv.FormatFloat(typedValue, 'f', -1, 64), true
+	case int:
+		return strconv.Itoa(typedValue), true
+	case bool:
+		return strconv.FormatBool(typedValue), true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,76 @@
+package sinks
+
+import (
+	"net/http"
+
+	wtypes "crawler/app/pkg/crawler/workers/workers-types"
+)
+
+// Sink is a destination for the crawled items.
+//
+// Implementations must be safe for concurrent use.
+type Sink interface {
+	// Name returns a short human readable identifier of the sink, used for logging.
+	Name() string
+
+	// Write delivers (or enqueues, for buffered sinks) a single element.
+	Write(el *wtypes.ContentElement) error
+
+	// Flush forces the delivery of the buffered elements, if any.
+	Flush() error
+
+	// Close flushes the sink and releases all its resources.
+	// The sink must not be used after Close has been called.
+	Close() error
+
+	// Health returns nil if the sink is currently able to deliver elements,
+	// otherwise an error describing why it is not.
+	Health() error
+}
+
+// wrapper is implemented by the sinks that deliver to other sinks,
+// so that the sinks they wrap can be reached (see SetWebsocketHeaders).
+type wrapper interface {
+	Unwrap() []Sink
+}
+
+// buffered is implemented by the sinks that hold the elements written to them
+// before delivering them.
+type buffered interface {
+	// Buffered returns the amount of elements written and not delivered yet.
+	// It must not block, even if the sink is stuck delivering.
+	Buffered() int
+}
+
+// Buffered returns the amount of elements held by the sinks in the sink tree of sink,
+// written and not delivered yet (e.g. to count them as lost if the sink cannot be closed).
+func Buffered(sink Sink) int {
+	var amount int
+	if s, ok := sink.(buffered); ok {
+		amount += s.Buffered()
+	}
+	if s, ok := sink.(wrapper); ok {
+		for _, wrapped := range s.Unwrap() {
+			amount += Buffered(wrapped)
+		}
+	}
+	return amount
+}
+
+// SetWebsocketHeaders changes the connection headers of all the websocket sinks
+// in the sink tree of sink. It returns the amount of websocket sinks updated.
+func SetWebsocketHeaders(sink Sink, headers http.Header) int {
+	switch s := sink.(type) {
+	case *WebsocketSink:
+		s.SetHeaders(headers)
+		return 1
+	case wrapper:
+		var updated int
+		for _, wrapped := range s.Unwrap() {
+			updated += SetWebsocketHeaders(wrapped, headers)
+		}
+		return updated
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,422 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	assetshandler "crawler/app/pkg/assets-handler"
+	wtypes "crawler/app/pkg/crawler/workers/workers-types"
+	"crawler/app/pkg/utils/mapx"
+)
+
+// WebhookSink POSTs the elements, in batches encoded as JSON arrays of records
+// (see itemRecord), to every configured endpoint.
+//
+// A batch is sent as soon as it reaches the batch size or when the linger time
+// has passed since its first element has been written.
+// Each endpoint receives every batch and has its own concurrency limit.
+// Failed requests (network errors, 429s and 5xx) are retried with an
+// exponential backoff, while other status codes are considered permanent failures.
+// The items of a batch that could not be delivered to an endpoint are labeled as lost.
+type WebhookSink struct {
+	endpoints []*webhookEndpoint
+
+	batchSize int
+	linger    time.Duration
+
+	// Write only enqueues the elements, which are batched by batchLoop.
+	queue     chan *wtypes.ContentElement
+	flushChan chan chan struct{}
+	// closingChan is closed as soon as Close is called, to unblock the writes
+	// waiting for the queue and the retries waiting for their backoff.
+	closingChan chan struct{}
+	stopChan    chan struct{}
+	loopDone    chan struct{}
+
+	// the elements written and not delivered to every endpoint yet
+	buffered atomic.Int64
+
+	// used to count the items of the batches that could not be delivered
+	outcome *wtypes.Outcome
+
+	closed    bool
+	closeOnce sync.Once
+	mu        sync.RWMutex
+}
+
+type webhookEndpoint struct {
+	url     string
+	headers map[string][]string
+	client  *http.Client
+
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	// a buffered channel used as a semaphore to limit the concurrent requests
+	slots    chan struct{}
+	inFlight sync.WaitGroup
+
+	// the error of the last batch that could not be delivered, reset on success
+	lastErr error
+	mu      sync.Mutex
+
+	// closed along with the closingChan of the sink
+	closingChan <-chan struct{}
+
+	rand   *rand.Rand
+	randMu sync.Mutex
+}
+
+func NewWebhookSink(cfg *assetshandler.WebhookSinkCfg, outcome *wtypes.Outcome) (*WebhookSink, error) {
+	if err := checkError(func(report assetshandler.ReportFunc) { checkWebhookCfg(cfg, "", report) }); err != nil {
+		return nil, err
+	}
+
+	batchSize := cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = batchSize * 10
+	}
+	concurrency := cfg.ConcurrencyPerEndpoint
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	timeout := cfg.TimeoutSeconds
+	if timeout <= 0 {
+		timeout = 10
+	}
+
+	headers := mapx.StringToStringsList(cfg.Headers)
+	closingChan := make(chan struct{})
+
+	endpoints := make([]*webhookEndpoint, len(cfg.Urls))
+	for idx, url := range cfg.Urls {
+		endpoints[idx] = &webhookEndpoint{
+			url:            url,
+			headers:        headers,
+			client:         &http.Client{Timeout: (time.Duration)(timeout) * time.Second},
+			maxRetries:     max(cfg.MaxRetries, 0),
+			initialBackoff: (time.Duration)(max(cfg.InitialBackoffMilli, 1)) * time.Millisecond,
+			maxBackoff:     (time.Duration)(max(cfg.MaxBackoffMilli, cfg.InitialBackoffMilli, 1)) * time.Millisecond,
+			slots:          make(chan struct{}, concurrency),
+			closingChan:    closingChan,
+			rand:           rand.New(rand.NewSource(time.Now().UnixNano())),
+		}
+	}
+
+	whS := &WebhookSink{
+		endpoints:   endpoints,
+		batchSize:   batchSize,
+		linger:      (time.Duration)(max(cfg.LingerMilli, 0)) * time.Millisecond,
+		queue:       make(chan *wtypes.ContentElement, queueSize),
+		flushChan:   make(chan chan struct{}),
+		closingChan: closingChan,
+		stopChan:    make(chan struct{}),
+		loopDone:    make(chan struct{}),
+		outcome:     outcome,
+	}
+
+	go whS.batchLoop()
+
+	return whS, nil
+}
+
+func (whS *WebhookSink) Name() string {
+	return "webhook"
+}
+
+// Write enqueues the element. If the queue is full it blocks until there is room,
+// so that slow endpoints apply backpressure to the workers, or until the sink is closed.
+func (whS *WebhookSink) Write(el *wtypes.ContentElement) error {
+	whS.mu.RLock()
+	defer whS.mu.RUnlock()
+
+	if whS.closed {
+		return errors.New("write on closed sink")
+	}
+
+	select {
+	case whS.queue <- el:
+		whS.buffered.Add(1)
+		return nil
+	case <-whS.closingChan:
+		return fmt.Errorf("sink closed while the queue was full, item (ID %d) dropped", el.ContentID)
+	}
+}
+
+// Flush sends the current batch and waits for all the in flight requests to complete.
+func (whS *WebhookSink) Flush() error {
+	whS.mu.RLock()
+	if whS.closed {
+		whS.mu.RUnlock()
+		return nil
+	}
+	done := make(chan struct{})
+	whS.flushChan <- done
+	whS.mu.RUnlock()
+
+	<-done
+	return whS.Health()
+}
+
+// Close sends all the queued elements and waits for all the in flight requests to complete.
+// The failed requests are not retried anymore.
+func (whS *WebhookSink) Close() error {
+	whS.closeOnce.Do(func() { close(whS.closingChan) })
+
+	// waits for the blocked writes, which return on closingChan
+	whS.mu.Lock()
+	if whS.closed {
+		whS.mu.Unlock()
+		return nil
+	}
+	whS.closed = true
+	close(whS.stopChan)
+	whS.mu.Unlock()
+
+	<-whS.loopDone
+	return whS.Health()
+}
+
+// Buffered returns the amount of elements queued, batched or being sent.
+func (whS *WebhookSink) Buffered() int {
+	return int(whS.buffered.Load())
+}
+
+func (whS *WebhookSink) Health() error {
+	var errs []error
+	for _, endpoint := range whS.endpoints {
+		endpoint.mu.Lock()
+		if endpoint.lastErr != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", endpoint.url, endpoint.lastErr))
+		}
+		endpoint.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+func (whS *WebhookSink) batchLoop() {
+	defer close(whS.loopDone)
+
+	batch := make([]*wtypes.ContentElement, 0, whS.batchSize)
+
+	// the linger timer is only armed while the batch is not empty
+	lingerTimer := time.NewTimer(whS.linger)
+	lingerTimer.Stop()
+
+	send := func() {
+		lingerTimer.Stop()
+		if len(batch) == 0 {
+			return
+		}
+		whS.sendBatch(batch)
+		batch = make([]*wtypes.ContentElement, 0, whS.batchSize)
+	}
+
+	// moves all the currently queued elements into batches
+	drainQueue := func() {
+		for {
+			select {
+			case el := <-whS.queue:
+				batch = append(batch, el)
+				if len(batch) >= whS.batchSize {
+					send()
+				}
+			default:
+				return
+			}
+		}
+	}
+
+	waitInFlight := func() {
+		for _, endpoint := range whS.endpoints {
+			endpoint.inFlight.Wait()
+		}
+	}
+
+	for {
+		select {
+		case <-whS.stopChan:
+			// no more writes can happen at this point, so the queue can be drained safely
+			drainQueue()
+			send()
+			waitInFlight()
+			return
+		case done := <-whS.flushChan:
+			drainQueue()
+			send()
+			waitInFlight()
+			close(done)
+		case <-lingerTimer.C:
+			send()
+		case el := <-whS.queue:
+			batch = append(batch, el)
+			if len(batch) >= whS.batchSize {
+				send()
+			} else if len(batch) == 1 {
+				lingerTimer.Reset(whS.linger)
+			}
+		}
+	}
+}
+
+func (whS *WebhookSink) sendBatch(batch []*wtypes.ContentElement) {
+	records := make([]*itemRecord, len(batch))
+	for idx, el := range batch {
+		records[idx] = newItemRecord(el)
+	}
+
+	body, err := json.Marshal(records)
+	if err != nil {
+		slog.Error(fmt.Sprintf(
+			"(WebhookSink): error marshalling batch of %d items (IDs %d-%d), batch dropped: %s",
+			len(batch), batch[0].ContentID, batch[len(batch)-1].ContentID, err.Error(),
+		))
+		whS.buffered.Add(-int64(len(batch)))
+		whS.addLost(len(batch))
+		return
+	}
+
+	// the batch is not buffered anymore once all the endpoints are done with it,
+	// and it is lost if any of them has not received it
+	var endpointsLeft atomic.Int32
+	var undelivered atomic.Bool
+	endpointsLeft.Store(int32(len(whS.endpoints)))
+	for _, endpoint := range whS.endpoints {
+		// blocks until a slot is available, so that slow endpoints apply backpressure
+		endpoint.slots <- struct{}{}
+		endpoint.inFlight.Add(1)
+		go func() {
+			defer func() {
+				if endpointsLeft.Add(-1) == 0 {
+					whS.buffered.Add(-int64(len(batch)))
+					if undelivered.Load() {
+						whS.addLost(len(batch))
+					}
+				}
+				<-endpoint.slots
+				endpoint.inFlight.Done()
+			}()
+			if !endpoint.deliver(body, len(batch)) {
+				undelivered.Store(true)
+			}
+		}()
+	}
+}
+
+func (whS *WebhookSink) addLost(itemsAmount int) {
+	if whS.outcome != nil {
+		whS.outcome.Update(func(o *wtypes.Outcome) { o.Lost += itemsAmount })
+	}
+}
+
+// deliver reports whether the body has been delivered to the endpoint.
+func (ep *webhookEndpoint) deliver(body []byte, itemsAmount int) bool {
+	var err error
+	for attempt := 0; attempt <= ep.maxRetries; attempt++ {
+		// the batch is not retried anymore once the sink is being closed
+		if attempt > 0 && !ep.waitBackoff(attempt) {
+			break
+		}
+
+		var retryable bool
+		retryable, err = ep.post(body)
+		if err == nil {
+			ep.mu.Lock()
+			ep.lastErr = nil
+			ep.mu.Unlock()
+			return true
+		}
+		if !retryable {
+			break
+		}
+	}
+
+	slog.Error(fmt.Sprintf(
+		"(WebhookSink): batch of %d items could not be delivered to %s: %s",
+		itemsAmount, ep.url, err.Error(),
+	))
+
+	ep.mu.Lock()
+	ep.lastErr = err
+	ep.mu.Unlock()
+	return false
+}
+
+// post sends the body once and reports whether a failure is worth a retry.
+func (ep *webhookEndpoint) post(body []byte) (retryable bool, err error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, ep.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for key, values := range ep.headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ep.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected response status code %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("unexpected response status code %d", resp.StatusCode)
+	}
+}
+
+// waitBackoff waits for the backoff of the attempt. It returns false
+// if the sink is closed meanwhile.
+func (ep *webhookEndpoint) waitBackoff(attempt int) bool {
+	timer := time.NewTimer(ep.backoff(attempt))
+	defer timer.Stop()
+
+	select {
+	case <-ep.closingChan:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// backoff returns initialBackoff * 2^(attempt-1), capped at maxBackoff,
+// with a random jitter of up to 20% to avoid synchronized retries.
+func (ep *webhookEndpoint) backoff(attempt int) time.Duration {
+	// doubling only while below half the max backoff cannot overflow
+	backoff := min(ep.initialBackoff, ep.maxBackoff)
+	for i := 1; i < attempt; i++ {
+		if backoff >= ep.maxBackoff/2 {
+			backoff = ep.maxBackoff
+			break
+		}
+		backoff *= 2
+	}
+
+	ep.randMu.Lock()
+	jitter := time.Duration(ep.rand.Int63n(int64(backoff)/5 + 1))
+	ep.randMu.Unlock()
+
+	return backoff + jitter
+}
@@ -0,0 +1,571 @@
+package sinks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	assetshandler "crawler/app/pkg/assets-handler"
+	wtypes "crawler/app/pkg/crawler/workers/workers-types"
+	safews "crawler/app/pkg/safe-ws"
+	"crawler/app/pkg/utils/mapx"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsControlWriteTimeout = 5 * time.Second
+	// a half-open connection must not block the writes forever
+	wsWriteTimeout = 10 * time.Second
+	wsDialTimeout  = 10 * time.Second
+)
+
+// WebsocketSink sends each element, encoded as JSON, to one websocket server
+// out of its set of endpoints.
+// By default the endpoint is selected sequentially in a cyclic way from the
+// healthy ones. Otherwise, with a hash routing, the endpoint is selected by
+// consistent hashing of the item ID or of an item field, so that the elements
+// with the same key always land on the same endpoint while it is healthy
+// (see wsRouter).
+//
+// Each endpoint keeps its connection alive with pings and reconnects with an
+// exponential backoff whenever the connection breaks. While an endpoint is
+// reconnecting it is excluded from the cycle.
+// The elements that cannot be delivered to any endpoint are kept in a bounded
+// replay buffer and redelivered as soon as an endpoint is connected again.
+//
+// If acks are enabled, each element is wrapped in a wsEnvelope carrying a
+// delivery ID that the consumer must acknowledge (see wsAck). The deliveries
+// not acknowledged within the timeout are retried on another endpoint (if any)
+// and given up after the max attempts.
+type WebsocketSink struct {
+	endpoints []*wsEndpoint
+
+	router *wsRouter
+
+	currentEndpointIdx int
+	idxMu              sync.Mutex
+
+	replay    *replayBuffer
+	replaying atomic.Bool
+
+	// nil if acks are disabled
+	acks *ackTracker
+
+	// used to count ack timeouts and dropped deliveries
+	outcome *wtypes.Outcome
+
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+	closed   atomic.Bool
+}
+
+// wsMessage is an encoded element ready to be written to a connection.
+type wsMessage struct {
+	contentID int
+	payload   []byte
+
+	// the key the message is routed by, empty with the round robin routing
+	routingKey string
+
+	// only used when acks are enabled
+	deliveryID uint64
+	attempts   int
+}
+
+type wsEndpoint struct {
+	url string
+	// swapped by SetHeaders, used from the next connection
+	headers atomic.Pointer[http.Header]
+	dialer  *websocket.Dialer
+
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	pingInterval   time.Duration
+	pongTimeout    time.Duration
+
+	// nil while the endpoint is reconnecting
+	conn *safews.SafeConn
+	mu   sync.Mutex
+
+	// signaled when the current connection breaks, so that maintain reconnects
+	broken chan struct{}
+}
+
+func NewWebsocketSink(cfg *assetshandler.WebsocketCfg, outcome *wtypes.Outcome) (*WebsocketSink, error) {
+	if err := checkError(func(report assetshandler.ReportFunc) { checkWebsocketCfg(cfg, "", report) }); err != nil {
+		return nil, err
+	}
+
+	initialBackoff := (time.Duration)(max(cfg.ReconnectInitialBackoffMilli, 1)) * time.Millisecond
+	maxBackoff := (time.Duration)(max(cfg.ReconnectMaxBackoffMilli, cfg.ReconnectInitialBackoffMilli, 1)) * time.Millisecond
+	headers := http.Header(mapx.StringToStringsList(cfg.WsHeaders))
+
+	dialer := &websocket.Dialer{
+		ReadBufferSize:   1024,
+		WriteBufferSize:  1024,
+		HandshakeTimeout: wsDialTimeout,
+	}
+
+	router := newWsRouter(
+		cfg.Routing.Mode, cfg.Routing.Field, cfg.Routing.VirtualNodes, cfg.Routing.Strict, cfg.WsUrls,
+	)
+
+	wsS := &WebsocketSink{
+		endpoints: make([]*wsEndpoint, len(cfg.WsUrls)),
+		router:    router,
+		replay:    newReplayBuffer(cfg.ReplayBufferSize),
+		outcome:   outcome,
+		stopChan:  make(chan struct{}),
+	}
+
+	if cfg.Acks.Enabled {
+		timeout := cfg.Acks.TimeoutSeconds
+		if timeout <= 0 {
+			timeout = 10
+		}
+		maxAttempts := cfg.Acks.MaxAttempts
+		if maxAttempts <= 0 {
+			maxAttempts = 3
+		}
+		wsS.acks = newAckTracker((time.Duration)(timeout)*time.Second, maxAttempts)
+
+		wsS.wg.Add(1)
+		go wsS.ackLoop()
+	}
+
+	for idx, wsUrl := range cfg.WsUrls {
+		wsS.endpoints[idx] = &wsEndpoint{
+			url:            wsUrl,
+			dialer:         dialer,
+			initialBackoff: initialBackoff,
+			maxBackoff:     maxBackoff,
+			pingInterval:   (time.Duration)(max(cfg.PingIntervalSeconds, 0)) * time.Second,
+			pongTimeout:    (time.Duration)(max(cfg.PongTimeoutSeconds, 0)) * time.Second,
+			broken:         make(chan struct{}, 1),
+		}
+		wsS.endpoints[idx].headers.Store(&headers)
+	}
+
+	// the first connection is made synchronously so that the sink
+	// is ready to be used as soon as it is returned
+	for _, endpoint := range wsS.endpoints {
+		conn, err := endpoint.dial()
+		if err != nil {
+			slog.Error(fmt.Sprintf(
+				"(WebsocketSink): error connecting to websocket with url %s, it will be retried in background: %s",
+				endpoint.url, err.Error(),
+			))
+		} else {
+			endpoint.setConn(conn)
+			slog.Info(fmt.Sprintf("connected to websocket with url: %s", endpoint.url))
+		}
+
+		wsS.wg.Add(1)
+		go wsS.maintain(endpoint, conn)
+	}
+
+	return wsS, nil
+}
+
+func (wsS *WebsocketSink) Name() string {
+	return "websocket"
+}
+
+// Write sends the element to the next healthy endpoint (or to the one owning its key).
+// If no endpoint is able to receive it, the element is buffered for replay.
+func (wsS *WebsocketSink) Write(el *wtypes.ContentElement) error {
+	if wsS.closed.Load() {
+		return errors.New("write on closed sink")
+	}
+
+	msg := &wsMessage{contentID: el.ContentID, routingKey: wsS.router.routingKey(el)}
+
+	var jsonContent []byte
+	var err error
+	if wsS.acks != nil {
+		msg.deliveryID = wsS.acks.nextDeliveryID()
+		jsonContent, err = json.Marshal(&wsEnvelope{DeliveryID: msg.deliveryID, Item: el.Content})
+	} else {
+		jsonContent, err = json.Marshal(el.Content)
+	}
+	if err != nil {
+		return fmt.Errorf("error marshalling item (ID %d) to json: %w", el.ContentID, err)
+	}
+	msg.payload = jsonContent
+
+	if wsS.send(msg, nil) {
+		return nil
+	}
+
+	if dropped := wsS.replay.push(msg); dropped != nil {
+		return fmt.Errorf(
+			"no healthy websocket connection and replay buffer full, item (ID %d) dropped",
+			dropped.contentID,
+		)
+	}
+	return nil
+}
+
+// Flush tries to redeliver the buffered elements.
+func (wsS *WebsocketSink) Flush() error {
+	wsS.replayBuffered()
+	if pending := wsS.replay.len(); pending > 0 {
+		return fmt.Errorf("%d items still waiting for a healthy websocket connection", pending)
+	}
+	return nil
+}
+
+// Close stops the reconnections and closes all the connections with a close frame.
+// The elements still in the replay buffer are lost.
+func (wsS *WebsocketSink) Close() error {
+	if !wsS.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	close(wsS.stopChan)
+	wsS.wg.Wait()
+
+	if pending := wsS.replay.len(); pending > 0 {
+		return fmt.Errorf("%d buffered items have not been delivered", pending)
+	}
+	return nil
+}
+
+// Buffered returns the amount of elements in the replay buffer.
+func (wsS *WebsocketSink) Buffered() int {
+	return wsS.replay.len()
+}
+
+// SetHeaders changes the headers sent when connecting to the endpoints.
+// The open connections are kept, the headers are used from the next reconnection.
+func (wsS *WebsocketSink) SetHeaders(headers http.Header) {
+	for _, endpoint := range wsS.endpoints {
+		endpoint.headers.Store(&headers)
+	}
+}
+
+func (wsS *WebsocketSink) Health() error {
+	if wsS.closed.Load() {
+		return errors.New("sink is closed")
+	}
+
+	var down []string
+	for _, endpoint := range wsS.endpoints {
+		if endpoint.currentConn() == nil {
+			down = append(down, endpoint.url)
+		}
+	}
+
+	if len(down) > 0 {
+		var unacked string
+		if wsS.acks != nil {
+			unacked = fmt.Sprintf(", %d items waiting for an ack", wsS.acks.len())
+		}
+		return fmt.Errorf(
+			"%d/%d websocket connections are reconnecting (%s), %d items buffered for replay%s",
+			len(down), len(wsS.endpoints), strings.Join(down, ", "), wsS.replay.len(), unacked,
+		)
+	}
+	return nil
+}
+
+// send writes the message to the first healthy endpoint and reports whether
+// it has been written. The endpoints are tried starting from the next one in
+// the cycle or, with a hash routing, from the one owning the message key.
+//
+// The avoid endpoint (if not nil) is only tried when no other endpoint is
+// able to receive the message.
+func (wsS *WebsocketSink) send(msg *wsMessage, avoid *wsEndpoint) bool {
+	for _, endpointIdx := range wsS.endpointsOrder(msg) {
+		endpoint := wsS.endpoints[endpointIdx]
+		if endpoint != avoid && wsS.sendTo(endpoint, msg) {
+			return true
+		}
+	}
+
+	return avoid != nil && wsS.sendTo(avoid, msg)
+}
+
+// endpointsOrder returns the indexes of the endpoints to try for the message, in order.
+func (wsS *WebsocketSink) endpointsOrder(msg *wsMessage) []int {
+	if endpoints := wsS.router.endpoints(msg.routingKey); endpoints != nil {
+		return endpoints
+	}
+
+	wsS.idxMu.Lock()
+	startIdx := wsS.currentEndpointIdx
+	wsS.currentEndpointIdx = (wsS.currentEndpointIdx + 1) % len(wsS.endpoints)
+	wsS.idxMu.Unlock()
+
+	endpoints := make([]int, len(wsS.endpoints))
+	for offset := range len(wsS.endpoints) {
+		endpoints[offset] = (startIdx + offset) % len(wsS.endpoints)
+	}
+	return endpoints
+}
+
+func (wsS *WebsocketSink) sendTo(endpoint *wsEndpoint, msg *wsMessage) bool {
+	conn := endpoint.currentConn()
+	if conn == nil {
+		return false
+	}
+
+	// the delivery is tracked before the write, as the consumer might ack it
+	// before WriteMessage returns
+	if wsS.acks != nil {
+		wsS.acks.track(msg, endpoint)
+	}
+
+	if err := conn.WriteMessage(websocket.TextMessage, msg.payload, wsWriteTimeout); err != nil {
+		if wsS.acks != nil {
+			wsS.acks.untrack(msg)
+		}
+		slog.Warn(fmt.Sprintf(
+			"(WebsocketSink): error sending item (ID %d) to websocket with url %s, reconnecting: %s",
+			msg.contentID, endpoint.url, err.Error(),
+		))
+		endpoint.fail(conn)
+		return false
+	}
+
+	return true
+}
+
+// ackLoop periodically retries the deliveries not acknowledged within the timeout.
+func (wsS *WebsocketSink) ackLoop() {
+	defer wsS.wg.Done()
+
+	ticker := time.NewTicker(max(wsS.acks.timeout/4, 100*time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-wsS.stopChan:
+			return
+		case <-ticker.C:
+			for _, delivery := range wsS.acks.expired() {
+				msg := delivery.msg
+
+				wsS.outcome.Mu.Lock()
+				wsS.outcome.AckTimeouts++
+				if delivery.attempts >= wsS.acks.maxAttempts {
+					wsS.outcome.AckDropped++
+				}
+				wsS.outcome.Mu.Unlock()
+
+				if delivery.attempts >= wsS.acks.maxAttempts {
+					slog.Error(fmt.Sprintf(
+						"(WebsocketSink): item (ID %d, delivery %d) dropped after %d unacknowledged attempts",
+						msg.contentID, msg.deliveryID, delivery.attempts,
+					))
+					continue
+				}
+
+				if !wsS.send(msg, delivery.endpoint) {
+					if dropped := wsS.replay.push(msg); dropped != nil {
+						slog.Error(fmt.Sprintf(
+							"(WebsocketSink): replay buffer full, item (ID %d) dropped", dropped.contentID,
+						))
+					}
+				}
+			}
+		}
+	}
+}
+
+// replayBuffered redelivers the buffered messages until the buffer is empty
+// or no endpoint is able to receive them. Only one replay runs at a time.
+//
+// With the strict routing a message can only be sent to the endpoint owning
+// its key, so the undeliverable messages are skipped (and buffered again)
+// instead of blocking the messages of the other endpoints.
+func (wsS *WebsocketSink) replayBuffered() {
+	if !wsS.replaying.CompareAndSwap(false, true) {
+		return
+	}
+	defer wsS.replaying.Store(false)
+
+	replayed := 0
+	var undelivered []*wsMessage
+	for {
+		msg, ok := wsS.replay.pop()
+		if !ok {
+			break
+		}
+		if !wsS.send(msg, nil) {
+			undelivered = append(undelivered, msg)
+			if wsS.router.strict {
+				continue
+			}
+			break
+		}
+		replayed++
+	}
+
+	for idx := len(undelivered) - 1; idx >= 0; idx-- {
+		if wsS.replay.pushFront(undelivered[idx]) {
+			slog.Error(fmt.Sprintf(
+				"(WebsocketSink): replay buffer full, item (ID %d) dropped", undelivered[idx].contentID,
+			))
+		}
+	}
+
+	if replayed > 0 {
+		slog.Info(fmt.Sprintf("(WebsocketSink): replayed %d buffered items", replayed))
+	}
+}
+
+// maintain keeps the endpoint connected until the sink is closed.
+// conn is the initial connection, or nil if the first dial failed.
+func (wsS *WebsocketSink) maintain(endpoint *wsEndpoint, conn *safews.SafeConn) {
+	defer wsS.wg.Done()
+
+	backoff := endpoint.initialBackoff
+	for {
+		if conn == nil {
+			select {
+			case <-wsS.stopChan:
+				return
+			case <-time.After(backoff):
+			}
+
+			var err error
+			conn, err = endpoint.dial()
+			if err != nil {
+				slog.Warn(fmt.Sprintf(
+					"(WebsocketSink): error reconnecting to websocket with url %s, retrying in %s: %s",
+					endpoint.url, min(backoff*2, endpoint.maxBackoff), err.Error(),
+				))
+				backoff = min(backoff*2, endpoint.maxBackoff)
+				continue
+			}
+			slog.Info(fmt.Sprintf("(WebsocketSink): reconnected to websocket with url: %s", endpoint.url))
+		}
+		backoff = endpoint.initialBackoff
+
+		endpoint.setConn(conn)
+		go wsS.readLoop(endpoint, conn)
+		go wsS.replayBuffered()
+
+		if stopped := endpoint.keepAlive(conn, wsS.stopChan); stopped {
+			conn.WriteClose(wsControlWriteTimeout)
+			endpoint.fail(conn)
+			return
+		}
+		conn = nil
+	}
+}
+
+func (ep *wsEndpoint) dial() (*safews.SafeConn, error) {
+	rawConn, _, err := ep.dialer.Dial(ep.url, *ep.headers.Load())
+	if err != nil {
+		return nil, err
+	}
+
+	conn := safews.NewSafeConn(rawConn)
+	if ep.pongTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(ep.pongTimeout))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(ep.pongTimeout))
+		})
+	} else {
+		conn.SetReadDeadline(time.Time{})
+	}
+
+	return conn, nil
+}
+
+// keepAlive pings the connection until it breaks or stopChan is closed.
+// It returns true if it stopped because of stopChan.
+func (ep *wsEndpoint) keepAlive(conn *safews.SafeConn, stopChan <-chan struct{}) (stopped bool) {
+	var pingChan <-chan time.Time
+	if ep.pingInterval > 0 {
+		ticker := time.NewTicker(ep.pingInterval)
+		defer ticker.Stop()
+		pingChan = ticker.C
+	}
+
+	for {
+		select {
+		case <-stopChan:
+			return true
+		case <-ep.broken:
+			return false
+		case <-pingChan:
+			if err := conn.WritePing(wsControlWriteTimeout); err != nil {
+				slog.Warn(fmt.Sprintf(
+					"(WebsocketSink): error pinging websocket with url %s, reconnecting: %s",
+					ep.url, err.Error(),
+				))
+				ep.fail(conn)
+			}
+		}
+	}
+}
+
+// readLoop reads the incoming messages, which is also required to process the
+// control messages (pongs and close frames) of the connection.
+// Acks are processed if enabled, any other message is discarded.
+// A read error (e.g. a missed pong deadline) marks the connection as broken.
+func (wsS *WebsocketSink) readLoop(endpoint *wsEndpoint, conn *safews.SafeConn) {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if endpoint.currentConn() == conn {
+				slog.Warn(fmt.Sprintf(
+					"(WebsocketSink): connection to websocket with url %s lost, reconnecting: %s",
+					endpoint.url, err.Error(),
+				))
+			}
+			endpoint.fail(conn)
+			return
+		}
+
+		if wsS.acks != nil {
+			if deliveryID, ok := parseAck(data); ok {
+				wsS.acks.ack(deliveryID)
+			}
+		}
+	}
+}
+
+func (ep *wsEndpoint) currentConn() *safews.SafeConn {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	return ep.conn
+}
+
+func (ep *wsEndpoint) setConn(conn *safews.SafeConn) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	ep.conn = conn
+}
+
+// fail closes conn and, if it is the current connection of the endpoint,
+// removes it and signals maintain to reconnect.
+// Calling fail multiple times for the same connection is safe.
+func (ep *wsEndpoint) fail(conn *safews.SafeConn) {
+	ep.mu.Lock()
+	if ep.conn != conn {
+		ep.mu.Unlock()
+		return
+	}
+	ep.conn = nil
+	ep.mu.Unlock()
+
+	// closed without holding the endpoint lock, so that the other goroutines
+	// can keep using the endpoint while the connection is being closed
+	conn.Close()
+
+	select {
+	case ep.broken <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,89 @@
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Renderer writes snapshots to a log.
+type Renderer interface {
+	Render(w io.Writer, snapshot *Snapshot) error
+}
+
+// NewRenderer returns the renderer of the format. An empty format is the text one.
+func NewRenderer(format string) (Renderer, error) {
+	switch format {
+	case "", FormatText:
+		return TextRenderer{}, nil
+	case FormatJSON:
+		return JSONRenderer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown status format %q", format)
+	}
+}
+
+// TextRenderer writes each snapshot as a human readable block.
+type TextRenderer struct{}
+
+func (TextRenderer) Render(w io.Writer, snapshot *Snapshot) error {
+	pools := make([]string, len(snapshot.Pools))
+	for idx, pool := range snapshot.Pools {
+		pools[idx] = fmt.Sprintf("%s %.2f%% (%d)", pool.Name, pool.Utilization*100, pool.Workers)
+	}
+
+	_, err := fmt.Fprintf(w,
+		snapshot.Time.Format("2006-01-02 15:04:05.0")+" STATUS\n"+
+			"Reqs: %d, Success: %.2f%%\n"+
+			"RateLimits (429): %d, NotFounds (404): %d, "+
+			"Unauthorized (401): %d, OtherErrs: %d\n"+
+			"Recovered from backup: %d, Lost from backup: %d\n"+
+			"Ack timeouts: %d, Ack dropped: %d, Late items: %d, Duplicates: %d\n"+
+			"Malformed items: %d, Worker crashes: %d\n"+
+			"BatchID: %d, HighestID: %d\n"+
+			"AvgThreshAmount: %.2f, AvgThreshOffset: %.2f\n"+
+			"AvgHitThreshLevel: %.2f, AvgDelay: %.2f\n"+
+			"Delay P50: %.0f, P90: %.0f, P99: %.0f, Max: %.0f\n"+
+			"Pools utilization: %s"+
+			"\n\n",
+		snapshot.Requests.Total, snapshot.Requests.SuccessRate,
+		snapshot.Requests.RateLimits, snapshot.Requests.NotFounds,
+		snapshot.Requests.Unauthorized, snapshot.Requests.OtherErrs,
+		snapshot.Backup.Recovered, snapshot.Backup.Lost,
+		snapshot.Delivery.AckTimeouts, snapshot.Delivery.AckDropped,
+		snapshot.Delivery.LateItems, snapshot.Delivery.Duplicates,
+		snapshot.MalformedItems, snapshot.WorkerCrashes,
+		snapshot.BatchID, snapshot.HighestID,
+		snapshot.ThresholdsAmount.Avg, snapshot.ThresholdsOffset.Avg,
+		snapshot.HitThresholdLevel.Avg, snapshot.Delays.Avg,
+		valueOrZero(snapshot.Delays.P50), valueOrZero(snapshot.Delays.P90),
+		valueOrZero(snapshot.Delays.P99), snapshot.Delays.Max,
+		strings.Join(pools, ", "),
+	)
+	return err
+}
+
+// JSONRenderer writes each snapshot as a JSON line.
+type JSONRenderer struct{}
+
+func (JSONRenderer) Render(w io.Writer, snapshot *Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func valueOrZero(value *float64) float64 {
+	if value == nil {
+		return 0
+	}
+	return *value
+}
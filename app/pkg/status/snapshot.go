@@ -0,0 +1,122 @@
+package status
+
+import (
+	"time"
+
+	"crawler/app/pkg/histogram"
+
+	"golang.org/x/exp/constraints"
+)
+
+// Snapshot is the state of the crawler over a status interval.
+// The counters only cover the interval, as they are reset after each snapshot.
+type Snapshot struct {
+	Time            time.Time `json:"time"`
+	IntervalSeconds float64   `json:"interval_seconds"`
+
+	Requests Requests `json:"requests"`
+	Backup   Backup   `json:"backup"`
+	Delivery Delivery `json:"delivery"`
+
+	// items fetched without the expected content and panics recovered in the workers
+	MalformedItems int `json:"malformed_items"`
+	WorkerCrashes  int `json:"worker_crashes"`
+
+	BatchID   uint16 `json:"batch_id"`
+	HighestID int    `json:"highest_id"`
+
+	// delays between the publication of the items and their fetch, in milliseconds
+	Delays            Summary `json:"delays_ms"`
+	ThresholdsAmount  Summary `json:"thresholds_amount"`
+	ThresholdsOffset  Summary `json:"thresholds_offset"`
+	HitThresholdLevel Summary `json:"hit_threshold_level"`
+
+	Pools []Pool `json:"pools"`
+}
+
+// Requests counts the item requests of the subordinate and thresholds workers by result.
+type Requests struct {
+	Total       int     `json:"total"`
+	SuccessRate float64 `json:"success_rate"`
+
+	Successes    int `json:"successes"`
+	NotFounds    int `json:"not_founds"`
+	RateLimits   int `json:"rate_limits"`
+	Unauthorized int `json:"unauthorized"`
+	OtherErrs    int `json:"other_errors"`
+}
+
+type Backup struct {
+	Recovered int `json:"recovered"`
+	Lost      int `json:"lost"`
+}
+
+type Delivery struct {
+	AckTimeouts int `json:"ack_timeouts"`
+	AckDropped  int `json:"ack_dropped"`
+	LateItems   int `json:"late_items"`
+	Duplicates  int `json:"duplicates"`
+}
+
+// Pool is the utilization of a pool of workers, i.e. the fraction of the
+// interval its workers have spent working on items instead of waiting for them.
+type Pool struct {
+	Name        string  `json:"name"`
+	Workers     int     `json:"workers"`
+	Utilization float64 `json:"utilization"`
+}
+
+// Summary describes a set of values. Percentiles are only set for the
+// summaries created with NewHistogramSummary.
+type Summary struct {
+	Count int     `json:"count"`
+	Avg   float64 `json:"avg"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+
+	P50 *float64 `json:"p50,omitempty"`
+	P90 *float64 `json:"p90,omitempty"`
+	P99 *float64 `json:"p99,omitempty"`
+}
+
+func NewSummary[T constraints.Integer | constraints.Float](values []T) Summary {
+	if len(values) == 0 {
+		return Summary{}
+	}
+
+	summary := Summary{
+		Count: len(values),
+		Min:   float64(values[0]),
+		Max:   float64(values[0]),
+	}
+	var sum float64
+	for _, value := range values {
+		sum += float64(value)
+		summary.Min = min(summary.Min, float64(value))
+		summary.Max = max(summary.Max, float64(value))
+	}
+	summary.Avg = sum / float64(len(values))
+
+	return summary
+}
+
+// NewHistogramSummary returns the summary of the values recorded in the histogram,
+// percentiles included.
+func NewHistogramSummary(h *histogram.Histogram) Summary {
+	if h.Count() == 0 {
+		return Summary{}
+	}
+
+	p50 := float64(h.Percentile(0.5))
+	p90 := float64(h.Percentile(0.9))
+	p99 := float64(h.Percentile(0.99))
+	return Summary{
+		Count: int(h.Count()),
+		Avg:   h.Mean(),
+		Min:   float64(h.Min()),
+		Max:   float64(h.Max()),
+		P50:   &p50,
+		P90:   &p90,
+		P99:   &p99,
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"sync"
 )
 
 type ThresholdsController struct {
@@ -13,13 +14,17 @@ type ThresholdsController struct {
 	// A set of configs that the controller will use in construction and to
 	// adjust the thresholds amount whenever a ThresholdsControllerInput is received.
 	cfg *ThresholdsControllerConfig
+
+	// Guards state, as it can be read from other goroutines (e.g. the admin API)
+	// while the controller is being updated.
+	mu sync.RWMutex
 }
 
 type thresholdsState struct {
 	// The amount of IDs thresholds the controller is currently managing.
 	//
 	// Initialized with cfg.InitialThresholdsAmount.
-	thresholdsAmount uint16
+	thresholdsAmount uint32
 
 	// The timestamp of the last input received.
 	// It is used to calculate the increment of the thresholds amount by comparing
@@ -32,7 +37,11 @@ type thresholdsState struct {
 type ThresholdsControllerConfig struct {
 	// The amount of thresholds the controller will start with.
 	// This value must be greater than 0.
-	InitialThresholdsAmount uint16
+	InitialThresholdsAmount uint32
+
+	// The amount of thresholds the controller will never exceed.
+	// This value must not be less than InitialThresholdsAmount, 0 means 2^32 - 1.
+	MaxThresholdsAmount uint32
 
 	// When the controller receives an input (Update is called) all policies are iterated until match.
 	// A match is reached when input.ThresholdLevel is higher than or equal to
@@ -50,7 +59,7 @@ type ThresholdsControllerConfig struct {
 	ThresholdsAdjustmentPolicies []*ThresholdsAdjustmentPolicy
 }
 
-type ComputeIncrementFunc func(currentTimestamp, newTimestamp uint32, thresholdsAmount uint16) int32
+type ComputeIncrementFunc func(currentTimestamp, newTimestamp uint32, thresholdsAmount uint32) int64
 
 type ThresholdsAdjustmentPolicy struct {
 	// The percentage of thresholdsAmount that must be less than or equal to
@@ -64,13 +73,15 @@ type ThresholdsAdjustmentPolicy struct {
 	// These 3 values can be used to calculate the increment based on the custom
 	// policy logic. The passed thresholdsAmount can be used to return percentages
 	// of it as the increment
-	// ( e.g. return int32(float32(thresholdsAmount) * 0.25) ).
+	// ( e.g. return int64(float64(thresholdsAmount) * 0.25) ).
+	//
+	// The resulting thresholdsAmount is clamped to the range [1, MaxThresholdsAmount].
 	ComputeIncrement ComputeIncrementFunc
 }
 
 type ThresholdsControllerInput struct {
 	// The level of the threshold that has been hit.
-	ThresholdLevel uint16
+	ThresholdLevel uint32
 
 	// The timestamp of the item that hit the threshold.
 	Timestamp uint32
@@ -84,18 +95,40 @@ func NewThresholdsController(cfg *ThresholdsControllerConfig) (*ThresholdsContro
 			cfg.InitialThresholdsAmount,
 		)
 	}
+	if cfg.MaxThresholdsAmount != 0 && cfg.MaxThresholdsAmount < cfg.InitialThresholdsAmount {
+		return nil, fmt.Errorf(
+			"MaxThresholdsAmount must not be less than InitialThresholdsAmount (%d), %d has been provided",
+			cfg.InitialThresholdsAmount, cfg.MaxThresholdsAmount,
+		)
+	}
+
+	if err := validatePolicies(cfg.ThresholdsAdjustmentPolicies); err != nil {
+		return nil, err
+	}
+
+	thresholdsController := &ThresholdsController{
+		cfg: cfg,
+		state: &thresholdsState{
+			thresholdsAmount: cfg.InitialThresholdsAmount,
+			currentTimestamp: math.MaxUint32,
+		},
+	}
+
+	return thresholdsController, nil
+}
 
+func validatePolicies(policies []*ThresholdsAdjustmentPolicy) error {
 	// Validate that at least one policy has been provided.
-	if len(cfg.ThresholdsAdjustmentPolicies) == 0 {
-		return nil, errors.New("no ThresholdsAdjustmentPolicy provided, at least one is required")
+	if len(policies) == 0 {
+		return errors.New("no ThresholdsAdjustmentPolicy provided, at least one is required")
 	}
 
 	// Validate that all policies percentages are in the range [0, 1]
 	// and at least one policy has a percentage of 0.
 	foundZero := false
-	for idx, policy := range cfg.ThresholdsAdjustmentPolicies {
+	for idx, policy := range policies {
 		if policy.Percentage < 0 || policy.Percentage > 1 {
-			return nil, fmt.Errorf(
+			return fmt.Errorf(
 				"policy percentages must be in the range [0, 1]."+
 					"policy N. %d has a percentage of %f",
 				idx, policy.Percentage,
@@ -106,18 +139,9 @@ func NewThresholdsController(cfg *ThresholdsControllerConfig) (*ThresholdsContro
 		}
 	}
 	if !foundZero {
-		return nil, errors.New("at least one policy must have a percentage of 0")
+		return errors.New("at least one policy must have a percentage of 0")
 	}
-
-	thresholdsController := &ThresholdsController{
-		cfg: cfg,
-		state: &thresholdsState{
-			thresholdsAmount: cfg.InitialThresholdsAmount,
-			currentTimestamp: math.MaxUint32,
-		},
-	}
-
-	return thresholdsController, nil
+	return nil
 }
 
 // When this function is called all policies are iterated until match.
@@ -130,18 +154,20 @@ func NewThresholdsController(cfg *ThresholdsControllerConfig) (*ThresholdsContro
 //
 // See ThresholdsAdjustmentPolicy for more information about the policies.
 func (tc *ThresholdsController) Update(input *ThresholdsControllerInput) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
 	// This approach implements the strategy pattern, granting scalability and flexibility.
 	for _, policy := range tc.cfg.ThresholdsAdjustmentPolicies {
-		minMatchingLevel := policy.Percentage * float32(tc.state.thresholdsAmount)
+		// float64 keeps the product exact for any uint32 amount
+		minMatchingLevel := float64(policy.Percentage) * float64(tc.state.thresholdsAmount)
 
-		if input.ThresholdLevel >= uint16(math.Ceil(float64(minMatchingLevel))) {
+		if input.ThresholdLevel >= uint32(math.Ceil(minMatchingLevel)) {
 			increment := policy.ComputeIncrement(
 				tc.state.currentTimestamp, input.Timestamp, tc.state.thresholdsAmount,
 			)
 
-			tc.state.thresholdsAmount = uint16(
-				max(1, int32(tc.state.thresholdsAmount)+increment),
-			)
+			tc.state.thresholdsAmount = addIncrement(tc.state.thresholdsAmount, increment, tc.maxThresholdsAmount())
 
 			break
 		}
@@ -150,13 +176,67 @@ func (tc *ThresholdsController) Update(input *ThresholdsControllerInput) {
 	tc.state.currentTimestamp = input.Timestamp
 }
 
+// addIncrement returns amount + increment clamped to the range [1, maxAmount].
+func addIncrement(amount uint32, increment int64, maxAmount uint32) uint32 {
+	// with the increment clamped, the sum fits in 34 bits and cannot overflow
+	increment = min(max(increment, -math.MaxUint32), math.MaxUint32)
+	return uint32(min(max(int64(amount)+increment, 1), int64(maxAmount)))
+}
+
+// maxThresholdsAmount must be called with the lock held.
+func (tc *ThresholdsController) maxThresholdsAmount() uint32 {
+	if tc.cfg.MaxThresholdsAmount == 0 {
+		return math.MaxUint32
+	}
+	return tc.cfg.MaxThresholdsAmount
+}
+
+// SetPolicies replaces the adjustment policies of the controller, keeping its state.
+// The policies are validated as in NewThresholdsController.
+func (tc *ThresholdsController) SetPolicies(policies []*ThresholdsAdjustmentPolicy) error {
+	if err := validatePolicies(policies); err != nil {
+		return err
+	}
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	tc.cfg = &ThresholdsControllerConfig{
+		InitialThresholdsAmount:      tc.cfg.InitialThresholdsAmount,
+		MaxThresholdsAmount:          tc.cfg.MaxThresholdsAmount,
+		ThresholdsAdjustmentPolicies: policies,
+	}
+	return nil
+}
+
+// Restore overrides the state of the controller with a previously saved one
+// (see GetThresholdsAmount and GetCurrentTimestamp).
+// This is useful to resume a crawl without starting the adjustment from scratch.
+// The restored amount is clamped to MaxThresholdsAmount.
+func (tc *ThresholdsController) Restore(thresholdsAmount uint32, currentTimestamp uint32) error {
+	if thresholdsAmount == 0 {
+		return errors.New("the restored thresholds amount must be greater than 0")
+	}
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	tc.state.thresholdsAmount = min(thresholdsAmount, tc.maxThresholdsAmount())
+	tc.state.currentTimestamp = currentTimestamp
+	return nil
+}
+
 // Return the current thresholds amount of the controller.
 // This value is always greater than 0.
-func (tc *ThresholdsController) GetThresholdsAmount() uint16 {
+func (tc *ThresholdsController) GetThresholdsAmount() uint32 {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
 	return tc.state.thresholdsAmount
 }
 
 // Return the current timestamp of the controller.
 func (tc *ThresholdsController) GetCurrentTimestamp() uint32 {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
 	return tc.state.currentTimestamp
 }
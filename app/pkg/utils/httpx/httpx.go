@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"crawler/app/pkg/assert"
+	customerrors "crawler/app/pkg/custom-types/custom-errors"
 
 	utls "github.com/refraction-networking/utls"
 	"golang.org/x/net/http2"
@@ -45,7 +46,15 @@ func BuildRequest(
 	return req, nil
 }
 
+// the timeout of the TCP connection to a proxy
+const proxyDialTimeout = 5 * time.Second
+
 // TODO: add docs (specify that this only works with HTTP2 if HTTPS)
+//
+// The proxy is either an http proxy, tunneling the https requests with CONNECT,
+// or a socks5 one (see dialSOCKS5Tunnel), tunneling all the requests.
+// The failures of the proxy are wrapped in customerrors.ErrorProxyConnect and
+// customerrors.ErrorTLSHandshake, so that they can be told apart from the ones of the server.
 func MakeRequestWithProxyAndFingerprint(
 	req *http.Request,
 	cookieJar http.CookieJar,
@@ -58,18 +67,20 @@ func MakeRequestWithProxyAndFingerprint(
 	if req.URL.Scheme == "https" {
 		transport = &http2.Transport{
 			DialTLSContext: func(ctx context.Context, network string, addr string, cfg *tls.Config) (net.Conn, error) {
-				proxyAuth := ""
-				if proxyUrl.User != nil {
-					password, _ := proxyUrl.User.Password()
-					proxyAuth = fmt.Sprintf("%s:%s", proxyUrl.User.Username(), password)
-				}
-
-				return dialWithUTLS(addr, proxyUrl.Host, proxyAuth, utlsProfile)
+				return dialWithUTLS(ctx, addr, proxyUrl, utlsProfile)
+			},
+		}
+	} else if proxyUrl.Scheme == "socks5" {
+		transport = &http.Transport{
+			DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+				return dialSOCKS5Tunnel(ctx, addr, proxyUrl)
 			},
 		}
 	} else {
 		transport = &http.Transport{
 			Proxy: http.ProxyURL(proxyUrl),
+			// the connections are opened to the proxy
+			DialContext: dialProxy,
 		}
 	}
 
@@ -82,12 +93,108 @@ func MakeRequestWithProxyAndFingerprint(
 	return client.Do(req)
 }
 
-func dialWithUTLS(targetAddr string, proxyHost string, proxyAuth string, utlsProfile *utls.ClientHelloID) (*utls.UConn, error) {
+// ProbeProxy checks that proxyUrl can be used to send requests to the host of targetUrl:
+// for https, that a tunnel can be opened and that the TLS handshake succeeds over it,
+// for http, that a tunnel can be opened through the socks5 proxies and that the http
+// proxies can be reached. It returns the same errors as the requests.
+func ProbeProxy(
+	ctx context.Context,
+	proxyUrl *url.URL,
+	targetUrl *url.URL,
+	utlsProfile *utls.ClientHelloID,
+	timeout int,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, (time.Duration)(timeout)*time.Second)
+	defer cancel()
+
+	var conn net.Conn
+	var err error
+	if targetUrl.Scheme == "https" {
+		port := targetUrl.Port()
+		if port == "" {
+			port = "443"
+		}
+		conn, err = dialWithUTLS(ctx, net.JoinHostPort(targetUrl.Hostname(), port), proxyUrl, utlsProfile)
+	} else if proxyUrl.Scheme == "socks5" {
+		port := targetUrl.Port()
+		if port == "" {
+			port = "80"
+		}
+		conn, err = dialSOCKS5Tunnel(ctx, net.JoinHostPort(targetUrl.Hostname(), port), proxyUrl)
+	} else {
+		conn, err = dialProxy(ctx, "tcp", proxyUrl.Host)
+	}
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// proxyAuth returns the "user:password" credentials of proxyUrl, empty if it has none.
+func proxyAuth(proxyUrl *url.URL) string {
+	if proxyUrl.User == nil {
+		return ""
+	}
+	password, _ := proxyUrl.User.Password()
+	return fmt.Sprintf("%s:%s", proxyUrl.User.Username(), password)
+}
+
+// dialProxy opens a TCP connection to the proxy at proxyHost.
+func dialProxy(ctx context.Context, network string, proxyHost string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: proxyDialTimeout}
+	conn, err := dialer.DialContext(ctx, network, proxyHost)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to open TCP connection to proxy: %v", customerrors.ErrorProxyConnect, err)
+	}
+	return conn, nil
+}
+
+// dialWithUTLS opens a tunnel to targetAddr through proxyUrl and performs
+// the TLS handshake over it with the utlsProfile fingerprint.
+func dialWithUTLS(
+	ctx context.Context,
+	targetAddr string,
+	proxyUrl *url.URL,
+	utlsProfile *utls.ClientHelloID,
+) (*utls.UConn, error) {
+	var conn net.Conn
+	var err error
+	if proxyUrl.Scheme == "socks5" {
+		conn, err = dialSOCKS5Tunnel(ctx, targetAddr, proxyUrl)
+	} else {
+		conn, err = dialHTTPTunnel(ctx, targetAddr, proxyUrl.Host, proxyAuth(proxyUrl))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Setup the connection to use the uTLS profile fingerprint
+	//
+	// Remove the port from targetAddr to use it as ServerName
+	tlsConfig := &utls.Config{ServerName: strings.Split(targetAddr, ":")[0]}
+	utlsConn := utls.UClient(conn, tlsConfig, *utlsProfile)
+
+	// Perform the TLS handshake
+	if err := utlsConn.HandshakeContext(ctx); err != nil {
+		utlsConn.Close()
+		return nil, fmt.Errorf("%w: TLS handshake failed: %v", customerrors.ErrorTLSHandshake, err)
+	}
+
+	return utlsConn, nil
+}
+
+// dialHTTPTunnel opens a TCP connection to targetAddr through the http proxy at proxyHost, with CONNECT.
+func dialHTTPTunnel(ctx context.Context, targetAddr string, proxyHost string, proxyAuth string) (net.Conn, error) {
 	// Open a low level raw TCP connection to the proxy server
 	// All data sent through this connection will be routed through the proxy
-	conn, err := net.DialTimeout("tcp", proxyHost, 5*time.Second)
+	conn, err := dialProxy(ctx, "tcp", proxyHost)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open TCP connection to proxy: %v", err)
+		return nil, err
+	}
+
+	// a proxy accepting the connection without answering must not hang the request
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
 	}
 
 	// fmt.Fprintf writes to the network buffer, which will be flushed to the proxy server
@@ -106,26 +213,20 @@ func dialWithUTLS(targetAddr string, proxyHost string, proxyAuth string, utlsPro
 	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
 	if err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("failed to open TCP connection between proxy and target: %v", err)
+		return nil, fmt.Errorf("%w: failed to open TCP connection between proxy and target: %v", customerrors.ErrorProxyConnect, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		conn.Close()
-		return nil, fmt.Errorf("failed to open TCP connection between proxy and target due to non-200 status code: %d", resp.StatusCode)
+		return nil, fmt.Errorf(
+			"%w: failed to open TCP connection between proxy and target due to non-200 status code: %d",
+			customerrors.ErrorProxyConnect, resp.StatusCode,
+		)
 	}
 
-	// Setup the connection to use the uTLS profile fingerprint
-	//
-	// Remove the port from targetAddr to use it as ServerName
-	tlsConfig := &utls.Config{ServerName: strings.Split(targetAddr, ":")[0]}
-	utlsConn := utls.UClient(conn, tlsConfig, *utlsProfile)
-
-	// Perform the TLS handshake
-	if err := utlsConn.Handshake(); err != nil {
-		utlsConn.Close()
-		return nil, fmt.Errorf("TLS handshake failed: %v", err)
-	}
+	// the TLS handshake is bound to ctx, the connection is then bound to the requests
+	conn.SetDeadline(time.Time{})
 
-	return utlsConn, nil
+	return conn, nil
 }
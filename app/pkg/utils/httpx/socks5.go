@@ -0,0 +1,35 @@
+package httpx
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+
+	customerrors "crawler/app/pkg/custom-types/custom-errors"
+
+	"golang.org/x/net/proxy"
+)
+
+// dialSOCKS5Tunnel opens a TCP connection to targetAddr through the socks5 proxy at proxyUrl,
+// authenticating with its username and password if it has some.
+// The target host is resolved by the proxy.
+func dialSOCKS5Tunnel(ctx context.Context, targetAddr string, proxyUrl *url.URL) (net.Conn, error) {
+	var auth *proxy.Auth
+	if proxyUrl.User != nil {
+		password, _ := proxyUrl.User.Password()
+		auth = &proxy.Auth{User: proxyUrl.User.Username(), Password: password}
+	}
+
+	dialer, err := proxy.SOCKS5("tcp", proxyUrl.Host, auth, &net.Dialer{Timeout: proxyDialTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to create socks5 dialer: %v", customerrors.ErrorProxyConnect, err)
+	}
+
+	// the socks5 dialer binds the handshake with the proxy to ctx
+	conn, err := dialer.(proxy.ContextDialer).DialContext(ctx, "tcp", targetAddr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to open socks5 tunnel between proxy and target: %v", customerrors.ErrorProxyConnect, err)
+	}
+	return conn, nil
+}